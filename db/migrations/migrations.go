@@ -1,6 +1,9 @@
 package migrations
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3" //nolint
 	_ "github.com/golang-migrate/migrate/v4/source/file"      //nolint
@@ -9,6 +12,44 @@ import (
 
 // DoMigrateDb performs DB migrations.
 func DoMigrateDb(dbURL string) error {
+	m, err := newMigrate(dbURL)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the schema of the DB and if the
+// migration to it failed part way. A DB without a schema is version 0.
+func SchemaVersion(dbURL string) (uint, bool, error) {
+	m, err := newMigrate(dbURL)
+	if err != nil {
+		return 0, false, err
+	}
+	defer m.Close()
+	version, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// LatestVersion returns the version of the schema DoMigrateDb migrates to.
+func LatestVersion() uint {
+	latest := uint(0)
+	for _, name := range AssetNames() {
+		if v, err := strconv.ParseUint(strings.SplitN(name, "_", 2)[0], 10, 0); err == nil && uint(v) > latest {
+			latest = uint(v)
+		}
+	}
+	return latest
+}
+
+func newMigrate(dbURL string) (*migrate.Migrate, error) {
 	resources := bindata.Resource(AssetNames(),
 		func(name string) ([]byte, error) {
 			return Asset(name)
@@ -16,16 +57,8 @@ func DoMigrateDb(dbURL string) error {
 
 	migrationData, err := bindata.WithInstance(resources)
 	if err != nil {
-		return err
-	}
-
-	m, err := migrate.NewWithSourceInstance("go-bindata", migrationData, dbURL)
-	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return err
-	}
-	return nil
+	return migrate.NewWithSourceInstance("go-bindata", migrationData, dbURL)
 }
@@ -0,0 +1,165 @@
+// Package bmff reads boxes from ISO base media files such as MP4, QuickTime
+// MOV, HEIF and Canon CR3.
+package bmff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrBoxNotFound is returned when a box path does not exist.
+	ErrBoxNotFound = errors.New("box not found")
+)
+
+// Box is a single box header within a file.
+type Box struct {
+	// Type is the four character box type (eg; moov).
+	Type string
+
+	// UserType is set for boxes with a type of uuid.
+	UserType [16]byte
+
+	// Offset is the offset of the box header from the start of the file.
+	Offset int64
+
+	// DataOffset is the offset of the box payload from the start of the file.
+	DataOffset int64
+
+	// Size is the size of the box including its header.
+	Size int64
+}
+
+// End returns the offset of the first byte after the box.
+func (b Box) End() int64 {
+	return b.Offset + b.Size
+}
+
+// DataSize returns the size of the box payload.
+func (b Box) DataSize() int64 {
+	return b.End() - b.DataOffset
+}
+
+// ReadBox reads the box header found at offset. end is the offset of the end
+// of the parent box (or the file size) and is used for boxes that extend to
+// the end of their container.
+func ReadBox(r io.ReaderAt, offset, end int64) (Box, error) {
+	buf := make([]byte, 16)
+	if _, err := r.ReadAt(buf[:8], offset); err != nil {
+		return Box{}, err
+	}
+
+	b := Box{
+		Type:       string(buf[4:8]),
+		Offset:     offset,
+		DataOffset: offset + 8,
+		Size:       int64(binary.BigEndian.Uint32(buf[0:4])),
+	}
+
+	switch b.Size {
+	case 0:
+		b.Size = end - offset
+	case 1:
+		if _, err := r.ReadAt(buf[8:16], offset+8); err != nil {
+			return Box{}, err
+		}
+		b.Size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		b.DataOffset += 8
+	}
+
+	if b.Type == "uuid" {
+		if _, err := r.ReadAt(b.UserType[:], b.DataOffset); err != nil {
+			return Box{}, err
+		}
+		b.DataOffset += 16
+	}
+
+	if b.Size < b.DataOffset-offset || b.End() > end {
+		return Box{}, fmt.Errorf("invalid %s box size %d at offset %d", b.Type, b.Size, offset)
+	}
+
+	return b, nil
+}
+
+// ReadBoxes returns the boxes found between start and end.
+func ReadBoxes(r io.ReaderAt, start, end int64) ([]Box, error) {
+	boxes := []Box{}
+	for offset := start; offset+8 <= end; {
+		b, err := ReadBox(r, offset, end)
+		if err != nil {
+			return boxes, err
+		}
+		boxes = append(boxes, b)
+		offset = b.End()
+	}
+	return boxes, nil
+}
+
+// Find descends through the boxes between start and end following path and
+// returns the last box in the path.
+func Find(r io.ReaderAt, start, end int64, path ...string) (Box, error) {
+	var found Box
+	for i, t := range path {
+		boxes, err := ReadBoxes(r, start, end)
+		if err != nil && len(boxes) == 0 {
+			return Box{}, err
+		}
+
+		ok := false
+		for _, b := range boxes {
+			if b.Type == t {
+				found, ok = b, true
+				break
+			}
+		}
+		if !ok {
+			return Box{}, fmt.Errorf("%s: %w", t, ErrBoxNotFound)
+		}
+
+		start, end = ChildrenOffset(r, found), found.End()
+		if i == len(path)-1 {
+			break
+		}
+	}
+	return found, nil
+}
+
+// FindUUID returns the first box of type uuid with the given user type found
+// between start and end.
+func FindUUID(r io.ReaderAt, start, end int64, userType [16]byte) (Box, error) {
+	boxes, err := ReadBoxes(r, start, end)
+	if err != nil && len(boxes) == 0 {
+		return Box{}, err
+	}
+	for _, b := range boxes {
+		if b.Type == "uuid" && b.UserType == userType {
+			return b, nil
+		}
+	}
+	return Box{}, fmt.Errorf("uuid %x: %w", userType, ErrBoxNotFound)
+}
+
+// ChildrenOffset returns the offset of the first child box of b. ISO meta
+// boxes carry a 4 byte version and flags field before their children while
+// QuickTime meta boxes do not, so the field is skipped only when it is zero.
+func ChildrenOffset(r io.ReaderAt, b Box) int64 {
+	if b.Type != "meta" {
+		return b.DataOffset
+	}
+	buf := make([]byte, 4)
+	if _, err := r.ReadAt(buf, b.DataOffset); err == nil && binary.BigEndian.Uint32(buf) == 0 {
+		return b.DataOffset + 4
+	}
+	return b.DataOffset
+}
+
+// Brand returns the major brand from the ftyp box at the start of head. An
+// empty string is returned if head does not start with a ftyp box.
+func Brand(head []byte) string {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return ""
+	}
+	return string(head[8:12])
+}
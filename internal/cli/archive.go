@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/geo"
+	"pt/internal/model"
+	"pt/internal/movelog"
+	"pt/internal/store"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// uniqueDestinationFilePath returns the destination of f for timestamp, made
+// unique with uniqueFilePath.
+func uniqueDestinationFilePath(f file.File, destinationDir, deviceName string, timestamp time.Time, taken map[string]bool) (string, error) {
+	return uniqueFilePath(f.OriginalFilePath, func(opts ...file.Option) string {
+		return f.DestinationFilePath(destinationDir, deviceName, timestamp, opts...)
+	}, taken)
+}
+
+// uniqueFilePath returns the path given by name for src, or with a numbered
+// suffix set by the file.WithFilenameSuffix option passed to name if it, or
+// the destination of one of the sidecars of src, already exists or is in
+// taken. If taken isn't nil the destinations returned are added to it.
+func uniqueFilePath(src string, name func(opts ...file.Option) string, taken map[string]bool) (string, error) {
+	sidecars, err := file.Sidecars(src)
+	if err != nil {
+		return "", err
+	}
+
+	free := func(p string) bool {
+		_, err := os.Lstat(p)
+		return os.IsNotExist(err) && !taken[p]
+	}
+	destinationFilePath := name()
+	for i := 1; ; i++ {
+		destinations := []string{destinationFilePath}
+		for _, sidecar := range sidecars {
+			destinations = append(destinations, file.SidecarDestination(src, sidecar, destinationFilePath))
+		}
+		ok := true
+		for _, p := range destinations {
+			ok = ok && free(p)
+		}
+		if ok {
+			if taken != nil {
+				for _, p := range destinations {
+					taken[p] = true
+				}
+			}
+			return destinationFilePath, nil
+		}
+		destinationFilePath = name(file.WithFilenameSuffix(strconv.Itoa(i)))
+	}
+}
+
+// moveWithSidecars moves src to dst along with its sidecars, which are
+// renamed to match dst. The moves are recorded in log if it isn't nil, along
+// with meta, the meta values of the file before the move. Nothing is moved
+// if any of the destinations exists, and the files already moved are moved
+// back if a move fails.
+func moveWithSidecars(log *movelog.Log, src, dst string, meta map[string]*string) error {
+	move := func(m movelog.Move) error {
+		if log == nil {
+			return fileutil.Move(m.Src, m.Dst)
+		}
+		return log.Move(m.Src, m.Dst, m.Meta)
+	}
+
+	sidecars, err := file.Sidecars(src)
+	if err != nil {
+		return err
+	}
+	moves := []movelog.Move{{Src: src, Dst: dst, Meta: meta}}
+	for _, sidecar := range sidecars {
+		moves = append(moves, movelog.Move{Src: sidecar, Dst: file.SidecarDestination(src, sidecar, dst)})
+	}
+
+	for _, m := range moves {
+		if _, err := os.Lstat(m.Dst); err == nil {
+			return fmt.Errorf("%s: %w", m.Dst, fileutil.ErrFileExists)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for i, m := range moves {
+		if err := move(m); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if backErr := move(movelog.Move{Src: moves[j].Dst, Dst: moves[j].Src}); backErr != nil {
+					return fmt.Errorf("%w, moving %s back: %v", err, moves[j].Dst, backErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// moveRecorded moves src to dst with moveWithSidecars and records the move in
+// the database with record, in a transaction committed once the files are
+// moved. The files are moved back if the move can't be recorded.
+func moveRecorded(ctx context.Context, db *sql.DB, log *movelog.Log, src, dst string, meta map[string]*string, record func(exec boil.ContextExecutor) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := record(tx); err != nil {
+		return err
+	}
+	if err := moveWithSidecars(log, src, dst, meta); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		if backErr := moveWithSidecars(log, dst, src, nil); backErr != nil {
+			return fmt.Errorf("%w, moving %s back: %v", err, dst, backErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, but not including, stop
+// while they are empty.
+func removeEmptyDirs(dir, stop string) {
+	stop = filepath.Clean(stop)
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, stop+string(os.PathSeparator)); dir = filepath.Dir(dir) {
+		// Remove fails if the directory isn't empty.
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// archivedFile is a file recorded in the hash table along with its meta
+// values.
+type archivedFile struct {
+	hash *model.Hash
+	meta map[string]string
+}
+
+// previousMeta returns the values af has for the keys of meta, for recording
+// in a move log. Keys af has no value for are nil.
+func (af archivedFile) previousMeta(meta map[string]string) map[string]*string {
+	previous := map[string]*string{}
+	for k := range meta {
+		if v, ok := af.meta[k]; ok {
+			previous[k] = &v
+		} else {
+			previous[k] = nil
+		}
+	}
+	return previous
+}
+
+// loadArchivedFiles returns every file recorded in the hash table.
+func loadArchivedFiles(ctx context.Context, db *sql.DB) ([]archivedFile, error) {
+	hashes, err := model.Hashes().All(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := store.AllMeta(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []archivedFile{}
+	for _, h := range hashes {
+		m := meta[h.ID]
+		if m == nil {
+			m = map[string]string{}
+		}
+		files = append(files, archivedFile{hash: h, meta: m})
+	}
+	return files, nil
+}
+
+// timestamp returns the recorded capture time of the file.
+func (a archivedFile) timestamp() (time.Time, bool) {
+	t, err := time.Parse(store.TimeFormat, a.meta[store.MetaTimestamp])
+	return t, err == nil
+}
+
+// location returns the recorded location of the file, nil if it has none.
+func (a archivedFile) location() *geo.Point {
+	lat, err := strconv.ParseFloat(a.meta[store.MetaLatitude], 64)
+	if err != nil {
+		return nil
+	}
+	lon, err := strconv.ParseFloat(a.meta[store.MetaLongitude], 64)
+	if err != nil {
+		return nil
+	}
+	return &geo.Point{Latitude: lat, Longitude: lon}
+}
+
+// recordedMeta returns the meta values of the file at relPath, or nil if
+// the file isn't recorded in the hash table.
+func recordedMeta(ctx context.Context, db *sql.DB, relPath string) (map[string]string, error) {
+	hash, err := store.FindHashByFilepath(ctx, db, relPath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store.Meta(ctx, db, hash.ID)
+}
+
+// deviceAlbum returns the device name and album of the file recorded by copy,
+// falling back to those in its path, which is parsed with pathLayout.
+func (a archivedFile) deviceAlbum(pathLayout *template.Template) (string, string, bool) {
+	if deviceName, ok := a.meta[store.MetaDevice]; ok {
+		return deviceName, a.meta[store.MetaAlbum], true
+	}
+	archivePath, ok := file.ParseArchivePath(a.hash.Filepath, pathLayout)
+	return archivePath.DeviceName, archivePath.Album, ok
+}
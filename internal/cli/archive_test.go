@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/db/migrations"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// openTestDB returns a new database in a temporary directory and its path.
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "pt.db")
+	require.NoError(t, migrations.DoMigrateDb(fmt.Sprintf("sqlite3://%s", p)))
+	db, err := sql.Open("sqlite3", p)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db, p
+}
+
+// writeFiles writes an empty file at each of paths.
+func writeFiles(t *testing.T, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(filepath.Base(p)), 0644))
+	}
+}
+
+func TestUniqueDestinationFilePath(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "import", "IMG_0001.JPG")
+	writeFiles(t, src, filepath.Join(dir, "import", "IMG_0001.xmp"))
+	timestamp := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	f := file.NewFile(src, nil)
+	photos := filepath.Join(dir, "photos")
+	want := filepath.Join(photos, "2022", "01", "camera", "import", "20220102-030405000.JPG")
+
+	got, err := uniqueDestinationFilePath(f, photos, "camera", timestamp, nil)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// The sidecar destination is taken, the file gets a suffix.
+	writeFiles(t, filepath.Join(filepath.Dir(want), "20220102-030405000.xmp"))
+	got, err = uniqueDestinationFilePath(f, photos, "camera", timestamp, nil)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(filepath.Dir(want), "20220102-030405000-1.JPG"), got)
+
+	// The file and sidecar destinations are added to taken.
+	taken := map[string]bool{}
+	got, err = uniqueDestinationFilePath(f, photos, "camera", timestamp, taken)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{got: true, filepath.Join(filepath.Dir(want), "20220102-030405000-1.xmp"): true}, taken)
+	got, err = uniqueDestinationFilePath(f, photos, "camera", timestamp, taken)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(filepath.Dir(want), "20220102-030405000-2.JPG"), got)
+}
+
+func TestMoveWithSidecars(t *testing.T) {
+	dir := t.TempDir()
+	src, sidecar := filepath.Join(dir, "a", "IMG_0001.JPG"), filepath.Join(dir, "a", "IMG_0001.xmp")
+	dst := filepath.Join(dir, "b", "IMG_0002.JPG")
+	writeFiles(t, src, sidecar, filepath.Join(dir, "b", "IMG_0002.xmp"))
+
+	// The sidecar destination exists, nothing is moved.
+	err := moveWithSidecars(nil, src, dst, nil)
+	assert.True(t, errors.Is(err, fileutil.ErrFileExists))
+	assert.FileExists(t, src)
+	assert.FileExists(t, sidecar)
+	assert.NoFileExists(t, dst)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "b", "IMG_0002.xmp")))
+	require.NoError(t, moveWithSidecars(nil, src, dst, nil))
+	assert.NoFileExists(t, src)
+	assert.NoFileExists(t, sidecar)
+	assert.FileExists(t, dst)
+	assert.FileExists(t, filepath.Join(dir, "b", "IMG_0002.xmp"))
+}
+
+func TestMoveRecorded(t *testing.T) {
+	ctx := context.Background()
+	db, _ := openTestDB(t)
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a", "IMG_0001.JPG"), filepath.Join(dir, "b", "IMG_0001.JPG")
+	writeFiles(t, src, filepath.Join(dir, "a", "IMG_0001.xmp"))
+
+	// The move isn't recorded, the file isn't moved.
+	err := moveRecorded(ctx, db, nil, src, dst, nil, func(exec boil.ContextExecutor) error {
+		if _, err := store.InsertHash(ctx, exec, "b/IMG_0001.JPG", "hash"); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	assert.Error(t, err)
+	assert.FileExists(t, src)
+	_, err = store.FindHashByFilepath(ctx, db, "b/IMG_0001.JPG")
+	assert.True(t, errors.Is(err, sql.ErrNoRows))
+
+	// The file isn't moved, the database isn't changed.
+	writeFiles(t, filepath.Join(dir, "b", "IMG_0001.xmp"))
+	err = moveRecorded(ctx, db, nil, src, dst, nil, func(exec boil.ContextExecutor) error {
+		_, err := store.InsertHash(ctx, exec, "b/IMG_0001.JPG", "hash")
+		return err
+	})
+	assert.True(t, errors.Is(err, fileutil.ErrFileExists))
+	_, err = store.FindHashByFilepath(ctx, db, "b/IMG_0001.JPG")
+	assert.True(t, errors.Is(err, sql.ErrNoRows))
+}
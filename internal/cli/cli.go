@@ -1,13 +1,19 @@
 package cli
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
+	"pt/internal/eventstream"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/geo"
+	"pt/internal/summary"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -18,22 +24,155 @@ type cli struct {
 	initOnce   sync.Once
 	errOnce    error
 	config     config
+
+	// profile is the profile of the config file applied to config.
+	// fileConfig is the config file alone, settings are where each
+	// setting of config came from.
+	profile    string
+	fileConfig config
+	settings   configSettings
+
+	// output is how the summary of the run is written, text or json.
+	output string
+
+	// events is the stream of events written to eventsFile, it is nil if
+	// no events are written.
+	eventsFile   string
+	events       *eventstream.Stream
+	eventsCloser io.Closer
+
+	// report is filled in by commands for --output json.
+	report summary.Report
+
+	// log sets where log messages go, logCloser is the --log-file.
+	log       logFlags
+	logCloser io.Closer
+
+	// interruption is the signal stopping the running command.
+	interruption interruption
 }
 
 type config struct {
-	DBFile            string              `json:"db_file"`
-	SourceDir         string              `json:"source_dir"`
-	DestinationDir    string              `json:"destination_dir"`
-	DeviceNames       map[string][]string `json:"device_names"`
+	DBFile          string              `json:"db_file"`
+	SourceDir       string              `json:"source_dir"`
+	DestinationDir  string              `json:"destination_dir"`
+	DeviceNames     map[string][]string `json:"device_names"`
+	DeviceRules     []deviceRule        `json:"device_rules,omitempty"`
+	FallbackDevice  string              `json:"fallback_device_name,omitempty"`
+	DeviceTimezones map[string]string   `json:"device_timezones,omitempty"`
+	ClockOffsets    []clockOffset       `json:"clock_offsets,omitempty"`
+	PathLayout      string              `json:"path_layout,omitempty"`
+	GazetteerFile   string              `json:"gazetteer_file,omitempty"`
+	Admin1File      string              `json:"gazetteer_admin1_file,omitempty"`
+	RetryAttempts   int                 `json:"retry_attempts,omitempty"`
+	RetryBackoff    string              `json:"retry_backoff,omitempty"`
+	Jobs            *jobsConfig         `json:"jobs,omitempty"`
+	LogMaxSize      string              `json:"log_max_size,omitempty"`
+	LogMaxFiles     int                 `json:"log_max_files,omitempty"`
+	Sources         []sourceConfig      `json:"sources,omitempty"`
+
+	// Profiles are named sets of settings applied over the others with
+	// --profile.
+	Profiles map[string]map[string]interface{} `json:"profiles,omitempty"`
+}
+
+// deviceRule names the device of files whose camera details or file name
+// match its patterns.
+type deviceRule struct {
+	DeviceName   string `json:"device"`
+	Priority     int    `json:"priority,omitempty"`
+	Make         string `json:"make,omitempty"`
+	Model        string `json:"model,omitempty"`
+	SerialNumber string `json:"serial,omitempty"`
+	FileName     string `json:"filename,omitempty"`
+}
+
+// deviceRules returns DeviceNames, DeviceRules and FallbackDevice as
+// file.DeviceRules.
+func (c config) deviceRules() (file.DeviceRules, error) {
+	rules := file.DeviceRules{DeviceNames: c.DeviceNames, Fallback: c.FallbackDevice}
+	for _, i := range c.DeviceRules {
+		if i.DeviceName == "" {
+			return rules, errors.New("device rule without a device name")
+		}
+		for _, pattern := range []string{i.Make, i.Model, i.SerialNumber, i.FileName} {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return rules, fmt.Errorf("device rule for %s: %q: %w", i.DeviceName, pattern, err)
+			}
+		}
+		rules.Rules = append(rules.Rules, file.DeviceRule{
+			DeviceName:   i.DeviceName,
+			Priority:     i.Priority,
+			Make:         i.Make,
+			Model:        i.Model,
+			SerialNumber: i.SerialNumber,
+			FileName:     i.FileName,
+		})
+	}
+	return rules, nil
+}
+
+// clockOffset is a correction for a devices clock between From and Until.
+type clockOffset struct {
+	DeviceName string `json:"device"`
+	From       string `json:"from,omitempty"`
+	Until      string `json:"until,omitempty"`
+	Offset     string `json:"offset"`
+}
+
+// clockOffsets returns ClockOffsets parsed as file.ClockOffsets.
+func (c config) clockOffsets() (file.ClockOffsets, error) {
+	offsets := file.ClockOffsets{}
+	for _, i := range c.ClockOffsets {
+		var err error
+		o := file.ClockOffset{DeviceName: i.DeviceName}
+		if o.From, err = file.ParseClockTime(i.From); err != nil {
+			return nil, fmt.Errorf("clock offset for %s: %w", i.DeviceName, err)
+		}
+		if o.Until, err = file.ParseClockTime(i.Until); err != nil {
+			return nil, fmt.Errorf("clock offset for %s: %w", i.DeviceName, err)
+		}
+		if o.Offset, err = file.ParseTimeShift(i.Offset); err != nil {
+			return nil, fmt.Errorf("clock offset for %s: %w", i.DeviceName, err)
+		}
+		offsets = append(offsets, o)
+	}
+	return offsets, nil
+}
+
+// deviceLocations returns the timezones of DeviceTimezones keyed by device
+// name.
+func (c config) deviceLocations() (map[string]*time.Location, error) {
+	m := map[string]*time.Location{}
+	for k, v := range c.DeviceTimezones {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", k, err)
+		}
+		m[k] = loc
+	}
+	return m, nil
+}
+
+// pathLayout returns PathLayout parsed as a template. nil is returned for
+// the default layout.
+func (c config) pathLayout() (*template.Template, error) {
+	if c.PathLayout == "" {
+		return nil, nil
+	}
+	return file.ParsePathLayout(c.PathLayout)
 }
 
-func (c *cli) setup(ctx context.Context) error {
-	return c.init()
+func (c *cli) setup(cmd *cobra.Command) error {
+	if err := c.init(cmd); err != nil {
+		return err
+	}
+	return c.setupLogging()
 }
 
-func (c *cli) init() error {
+func (c *cli) init(cmd *cobra.Command) error {
 	c.initOnce.Do(func() {
-		if c.errOnce = c.initContext(); c.errOnce != nil {
+		if c.errOnce = c.initContext(cmd); c.errOnce != nil {
 			return
 		}
 		cobra.EnableCommandSorting = false
@@ -41,28 +180,57 @@ func (c *cli) init() error {
 	return c.errOnce
 }
 
-func (c *cli) initContext() error {
-	if c.configFile == "" {
-		return fmt.Errorf("config file is not set")
-	}
-
-	var buf []byte
-	var err error
-	if buf, err = ioutil.ReadFile(c.configFile); err != nil {
+func (c *cli) initContext(cmd *cobra.Command) error {
+	if err := c.loadConfig(cmd); err != nil {
 		return err
 	}
 
-	if err := json.Unmarshal(buf, &c.config); err != nil {
-		return err
+	// The config commands work with configs that are incomplete or
+	// invalid.
+	if cmd.Annotations[annotationConfig] == "true" {
+		return nil
 	}
 
 	if c.config.DBFile == "" {
-		return fmt.Errorf("DBFile not set")
+		return fmt.Errorf("db_file is not set in %s, check it with pt config validate", c.configFile)
+	}
+
+	// Places are resolved using the embedded cities unless a GeoNames
+	// cities file is configured.
+	if c.config.GazetteerFile != "" {
+		g, err := geo.LoadGeoNames(c.config.GazetteerFile, c.config.Admin1File)
+		if err != nil {
+			return err
+		}
+		geo.SetDefault(g)
+	}
+
+	retryPolicy, err := c.config.retryPolicy()
+	if err != nil {
+		return err
 	}
+	fileutil.SetRetryPolicy(retryPolicy)
 
 	return nil
 }
 
+// retryPolicy returns the policy for transient I/O errors, the default with
+// retry_attempts and retry_backoff applied.
+func (cfg config) retryPolicy() (fileutil.RetryPolicy, error) {
+	p := fileutil.DefaultRetryPolicy
+	if cfg.RetryAttempts != 0 {
+		p.Attempts = cfg.RetryAttempts
+	}
+	if cfg.RetryBackoff != "" {
+		d, err := time.ParseDuration(cfg.RetryBackoff)
+		if err != nil {
+			return p, fmt.Errorf("retry_backoff: %w", err)
+		}
+		p.Backoff = d
+	}
+	return p, nil
+}
+
 func (c *cli) persistConfig() error {
 	if c.configFile == "" {
 		return fmt.Errorf("configFile not set")
@@ -79,10 +247,9 @@ func (c *cli) persistConfig() error {
 		return fmt.Errorf("DBfile not set")
 	}
 
-	buf, err := json.MarshalIndent(c.config, "", "    ")
+	cfg, err := c.persistedConfig()
 	if err != nil {
 		return err
 	}
-
-	return ioutil.WriteFile(c.configFile, buf, 0600)
+	return writeConfigFile(c.configFile, cfg)
 }
@@ -0,0 +1,406 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pt/db/migrations"
+	"pt/internal/file"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// annotationConfig marks the config commands, which load the config without
+// checking it so incomplete or invalid configs can be fixed.
+const annotationConfig = "config"
+
+func configCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "config",
+		Short: "Create, show, change and check the config file",
+	}
+	cmd.AddCommand(configInitCmd(cli))
+	cmd.AddCommand(configShowCmd(cli))
+	cmd.AddCommand(configGetCmd(cli))
+	cmd.AddCommand(configSetCmd(cli))
+	cmd.AddCommand(configValidateCmd(cli))
+	return cmd
+}
+
+func configInitCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		dbFile         string
+		sourceDir      string
+		destinationDir string
+		force          bool
+	}
+	var cmd = &cobra.Command{
+		Use:         "init",
+		Short:       "Write a new config file, asking for settings not given as flags on a terminal",
+		Annotations: map[string]string{annotationConfig: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cli.settings.found && !flags.force {
+				return fmt.Errorf("config file %s exists, use --force to overwrite it", cli.configFile)
+			}
+			cli.resetConfig()
+
+			settings := []struct {
+				key, value, fallback string
+			}{
+				{"db_file", flags.dbFile, filepath.Join(filepath.Dir(cli.configFile), "pt.db")},
+				{"source_dir", flags.sourceDir, ""},
+				{"destination_dir", flags.destinationDir, ""},
+			}
+			prompt := isTerminal(os.Stdin)
+			stdin := bufio.NewReader(os.Stdin)
+			for _, s := range settings {
+				value := s.value
+				if value == "" && prompt {
+					fmt.Fprintf(cli.stdout(), "%s [%s]: ", s.key, s.fallback)
+					line, err := stdin.ReadString('\n')
+					if err == io.EOF {
+						prompt = false
+						fmt.Fprintln(cli.stdout())
+					} else if err != nil {
+						return err
+					}
+					value = strings.TrimSpace(line)
+				}
+				if value == "" {
+					value = s.fallback
+				}
+				if value == "" {
+					continue
+				}
+				if err := cli.setConfig(s.key, value); err != nil {
+					return err
+				}
+			}
+
+			if err := cli.persistConfig(); err != nil {
+				return err
+			}
+			fmt.Fprintf(cli.stdout(), "wrote %s\n", cli.configFile)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.dbFile, "db-file", "", "Database file (default: pt.db next to the config file)")
+	cmd.Flags().StringVar(&flags.sourceDir, "source-dir", "", "Source directory")
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().BoolVar(&flags.force, "force", false, "Overwrite an existing config file")
+	return cmd
+}
+
+func configShowCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:         "show",
+		Short:       "Show the effective settings and where each came from (file, profile, env or flag)",
+		Annotations: map[string]string{annotationConfig: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			found := ""
+			if !cli.settings.found {
+				found = " (not found)"
+			}
+			fmt.Fprintf(cli.stdout(), "config file %s%s\n", cli.configFile, found)
+			if cli.profile != "" {
+				fmt.Fprintf(cli.stdout(), "profile %s\n", cli.profile)
+			}
+
+			w := tabwriter.NewWriter(cli.stdout(), 0, 4, 2, ' ', 0)
+			v := reflect.ValueOf(cli.config)
+			for _, key := range configKeys() {
+				if v.Field(key.index).IsZero() {
+					continue
+				}
+				value, err := formatSetting(v.Field(key.index).Interface(), false)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", key.name, value, cli.settings.sources[key.name])
+			}
+			return w.Flush()
+		},
+	}
+	return cmd
+}
+
+func configGetCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:         "get key",
+		Short:       "Print the effective value of a setting",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{annotationConfig: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := lookupConfigKey(args[0])
+			if err != nil {
+				return err
+			}
+			value, err := formatSetting(reflect.ValueOf(cli.config).Field(key.index).Interface(), true)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cli.stdout(), value)
+			return nil
+		},
+	}
+	return cmd
+}
+
+func configSetCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:         "set key value",
+		Short:       "Change a setting of the config file, or of the profile with --profile",
+		Long:        "Change a setting of the config file, or of the profile with --profile. Settings holding more than a single value are given as JSON (eg; pt config set device_timezones '{\"rene\": \"Australia/Melbourne\"}').",
+		Args:        cobra.ExactArgs(2),
+		Annotations: map[string]string{annotationConfig: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			before := cli.config.problems()
+			if err := cli.setConfig(args[0], args[1]); err != nil {
+				return err
+			}
+
+			// Settings that don't parse aren't saved, problems the
+			// config already had are left for validate.
+			for _, p := range cli.config.problems() {
+				if !containsString(before, p) {
+					return errors.New(p)
+				}
+			}
+			return cli.persistConfig()
+		},
+	}
+	return cmd
+}
+
+func configValidateCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:         "validate",
+		Short:       "Check the settings, paths and database of the config",
+		Annotations: map[string]string{annotationConfig: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			problems := cli.validateConfig()
+			for _, p := range problems {
+				fmt.Fprintln(cli.stdout(), p)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("%d problems found in %s", len(problems), cli.configFile)
+			}
+			fmt.Fprintf(cli.stdout(), "%s is valid\n", cli.configFile)
+			return nil
+		},
+	}
+	return cmd
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// lookupConfigKey returns the setting named name.
+func lookupConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys() {
+		if key.name == name {
+			return key, nil
+		}
+	}
+	return configKey{}, fmt.Errorf("unknown setting %q", name)
+}
+
+// formatSetting formats the value of a setting, settings holding more than a
+// single value as JSON.
+func formatSetting(v interface{}, indent bool) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int:
+		return fmt.Sprint(v), nil
+	}
+	var buf []byte
+	var err error
+	if indent {
+		buf, err = json.MarshalIndent(v, "", "    ")
+	} else {
+		buf, err = json.Marshal(v)
+	}
+	return string(buf), err
+}
+
+// resetConfig empties the config, for a new config file.
+func (c *cli) resetConfig() {
+	c.config, c.fileConfig, c.profile = config{}, config{}, ""
+	c.settings.sources = map[string]string{}
+}
+
+// setConfig sets the setting name to value, which is JSON for settings
+// holding more than a single value. The setting is saved to the profile if
+// one is applied, otherwise to the config file.
+func (c *cli) setConfig(name, value string) error {
+	key, err := lookupConfigKey(name)
+	if err != nil {
+		return err
+	}
+	var v interface{} = value
+	if !key.scalar {
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	// The setting is decoded into an empty config so maps and lists are
+	// replaced rather than merged.
+	decoded := config{}
+	if err := decodeConfig(map[string]interface{}{name: v}, &decoded); err != nil {
+		return err
+	}
+	reflect.ValueOf(&c.config).Elem().Field(key.index).Set(reflect.ValueOf(decoded).Field(key.index))
+
+	if c.profile == "" {
+		c.settings.sources[name] = sourceFile
+		return nil
+	}
+	if c.config.Profiles[c.profile] == nil {
+		return fmt.Errorf("profile %s not found in %s", c.profile, c.configFile)
+	}
+	c.settings.sources[name] = sourceProfile
+	return nil
+}
+
+// problems returns the problems with cfg found without looking at the
+// filesystem or the database.
+func (cfg config) problems() []string {
+	problems := []string{}
+	if cfg.DBFile == "" {
+		problems = append(problems, "db_file is not set")
+	}
+	if _, err := cfg.deviceRules(); err != nil {
+		problems = append(problems, fmt.Sprintf("device_rules: %v", err))
+	}
+	problems = append(problems, file.DeviceNameOverlaps(cfg.DeviceNames)...)
+	if _, err := cfg.deviceLocations(); err != nil {
+		problems = append(problems, fmt.Sprintf("device_timezones: %v", err))
+	}
+	if _, err := cfg.clockOffsets(); err != nil {
+		problems = append(problems, fmt.Sprintf("clock_offsets: %v", err))
+	}
+	if _, err := cfg.pathLayout(); err != nil {
+		problems = append(problems, fmt.Sprintf("path_layout: %v", err))
+	}
+	if _, err := cfg.retryPolicy(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, _, err := cfg.logRotation(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if j := cfg.Jobs; j != nil && (j.Probe < 0 || j.Hash < 0 || j.Copy < 0) {
+		problems = append(problems, "jobs: the number of workers must not be negative")
+	}
+	if _, err := cfg.sources(); err != nil {
+		problems = append(problems, fmt.Sprintf("sources: %v", err))
+	}
+	return problems
+}
+
+// validateConfig returns the problems with the config: unknown settings in
+// the config file, the problems of the settings, and the paths and database
+// they name.
+func (c *cli) validateConfig() []string {
+	if !c.settings.found {
+		return []string{fmt.Sprintf("%s not found, create it with pt config init", c.configFile)}
+	}
+
+	problems := unknownSettings(c.settings.file, "")
+	for name, profile := range c.fileConfig.Profiles {
+		problems = append(problems, unknownSettings(profile, "profile "+name+": ")...)
+	}
+	problems = append(problems, c.config.problems()...)
+
+	if c.config.DBFile != "" {
+		if err := checkDB(c.config.DBFile); err != nil {
+			problems = append(problems, fmt.Sprintf("db_file %s: %v", c.config.DBFile, err))
+		}
+	}
+	if c.config.SourceDir != "" {
+		if err := checkDir(c.config.SourceDir, false); err != nil {
+			problems = append(problems, fmt.Sprintf("source_dir: %v", err))
+		}
+	}
+	if c.config.DestinationDir != "" {
+		if err := checkDir(c.config.DestinationDir, true); err != nil {
+			problems = append(problems, fmt.Sprintf("destination_dir: %v", err))
+		}
+	}
+	for name, p := range map[string]string{"gazetteer_file": c.config.GazetteerFile, "gazetteer_admin1_file": c.config.Admin1File} {
+		if _, err := os.Stat(p); p != "" && err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return problems
+}
+
+// unknownSettings returns the keys of settings that aren't settings of
+// config, such as typos.
+func unknownSettings(settings map[string]interface{}, prefix string) []string {
+	known := map[string]bool{}
+	for _, key := range configKeys() {
+		known[key.name] = true
+	}
+	problems := []string{}
+	for name := range settings {
+		if !known[name] {
+			problems = append(problems, fmt.Sprintf("%sunknown setting %q", prefix, name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// checkDir returns an error if p isn't a directory, or one that can't be
+// written to if writable is set.
+func checkDir(p string, writable bool) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", p)
+	}
+	if !writable {
+		return nil
+	}
+	fh, err := os.CreateTemp(p, ".pt-validate-*")
+	if err != nil {
+		return err
+	}
+	fh.Close()
+	return os.Remove(fh.Name())
+}
+
+// checkDB returns an error if the database at p doesn't exist, its directory
+// can't be written to, or its schema isn't the version pt uses.
+func checkDB(p string) error {
+	if _, err := os.Stat(p); err != nil {
+		return fmt.Errorf("%w, create it with pt init", err)
+	}
+	if err := checkDir(filepath.Dir(p), true); err != nil {
+		return err
+	}
+
+	version, dirty, err := migrations.SchemaVersion(fmt.Sprintf("sqlite3://%s", p))
+	if err != nil {
+		return err
+	}
+	if latest := migrations.LatestVersion(); dirty || version != latest {
+		return fmt.Errorf("schema version %d (dirty %t), expected %d, migrate it with pt init", version, dirty, latest)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// configExts are the extensions of the config file formats, in the order
+// they are looked for.
+var configExts = []string{"json", "yaml", "yml", "toml"}
+
+// configDirs returns the directories searched for pt/config.<ext>,
+// $XDG_CONFIG_HOME (default: ~/.config) followed by $XDG_CONFIG_DIRS
+// (default: /etc/xdg).
+func configDirs() []string {
+	home := os.Getenv("XDG_CONFIG_HOME")
+	if home == "" {
+		home = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	dirs := []string{home}
+	system := os.Getenv("XDG_CONFIG_DIRS")
+	if system == "" {
+		system = "/etc/xdg"
+	}
+	for _, dir := range filepath.SplitList(system) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// findConfigFile returns the first config file found in configDirs, or the
+// JSON file in $XDG_CONFIG_HOME and false if there is none.
+func findConfigFile() (string, bool) {
+	dirs := configDirs()
+	for _, dir := range dirs {
+		for _, ext := range configExts {
+			p := filepath.Join(dir, "pt", "config."+ext)
+			if _, err := os.Stat(p); err == nil {
+				return p, true
+			}
+		}
+	}
+	return filepath.Join(dirs[0], "pt", "config.json"), false
+}
+
+// configFormat returns the format of the config file at p from its
+// extension.
+func configFormat(p string) (string, error) {
+	switch ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(p), ".")); ext {
+	case "json", "toml":
+		return ext, nil
+	case "yaml", "yml":
+		return "yaml", nil
+	default:
+		return "", fmt.Errorf("%s: unsupported config format %q, expected json, yaml or toml", p, ext)
+	}
+}
+
+// readConfigFile returns the settings of the config file at p. Keys keep
+// their case, unlike viper which lower cases them, as device names are map
+// keys.
+func readConfigFile(p string) (map[string]interface{}, error) {
+	format, err := configFormat(p)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	switch format {
+	case "json":
+		err = json.Unmarshal(buf, &m)
+	case "yaml":
+		err = yaml.Unmarshal(buf, &m)
+	case "toml":
+		err = toml.Unmarshal(buf, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return m, nil
+}
+
+// writeConfigFile writes cfg to the config file at p in the format of its
+// extension.
+func writeConfigFile(p string, cfg config) error {
+	format, err := configFormat(p)
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return err
+	}
+	if format != "json" {
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(buf, &m); err != nil {
+			return err
+		}
+		if format == "yaml" {
+			buf, err = yaml.Marshal(m)
+		} else {
+			buf, err = toml.Marshal(m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(p, buf, 0600)
+}
+
+// decodeConfig decodes settings into cfg. Strings from environment
+// variables are converted to the type of their field.
+func decodeConfig(settings map[string]interface{}, cfg *config) error {
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:          "json",
+		WeaklyTypedInput: true,
+		Result:           cfg,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Decode(settings)
+}
+
+// configKey is a setting of config.
+type configKey struct {
+	name  string
+	index int
+	// scalar is set for settings that are a single value, which can be
+	// set by environment variables.
+	scalar bool
+}
+
+// configKeys returns the settings of config, the json names of its fields.
+func configKeys() []configKey {
+	keys := []configKey{}
+	t := reflect.TypeOf(config{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		kind := t.Field(i).Type.Kind()
+		keys = append(keys, configKey{name: name, index: i, scalar: kind == reflect.String || kind == reflect.Int})
+	}
+	return keys
+}
+
+// envPrefix is the prefix of the environment variables setting config, eg;
+// PT_DB_FILE sets db_file.
+const envPrefix = "pt"
+
+// loadConfig loads the config file, the profile and the settings of
+// environment variables and the flags of cmd into c.config. The config file
+// is --config-file, or the first found in configDirs. Each source overrides
+// the one before it:
+//
+//	config file < --profile < PT_<KEY> environment variables < flags
+//
+// Flags override the setting of the same name with - for _ (eg;
+// --destination-dir sets destination_dir).
+func (c *cli) loadConfig(cmd *cobra.Command) error {
+	c.settings = configSettings{file: map[string]interface{}{}, sources: map[string]string{}}
+	if c.configFile == "" {
+		c.configFile, c.settings.found = findConfigFile()
+	} else {
+		c.settings.found = true
+	}
+	if c.settings.found {
+		m, err := readConfigFile(c.configFile)
+		if err != nil {
+			return err
+		}
+		c.settings.file = m
+	}
+	if err := decodeConfig(c.settings.file, &c.fileConfig); err != nil {
+		return fmt.Errorf("%s: %w", c.configFile, err)
+	}
+
+	settings := map[string]interface{}{}
+	for k, v := range c.settings.file {
+		settings[k] = v
+		c.settings.sources[k] = sourceFile
+	}
+	if c.profile != "" {
+		profile, ok := c.fileConfig.Profiles[c.profile]
+		if !ok {
+			return fmt.Errorf("profile %s not found in %s", c.profile, c.configFile)
+		}
+		for k, v := range profile {
+			settings[k] = v
+			c.settings.sources[k] = sourceProfile
+		}
+	}
+
+	v := viper.New()
+	v.SetEnvPrefix(envPrefix)
+	for _, key := range configKeys() {
+		if !key.scalar {
+			continue
+		}
+		_ = v.BindEnv(key.name)
+		if f := cmd.Flags().Lookup(strings.ReplaceAll(key.name, "_", "-")); f != nil {
+			_ = v.BindPFlag(key.name, f)
+		}
+		if !v.IsSet(key.name) {
+			continue
+		}
+		settings[key.name] = v.Get(key.name)
+		c.settings.sources[key.name] = sourceEnv
+		if f := cmd.Flags().Lookup(strings.ReplaceAll(key.name, "_", "-")); f != nil && f.Changed {
+			c.settings.sources[key.name] = sourceFlag
+		}
+	}
+
+	if err := decodeConfig(settings, &c.config); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
+
+// Sources of settings.
+const (
+	sourceFile    = "file"
+	sourceProfile = "profile"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// configSettings are where the settings of c.config came from.
+type configSettings struct {
+	// found is set if the config file exists.
+	found bool
+	// file is the settings of the config file.
+	file map[string]interface{}
+	// sources is the source of each setting by key.
+	sources map[string]string
+}
+
+// persistedConfig returns c.config as it is written to the config file.
+// Settings of environment variables and flags are left as they are in the
+// file, settings of the profile are written to the profile.
+func (c *cli) persistedConfig() (config, error) {
+	cfg := c.config
+	current, file := reflect.ValueOf(c.config), reflect.ValueOf(c.fileConfig)
+	out := reflect.ValueOf(&cfg).Elem()
+	for _, key := range configKeys() {
+		switch c.settings.sources[key.name] {
+		case sourceEnv, sourceFlag:
+			out.Field(key.index).Set(file.Field(key.index))
+		case sourceProfile:
+			out.Field(key.index).Set(file.Field(key.index))
+			buf, err := json.Marshal(current.Field(key.index).Interface())
+			if err != nil {
+				return cfg, err
+			}
+			var v interface{}
+			if err := json.Unmarshal(buf, &v); err != nil {
+				return cfg, err
+			}
+			cfg.Profiles[c.profile][key.name] = v
+		}
+	}
+	return cfg, nil
+}
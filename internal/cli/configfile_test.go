@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `{
+	"db_file": "/file/pt.db",
+	"destination_dir": "/file/photos",
+	"retry_attempts": 1,
+	"profiles": {
+		"nas": {"destination_dir": "/nas/photos", "retry_attempts": 2}
+	}
+}`
+
+// loadTestConfig loads the config file content with profile, and a command
+// with a --destination-dir flag given args.
+func loadTestConfig(t *testing.T, content, profile string, args ...string) *cli {
+	p := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(p, []byte(content), 0600))
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("destination-dir", "", "")
+	require.NoError(t, cmd.Flags().Parse(args))
+
+	c := &cli{configFile: p, profile: profile}
+	require.NoError(t, c.loadConfig(cmd))
+	return c
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		profile        string
+		env            map[string]string
+		args           []string
+		destinationDir string
+		retryAttempts  int
+		source         string
+	}{
+		{
+			name:           "file",
+			destinationDir: "/file/photos",
+			retryAttempts:  1,
+			source:         sourceFile,
+		},
+		{
+			name:           "profile over file",
+			profile:        "nas",
+			destinationDir: "/nas/photos",
+			retryAttempts:  2,
+			source:         sourceProfile,
+		},
+		{
+			name:           "env over profile",
+			profile:        "nas",
+			env:            map[string]string{"PT_DESTINATION_DIR": "/env/photos", "PT_RETRY_ATTEMPTS": "3"},
+			destinationDir: "/env/photos",
+			retryAttempts:  3,
+			source:         sourceEnv,
+		},
+		{
+			name:           "flag over env",
+			profile:        "nas",
+			env:            map[string]string{"PT_DESTINATION_DIR": "/env/photos"},
+			args:           []string{"--destination-dir", "/flag/photos"},
+			destinationDir: "/flag/photos",
+			retryAttempts:  2,
+			source:         sourceFlag,
+		},
+		{
+			name:           "flag over file",
+			args:           []string{"--destination-dir", "/flag/photos"},
+			destinationDir: "/flag/photos",
+			retryAttempts:  1,
+			source:         sourceFlag,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c := loadTestConfig(t, testConfig, tt.profile, tt.args...)
+			assert.Equal(t, "/file/pt.db", c.config.DBFile)
+			assert.Equal(t, tt.destinationDir, c.config.DestinationDir)
+			assert.Equal(t, tt.retryAttempts, c.config.RetryAttempts)
+			assert.Equal(t, tt.source, c.settings.sources["destination_dir"])
+		})
+	}
+}
+
+func TestLoadConfigMissingProfile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(p, []byte(testConfig), 0600))
+	c := &cli{configFile: p, profile: "missing"}
+	assert.Error(t, c.loadConfig(&cobra.Command{}))
+}
+
+func TestPersistedConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		env     map[string]string
+		args    []string
+		set     map[string]string
+		// want is the config written, without its profiles.
+		want config
+		// written is the profile written, for a profile.
+		written map[string]interface{}
+	}{
+		{
+			name: "file settings",
+			set:  map[string]string{"retry_backoff": "2s"},
+			want: config{DBFile: "/file/pt.db", DestinationDir: "/file/photos", RetryAttempts: 1, RetryBackoff: "2s"},
+		},
+		{
+			name: "env and flags are not written",
+			env:  map[string]string{"PT_DB_FILE": "/env/pt.db"},
+			args: []string{"--destination-dir", "/flag/photos"},
+			want: config{DBFile: "/file/pt.db", DestinationDir: "/file/photos", RetryAttempts: 1},
+		},
+		{
+			name:    "profile settings go to the profile",
+			profile: "nas",
+			set:     map[string]string{"retry_attempts": "5"},
+			want:    config{DBFile: "/file/pt.db", DestinationDir: "/file/photos", RetryAttempts: 1},
+			// Numbers are written as JSON numbers.
+			written: map[string]interface{}{"destination_dir": "/nas/photos", "retry_attempts": float64(5)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c := loadTestConfig(t, testConfig, tt.profile, tt.args...)
+			for k, v := range tt.set {
+				require.NoError(t, c.setConfig(k, v))
+			}
+
+			got, err := c.persistedConfig()
+			require.NoError(t, err)
+			if tt.profile != "" {
+				assert.Equal(t, tt.written, got.Profiles[tt.profile])
+			}
+			got.Profiles = nil
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
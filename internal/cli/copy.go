@@ -1,17 +1,21 @@
 package cli
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
-	"path"
-	"path/filepath"
+	"pt/internal/eventstream"
 	"pt/internal/file"
-	"pt/internal/logwrap"
+	"pt/internal/filter"
+	"pt/internal/summary"
+	"pt/internal/walk"
 	"pt/internal/worker"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -23,99 +27,252 @@ type result struct {
 
 func copyCmd(cli *cli) *cobra.Command {
 	var flags struct {
-		sourceDir       string
-		destinationDir  string
-		logLevel        string
-		checkDuplicates bool
+		sourceDir string
+		sources   sourceFlags
+		copy      copyFlags
 	}
 	var cmd = &cobra.Command{
-		Use: "copy",
-		PreRun: func(cmd *cobra.Command, args []string) {
-			_ = viper.BindPFlag("source-file", cmd.Flags().Lookup("source-file"))
-			_ = viper.BindPFlag("source-dir", cmd.Flags().Lookup("source-dir"))
-			_ = viper.BindPFlag("destination-dir", cmd.Flags().Lookup("destination-dir"))
-			_ = viper.BindPFlag("log-level", cmd.Flags().Lookup("log-level"))
-			_ = viper.BindPFlag("check-duplicates", cmd.Flags().Lookup("check-duplicates"))
-		},
+		Use:         "copy",
+		Annotations: map[string]string{annotationGraceful: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			sourceDir := cli.config.SourceDir
+			if flags.sourceDir != "" {
+				if len(flags.sources.names) > 0 || flags.sources.all {
+					return errors.New("--source-dir can't be used with --source or --all-sources")
+				}
+				sourceDir = flags.sourceDir
+			}
+			if sourceDir == "" && len(flags.sources.names) == 0 && !flags.sources.all {
+				return errors.New("no source directory, set source_dir in the config or give --source-dir")
+			}
+			sources, err := flags.sources.selected(cli.config, sourceDir)
 			if err != nil {
 				return err
 			}
-			defer db.Close()
-
-			logger := logwrap.New("pt", os.Stdout, false)
-			switch flags.logLevel {
-			case "info":
-				logger.SetLevel(logwrap.INFO)
-			case "debug":
-				logger.SetLevel(logwrap.DEBUG)
-			default:
-				logger.SetLevel(logwrap.NONE)
-			}
 
-			if cli.debug {
-				logger.SetLevel(logwrap.DEBUG)
+			roots, err := flags.copy.keepGoing.roots(sourceDir)
+			if err != nil {
+				return err
 			}
 
-			sourceDir := cli.config.SourceDir
-			if flags.sourceDir != "" {
-				sourceDir = flags.sourceDir
+			sum, walkers, err := cli.copySources(cmd.Context(), flags.copy, sources, roots)
+			// A run stopped by a signal still reports the files it
+			// finished.
+			interrupted := cli.interrupted()
+			if err != nil && interrupted == nil {
+				return err
 			}
 
-			destinationDir := cli.config.DestinationDir
-			if flags.destinationDir != "" {
-				destinationDir = flags.destinationDir
+			if err := cli.finishSummary("copy", sum, flags.copy.keepGoing, walkers...); interrupted == nil {
+				return err
 			}
+			cmd.SilenceUsage = true
+			return interrupted
+		},
+	}
+	cmd.Flags().StringVar(&flags.sourceDir, "source-dir", "", "Source directory")
+	addSourceFlags(cmd, &flags.sources)
+	addCopyFlags(cmd, &flags.copy)
+	return cmd
+}
 
-			g, ctx := errgroup.WithContext(cmd.Context())
-			files := make(chan file.File)
-
-			g.Go(func() error {
-				defer close(files)
-				return filepath.Walk(sourceDir, func(p string, info os.FileInfo, err error) error {
-					if !info.Mode().IsRegular() {
-						return nil
-					}
-
-					if strings.HasPrefix(path.Base(p), ".") {
-						return nil
-					}
-
-					if strings.HasPrefix(path.Base(p), ".") {
-						return nil
-					}
-
-					if strings.ToLower(file.DirName(p)) == ".thumbnails" {
-						return nil
-					}
-
-					select {
-					case files <- file.NewFile(p, info):
-					case <-ctx.Done():
-						return ctx.Err()
-					}
-					return nil
-				})
-			})
+// copyFlags are the flags setting how copy and watch-mounts copy files.
+type copyFlags struct {
+	destinationDir  string
+	checkDuplicates bool
+	timeShift       string
+	filter          filterFlags
+	walk            walkFlags
+	keepGoing       keepGoingFlags
+	jobs            jobsFlags
+	progress        progressFlags
+}
+
+// addCopyFlags adds the copy flags to cmd.
+func addCopyFlags(cmd *cobra.Command, flags *copyFlags) {
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().BoolVar(&flags.checkDuplicates, "check-duplicates", false, "Check duplicates within the DB and if found, don't clobber")
+	cmd.Flags().StringVar(&flags.timeShift, "time-shift", "", "Shift the timestamp of every file (eg; +1h, -30m, +2d)")
+	addFilterFlags(cmd, &flags.filter)
+	addWalkFlags(cmd, &flags.walk)
+	addKeepGoingFlags(cmd, &flags.keepGoing)
+	addJobsFlags(cmd, &flags.jobs, poolProbe, poolCopy)
+	addProgressFlags(cmd, &flags.progress)
+}
+
+// copySources copies the files of sources one after the other, counting
+// them in one summary, and returns the walkers of the sources for
+// printSkipped. The source without a name (eg; --source-dir) is copied from
+// roots, named sources from their path or, with --files-from, the paths of
+// roots within it. Copying stops at the first source that fails or when the
+// command is interrupted.
+func (c *cli) copySources(ctx context.Context, flags copyFlags, sources []source, roots []string) (*summary.Summary, []*walk.Walker, error) {
+	sum := summary.New()
+	walkers := []*walk.Walker{}
+
+	db, err := sql.Open("sqlite3", c.config.DBFile)
+	if err != nil {
+		return sum, walkers, err
+	}
+	defer db.Close()
+
+	deviceRules, err := c.config.deviceRules()
+	if err != nil {
+		return sum, walkers, err
+	}
+
+	deviceLocations, err := c.config.deviceLocations()
+	if err != nil {
+		return sum, walkers, err
+	}
 
-			const numCopiers = 4
-			for i := 0; i < numCopiers; i++ {
-				g.Go(func() error {
-					return worker.Copier(ctx, destinationDir, cli.config.DeviceNames, files, flags.checkDuplicates)
-				})
+	clockOffsets, err := c.config.clockOffsets()
+	if err != nil {
+		return sum, walkers, err
+	}
+
+	var timeShift time.Duration
+	if flags.timeShift != "" {
+		if timeShift, err = file.ParseTimeShift(flags.timeShift); err != nil {
+			return sum, walkers, err
+		}
+	}
+
+	pathLayout, err := c.config.pathLayout()
+	if err != nil {
+		return sum, walkers, err
+	}
+
+	walkOptions, err := flags.walk.options()
+	if err != nil {
+		return sum, walkers, err
+	}
+
+	destinationDir := c.config.DestinationDir
+	if flags.destinationDir != "" {
+		destinationDir = flags.destinationDir
+	}
+
+	pools, err := c.workerPools(flags.jobs)
+	if err != nil {
+		return sum, walkers, err
+	}
+
+	reporter, err := flags.progress.reporter("copy")
+	if err != nil {
+		return sum, walkers, err
+	}
+
+	// The filters of each source are added to the filter flags.
+	fileFilters := []filter.Filter{}
+	for _, src := range sources {
+		fileFilter, err := src.filters.merge(flags.filter).filter()
+		if err != nil {
+			return sum, walkers, err
+		}
+		fileFilters = append(fileFilters, fileFilter)
+	}
+
+	reporter.Start()
+	defer reporter.Stop()
+	for i, src := range sources {
+		fileFilter := fileFilters[i]
+		walkOptions.Filter = fileFilter
+		walker := walk.New(walkOptions)
+		walkers = append(walkers, walker)
+
+		sourceRoots := roots
+		if src.name != "" {
+			if !c.jsonOutput() {
+				fmt.Printf("source %s: %s\n", src.name, src.path)
+			}
+			sourceRoots = []string{src.path}
+			if flags.keepGoing.filesFrom != "" {
+				sourceRoots = src.within(roots)
 			}
+		}
+
+		sourceRules := deviceRules
+		if src.device != "" {
+			sourceRules.Fallback = src.device
+		}
+
+		copierConfig := worker.CopierConfig{
+			DB:              db,
+			DestinationDir:  destinationDir,
+			DeviceRules:     sourceRules,
+			DeviceLocations: deviceLocations,
+			Location:        src.location,
+			TimeShift:       timeShift + src.clockOffset,
+			ClockOffsets:    clockOffsets,
+			PathLayout:      pathLayout,
+			CheckDuplicates: flags.checkDuplicates,
+			Filter:          fileFilter,
+			RemoveSource:    src.move,
+			Summary:         sum,
+			KeepGoing:       flags.keepGoing.keepGoing,
+			Limiter:         pools.limiter,
+			Progress:        reporter,
+			Events:          c.events,
+		}
+		err := c.copyFiles(ctx, copierConfig, walker, sourceRoots, pools, i == len(sources)-1)
+		if err != nil || c.interrupted() != nil {
+			return sum, walkers, err
+		}
+	}
+	return sum, walkers, nil
+}
 
-			if err := g.Wait(); err != nil {
+// copyFiles copies the files walker finds walking roots with cfg, probing
+// them with one pool and copying them with another so a slow copy doesn't
+// hold up probing. The discovery of the reporter is done once the walk is if
+// last is set.
+func (c *cli) copyFiles(ctx context.Context, cfg worker.CopierConfig, walker *walk.Walker, roots []string, pools workerPools, last bool) error {
+	g, ctx := errgroup.WithContext(ctx)
+	files := make(chan file.File)
+
+	g.Go(func() error {
+		defer close(files)
+		for _, root := range roots {
+			err := walker.Walk(root, func(p string, info os.FileInfo) error {
+				cfg.Progress.Discovered(info.Size())
+				c.events.Emit(eventstream.Event{Type: eventstream.FileDiscovered, Path: p, Size: info.Size()})
+				select {
+				case files <- file.NewFile(p, info):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				return nil
+			})
+			if err != nil {
 				return err
 			}
+		}
+		if last {
+			cfg.Progress.DiscoveryDone()
+		}
+		return nil
+	})
 
-			return nil
-		},
+	jobs := make(chan worker.Job)
+	var probers sync.WaitGroup
+	for i := 0; i < pools.size(poolProbe); i++ {
+		probers.Add(1)
+		g.Go(func() error {
+			defer probers.Done()
+			return worker.Prober(ctx, cfg, files, jobs)
+		})
 	}
-	cmd.Flags().StringVar(&flags.sourceDir, "source-dir", "", "Source directory")
-	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
-	cmd.Flags().StringVar(&flags.logLevel, "log-level", "none", "Log level (none, info, debug)")
-	cmd.Flags().BoolVar(&flags.checkDuplicates, "check-duplicates", false, "Check duplicates within the DB and if found, don't clobber")
-	return cmd
+	g.Go(func() error {
+		probers.Wait()
+		close(jobs)
+		return nil
+	})
+
+	for i := 0; i < pools.size(poolCopy); i++ {
+		g.Go(func() error {
+			return worker.Copier(ctx, cfg, jobs)
+		})
+	}
+	return g.Wait()
 }
@@ -2,18 +2,19 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/walk"
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"golang.org/x/sync/errgroup"
 )
 
-func deleter(c <- chan string) {
+func deleter(w io.Writer, c <- chan string) {
 	// {filename: ext}
 	type imageFile struct {
 		filePath string
@@ -25,13 +26,13 @@ func deleter(c <- chan string) {
 		if existingImageFile, exists := imageFiles[filepathWithoutExt]; exists {
 			existingExt := existingImageFile.fileExt
 			if strings.ToLower(existingExt) == ".cr2" {
-				fmt.Printf("deleting %s\n", existingImageFile.filePath)
+				fmt.Fprintf(w, "deleting %s\n", existingImageFile.filePath)
 				err := os.Remove(existingImageFile.filePath)
 				if err != nil {
 					log.Fatal(fmt.Sprintf("Failed deleting %s: %v", existingImageFile.filePath, err))
 				}
 			} else {
-				fmt.Printf("need to delete %s,%s\n", existingImageFile.filePath, file)
+				fmt.Fprintf(w, "need to delete %s,%s\n", existingImageFile.filePath, file)
 			}
 			continue
 		}
@@ -42,33 +43,43 @@ func deleter(c <- chan string) {
 func cr2DupeCmd(cli *cli) *cobra.Command {
 	var flags struct {
 		imageDir  string
+		filter    filterFlags
+		walk      walkFlags
 	}
 	var cmd = &cobra.Command{
 		Use: "cr2dupe",
-		PreRun: func(cmd *cobra.Command, args []string) {
-			_ = viper.BindPFlag("image-dir", cmd.Flags().Lookup("image-dir"))
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			fileFilter, err := flags.filter.filter()
+			if err != nil {
+				return err
+			}
+			walkOptions, err := flags.walk.options()
+			if err != nil {
+				return err
+			}
+			walkOptions.Filter = fileFilter
+			walker := walk.New(walkOptions)
+
 			g, ctx := errgroup.WithContext(cmd.Context())
 			c := make(chan string)
 			g.Go(func() error {
 				defer close(c)
-				return filepath.Walk(flags.imageDir, func(p string, info os.FileInfo, err error) error {
-					if !info.Mode().IsRegular() {
+				return walker.Walk(flags.imageDir, func(p string, info os.FileInfo) error {
+					if info.Size() == 0 {
 						return nil
 					}
 
-					if strings.HasPrefix(path.Base(p), ".") {
+					switch strings.ToLower(filepath.Ext(p)) {
+					case ".jpg", ".jpeg", ".cr2":
+					default:
 						return nil
 					}
 
-					if info.Size() == 0 {
+					f := file.NewFile(p, info)
+					if !fileFilter.MatchMediaType(f.MediaType()) {
 						return nil
 					}
-
-					switch strings.ToLower(filepath.Ext(p)) {
-					case ".jpg", ".jpeg", ".cr2":
-					default:
+					if fileFilter.HasTimeRange() && !fileFilter.MatchTime(f.Timestamp()) {
 						return nil
 					}
 
@@ -81,18 +92,21 @@ func cr2DupeCmd(cli *cli) *cobra.Command {
 				})
 			})
 
-			deleter(c)
+			deleter(cli.stdout(), c)
 
 			// End of pipeline.
 			if err := g.Wait(); err != nil {
 				return err
 			}
+			cli.printSkipped(walker)
 
 			return nil
 		},
 	}
 	cmd.Flags().StringVar(&flags.imageDir, "image-dir", "", "Image directory")
 	cmd.MarkFlagRequired("image-dir")
+	addFilterFlags(cmd, &flags.filter)
+	addWalkFlags(cmd, &flags.walk)
 
 	return cmd
 }
@@ -0,0 +1,339 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/walk"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+)
+
+// scannedDevice is a distinct camera found by devices scan.
+type scannedDevice struct {
+	camera   file.Camera
+	prefix   string
+	files    int
+	dirs     map[string]bool
+	resolved map[string]bool
+}
+
+func devicesCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use: "devices",
+	}
+	cmd.AddCommand(devicesScanCmd(cli))
+	cmd.AddCommand(devicesListCmd(cli))
+	cmd.AddCommand(devicesAddCmd(cli))
+	cmd.AddCommand(devicesRemoveCmd(cli))
+	return cmd
+}
+
+func devicesScanCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		walk walkFlags
+	}
+	var cmd = &cobra.Command{
+		Use: "scan dir...",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no directories to scan")
+			}
+
+			deviceRules, err := cli.config.deviceRules()
+			if err != nil {
+				return err
+			}
+
+			walkOptions, err := flags.walk.options()
+			if err != nil {
+				return err
+			}
+			walker := walk.New(walkOptions)
+
+			devices := map[string]*scannedDevice{}
+			for _, p := range args {
+				err := walker.Walk(p, func(p string, info os.FileInfo) error {
+					f := file.NewFile(p, info)
+					supported, err := f.IsSupported()
+					if err != nil && err != fileutil.ErrUnknownFileType {
+						return err
+					}
+					if !supported {
+						return nil
+					}
+
+					camera := f.Camera()
+					camera.LensModel = ""
+
+					// Files without camera details are told apart by the
+					// prefix of their name (eg; PXL_).
+					prefix := ""
+					if camera == (file.Camera{}) {
+						prefix = fileNamePrefix(p)
+					}
+
+					key := strings.Join([]string{camera.Make, camera.Model, camera.SerialNumber, prefix}, "\x00")
+					d, ok := devices[key]
+					if !ok {
+						d = &scannedDevice{camera: camera, prefix: prefix, dirs: map[string]bool{}, resolved: map[string]bool{}}
+						devices[key] = d
+					}
+					d.files++
+					d.dirs[filepath.Dir(p)] = true
+					d.resolved[deviceRules.Match(p, camera)] = true
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			keys := []string{}
+			for k := range devices {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				d := devices[k]
+				fmt.Fprintf(cli.stdout(), "make %q, model %q, serial %q", d.camera.Make, d.camera.Model, d.camera.SerialNumber)
+				if d.prefix != "" {
+					fmt.Fprintf(cli.stdout(), ", file names %s*", d.prefix)
+				}
+				fmt.Fprintf(cli.stdout(), ": %d files\n", d.files)
+				for _, dir := range sortedKeys(d.dirs) {
+					fmt.Fprintf(cli.stdout(), "  path %s\n", dir)
+				}
+				for _, name := range sortedKeys(d.resolved) {
+					if name == "" {
+						name = "(none)"
+					}
+					fmt.Fprintf(cli.stdout(), "  device %s\n", name)
+				}
+				if suggestion := suggestDeviceRule(d); suggestion != "" {
+					fmt.Fprintf(cli.stdout(), "  suggest pt devices add %s\n", suggestion)
+				}
+			}
+			cli.printSkipped(walker)
+			return nil
+		},
+	}
+	addWalkFlags(cmd, &flags.walk)
+	return cmd
+}
+
+// suggestDeviceRule returns the arguments of a devices add command matching
+// the files of d, or an empty string if they can't be told apart.
+func suggestDeviceRule(d *scannedDevice) string {
+	name := file.SuggestDeviceName(d.camera)
+	args := []string{}
+	if d.camera.Make != "" {
+		args = append(args, fmt.Sprintf("--make %q", d.camera.Make))
+	}
+	if d.camera.Model != "" {
+		args = append(args, fmt.Sprintf("--model %q", d.camera.Model))
+	}
+	if d.camera.SerialNumber != "" {
+		args = append(args, fmt.Sprintf("--serial %q", d.camera.SerialNumber))
+	}
+	if d.prefix != "" {
+		name = strings.ToLower(strings.TrimSuffix(d.prefix, "_"))
+		args = append(args, fmt.Sprintf("--filename %q", d.prefix+"*"))
+	}
+	if name == "" {
+		return ""
+	}
+	return strings.Join(append([]string{name}, args...), " ")
+}
+
+// fileNamePrefix returns the letters and trailing underscore that start the
+// name of the file at p (eg; PXL_ for PXL_20220102_123456.jpg), or an empty
+// string if its name doesn't start that way.
+func fileNamePrefix(p string) string {
+	name := filepath.Base(p)
+	for i, r := range name {
+		if r == '_' && i > 0 {
+			return name[:i+1]
+		}
+		if !unicode.IsLetter(r) {
+			break
+		}
+	}
+	return ""
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func devicesListCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use: "list",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names := map[string]bool{}
+			for name := range cli.config.DeviceNames {
+				names[name] = true
+			}
+			for _, r := range cli.config.DeviceRules {
+				names[r.DeviceName] = true
+			}
+
+			for _, name := range sortedKeys(names) {
+				fmt.Fprintln(cli.stdout(), name)
+				for _, p := range cli.config.DeviceNames[name] {
+					fmt.Fprintf(cli.stdout(), "  path %s\n", p)
+				}
+				for _, r := range cli.config.DeviceRules {
+					if r.DeviceName == name {
+						fmt.Fprintf(cli.stdout(), "  rule %s\n", r)
+					}
+				}
+			}
+			if cli.config.FallbackDevice != "" {
+				fmt.Fprintf(cli.stdout(), "fallback %s\n", cli.config.FallbackDevice)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// String returns the patterns and priority of r.
+func (r deviceRule) String() string {
+	s := []string{}
+	for _, i := range []struct{ name, pattern string }{
+		{"make", r.Make},
+		{"model", r.Model},
+		{"serial", r.SerialNumber},
+		{"filename", r.FileName},
+	} {
+		if i.pattern != "" {
+			s = append(s, fmt.Sprintf("%s %q", i.name, i.pattern))
+		}
+	}
+	if r.Priority != 0 {
+		s = append(s, fmt.Sprintf("priority %d", r.Priority))
+	}
+	return strings.Join(s, ", ")
+}
+
+func devicesAddCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		rule deviceRule
+	}
+	var cmd = &cobra.Command{
+		Use: "add name [path...]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("expected a device name")
+			}
+			name, paths := args[0], args[1:]
+			if strings.ContainsAny(name, "/\\") {
+				return fmt.Errorf("device name %q can't contain a path separator", name)
+			}
+
+			rule := flags.rule
+			rule.DeviceName = name
+			hasRule := rule.Make != "" || rule.Model != "" || rule.SerialNumber != "" || rule.FileName != ""
+			if len(paths) == 0 && !hasRule {
+				return errors.New("expected paths or --make, --model, --serial or --filename patterns")
+			}
+
+			for _, p := range paths {
+				if !containsString(cli.config.DeviceNames[name], p) {
+					if cli.config.DeviceNames == nil {
+						cli.config.DeviceNames = map[string][]string{}
+					}
+					cli.config.DeviceNames[name] = append(cli.config.DeviceNames[name], p)
+				}
+			}
+			if hasRule {
+				cli.config.DeviceRules = append(cli.config.DeviceRules, rule)
+			}
+
+			// The rules are checked before they are saved.
+			if _, err := cli.config.deviceRules(); err != nil {
+				return err
+			}
+			return cli.persistConfig()
+		},
+	}
+	cmd.Flags().StringVar(&flags.rule.Make, "make", "", "Pattern matching the camera make (eg; 'NIKON*')")
+	cmd.Flags().StringVar(&flags.rule.Model, "model", "", "Pattern matching the camera model (eg; 'Pixel 6*')")
+	cmd.Flags().StringVar(&flags.rule.SerialNumber, "serial", "", "Pattern matching the camera body serial number")
+	cmd.Flags().StringVar(&flags.rule.FileName, "filename", "", "Pattern matching the file name (eg; 'PXL_*')")
+	cmd.Flags().IntVar(&flags.rule.Priority, "priority", 0, "Priority of the rule, higher priorities are tried first")
+	return cmd
+}
+
+func devicesRemoveCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use: "remove name [path...]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("expected a device name")
+			}
+			name, paths := args[0], args[1:]
+
+			// Without paths the device is removed along with its rules.
+			if len(paths) == 0 {
+				_, found := cli.config.DeviceNames[name]
+				delete(cli.config.DeviceNames, name)
+				rules := []deviceRule{}
+				for _, r := range cli.config.DeviceRules {
+					if r.DeviceName == name {
+						found = true
+						continue
+					}
+					rules = append(rules, r)
+				}
+				if !found {
+					return fmt.Errorf("no device %s", name)
+				}
+				cli.config.DeviceRules = rules
+				return cli.persistConfig()
+			}
+
+			for _, p := range paths {
+				if !containsString(cli.config.DeviceNames[name], p) {
+					return fmt.Errorf("device %s has no path %s", name, p)
+				}
+				kept := []string{}
+				for _, i := range cli.config.DeviceNames[name] {
+					if i != p {
+						kept = append(kept, i)
+					}
+				}
+				cli.config.DeviceNames[name] = kept
+			}
+			if len(cli.config.DeviceNames[name]) == 0 {
+				delete(cli.config.DeviceNames, name)
+			}
+			return cli.persistConfig()
+		},
+	}
+	return cmd
+}
+
+// containsString returns true if s contains v.
+func containsString(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,466 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/events"
+	"pt/internal/file"
+	"pt/internal/movelog"
+	"pt/internal/store"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// archivedEvent is an event recorded in the meta table.
+type archivedEvent struct {
+	id    string
+	name  string
+	files []archivedFile
+}
+
+// displayName returns the name of the event, or its id if it has no name.
+func (e archivedEvent) displayName() string {
+	if e.name != "" {
+		return e.name
+	}
+	return e.id
+}
+
+// recordedEvents groups files by their recorded event, ordered by event id.
+func recordedEvents(files []archivedFile) []archivedEvent {
+	byID := map[string]*archivedEvent{}
+	ids := []string{}
+	for _, f := range files {
+		id := f.meta[store.MetaEventID]
+		if id == "" {
+			continue
+		}
+		e, ok := byID[id]
+		if !ok {
+			e = &archivedEvent{id: id}
+			byID[id] = e
+			ids = append(ids, id)
+		}
+		if name := f.meta[store.MetaEventName]; name != "" {
+			e.name = name
+		}
+		e.files = append(e.files, f)
+	}
+
+	sort.Strings(ids)
+	archivedEvents := []archivedEvent{}
+	for _, id := range ids {
+		archivedEvents = append(archivedEvents, *byID[id])
+	}
+	return archivedEvents
+}
+
+func eventsCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use: "events",
+	}
+	cmd.AddCommand(eventsClusterCmd(cli))
+	cmd.AddCommand(eventsListCmd(cli))
+	cmd.AddCommand(eventsNameCmd(cli))
+	cmd.AddCommand(eventsOrganizeCmd(cli))
+	cmd.AddCommand(eventsLinkCmd(cli))
+	return cmd
+}
+
+func eventsClusterCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		gap      time.Duration
+		distance float64
+		dryRun   bool
+	}
+	var cmd = &cobra.Command{
+		Use: "cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			files, err := loadArchivedFiles(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			byID := map[int64]archivedFile{}
+			items := []events.Item{}
+			for _, f := range files {
+				t, ok := f.timestamp()
+				if !ok {
+					continue
+				}
+				byID[f.hash.ID] = f
+				items = append(items, events.Item{ID: f.hash.ID, Time: t, Point: f.location()})
+			}
+
+			clustered := events.Cluster(items, events.Options{MaxGap: flags.gap, MaxDistance: flags.distance})
+			for _, e := range clustered {
+				// Keep the name most of the events files were given
+				// before.
+				names := map[string]int{}
+				name := ""
+				for _, i := range e.Items {
+					if n := byID[i.ID].meta[store.MetaEventName]; n != "" {
+						names[n]++
+						if names[n] > names[name] {
+							name = n
+						}
+					}
+				}
+
+				fmt.Fprintf(cli.stdout(), "%s\t%s\t%s\t%d\t%s\n", e.ID(), e.Start().Format(time.RFC3339), e.End().Format(time.RFC3339), len(e.Items), name)
+				if flags.dryRun {
+					continue
+				}
+
+				for _, i := range e.Items {
+					if err := store.SetMetaMap(ctx, db, i.ID, map[string]string{
+						store.MetaEventID:   e.ID(),
+						store.MetaEventName: name,
+					}); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&flags.gap, "gap", 3*time.Hour, "Start a new event after this long without a file")
+	cmd.Flags().Float64Var(&flags.distance, "distance", 50, "Start a new event when a file is this many km from the previous file")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the events without recording them")
+	return cmd
+}
+
+func eventsListCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use: "list",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			files, err := loadArchivedFiles(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			for _, e := range recordedEvents(files) {
+				fmt.Fprintf(cli.stdout(), "%s\t%d\t%s\n", e.id, len(e.files), e.name)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func eventsNameCmd(cli *cli) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use: "name event-id name",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if len(args) != 2 {
+				return errors.New("expected an event id and a name")
+			}
+			id, name := args[0], strings.TrimSpace(args[1])
+			if err := checkEventName(name); err != nil {
+				return err
+			}
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			files, err := loadArchivedFiles(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			found := false
+			for _, f := range files {
+				if f.meta[store.MetaEventID] != id {
+					continue
+				}
+				found = true
+				if err := store.SetMeta(ctx, db, f.hash.ID, store.MetaEventName, name); err != nil {
+					return err
+				}
+			}
+			if !found {
+				return fmt.Errorf("no event %s, run pt events cluster first", id)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func eventsOrganizeCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+		moveLogDir     string
+		dryRun         bool
+	}
+	var cmd = &cobra.Command{
+		Use:         "organize",
+		Annotations: map[string]string{annotationGraceful: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+
+			pathLayout, err := cli.config.pathLayout()
+			if err != nil {
+				return err
+			}
+
+			files, err := loadArchivedFiles(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			moveLogDir := filepath.Join(filepath.Dir(cli.config.DBFile), "movelog")
+			if flags.moveLogDir != "" {
+				moveLogDir = flags.moveLogDir
+			}
+			var log *movelog.Log
+
+			// Only named events are organized, the files of other
+			// events keep their album. A move that was started is
+			// recorded in the database even if a signal stops the
+			// command.
+			ctx = context.WithoutCancel(ctx)
+			moved := 0
+			for _, e := range recordedEvents(files) {
+				if e.name == "" {
+					continue
+				}
+
+				for _, af := range e.files {
+					// Files copied before the device was recorded,
+					// into a custom path layout, can't be placed.
+					deviceName, _, ok := af.deviceAlbum(pathLayout)
+					if !ok {
+						fmt.Fprintf(cli.stdout(), "skip %s: unknown device and album\n", filepath.Join(destinationDir, af.hash.Filepath))
+						continue
+					}
+					timestamp, ok := af.timestamp()
+					if !ok {
+						continue
+					}
+
+					src := filepath.Join(destinationDir, af.hash.Filepath)
+					f := file.NewFile(src, nil).WithOptions(file.WithAlbum(e.name), file.WithPathLayout(pathLayout))
+					if filepath.Dir(f.DestinationFilePath(destinationDir, deviceName, timestamp)) == filepath.Dir(src) {
+						continue
+					}
+					dst, err := uniqueDestinationFilePath(f, destinationDir, deviceName, timestamp, nil)
+					if err != nil {
+						return err
+					}
+
+					if err := cli.stopMoves(cmd, moved); err != nil {
+						return err
+					}
+					fmt.Fprintf(cli.stdout(), "organize %s: %s\n", src, dst)
+					if flags.dryRun {
+						continue
+					}
+					moved++
+
+					// The log is created with the first move so
+					// nothing is written when there's nothing to move.
+					if log == nil {
+						if log, err = movelog.Create(moveLogDir, "organize"); err != nil {
+							return err
+						}
+						defer log.Close()
+						fmt.Fprintf(cli.stdout(), "move log: %s\n", log.Path())
+					}
+
+					meta := map[string]string{
+						store.MetaDevice: deviceName,
+						store.MetaAlbum:  e.name,
+					}
+					if err := moveRecorded(ctx, db, log, src, dst, af.previousMeta(meta), func(exec boil.ContextExecutor) error {
+						if err := store.UpdateFilepath(ctx, exec, af.hash.Filepath, store.RelPath(destinationDir, dst)); err != nil {
+							return err
+						}
+						return store.SetMetaMap(ctx, exec, af.hash.ID, meta)
+					}); err != nil {
+						return err
+					}
+					removeEmptyDirs(filepath.Dir(src), destinationDir)
+				}
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().StringVar(&flags.moveLogDir, "move-log-dir", "", "Directory to write the move log to (default: movelog next to the database)")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the moves without making them")
+	return cmd
+}
+
+func eventsLinkCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+	}
+	var cmd = &cobra.Command{
+		Use: "link dir",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if len(args) != 1 {
+				return errors.New("expected the directory to create the album tree in")
+			}
+			linkDir := args[0]
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+			destinationDir, err = filepath.Abs(destinationDir)
+			if err != nil {
+				return err
+			}
+
+			files, err := loadArchivedFiles(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			// Links of files with the same name in an album are given a
+			// suffix, links to files that are no longer archived there are
+			// replaced as the file may have moved since.
+			events := recordedEvents(files)
+			archived := map[string]bool{}
+			for _, f := range files {
+				archived[filepath.Join(destinationDir, f.hash.Filepath)] = true
+			}
+			taken := map[string]bool{}
+			for _, e := range events {
+				albumDir := filepath.Join(linkDir, e.displayName())
+				if err := os.MkdirAll(albumDir, 0755); err != nil {
+					return err
+				}
+				targets := []string{}
+				for _, af := range e.files {
+					targets = append(targets, filepath.Join(destinationDir, af.hash.Filepath))
+				}
+				if err := linkAlbum(albumDir, targets, archived, taken); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	return cmd
+}
+
+// checkEventName returns an error if name can't be used as the directory of
+// an album.
+func checkEventName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("event name can't be empty")
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("event name %q can't start with a dot", name)
+	case strings.ContainsAny(name, "/\\"):
+		return fmt.Errorf("event name %q can't contain a path separator", name)
+	}
+	return nil
+}
+
+// linkAlbum creates a link in albumDir to each of targets, named after the
+// target. Links already in albumDir to a target are kept. Links in taken, or
+// to another archived file, aren't replaced and the link is given a suffix
+// instead (eg; IMG_0001-1.JPG). The links are added to taken.
+func linkAlbum(albumDir string, targets []string, archived, taken map[string]bool) error {
+	entries, err := os.ReadDir(albumDir)
+	if err != nil {
+		return err
+	}
+	existing := map[string]string{}
+	for _, entry := range entries {
+		link := filepath.Join(albumDir, entry.Name())
+		if target, err := os.Readlink(link); err == nil && !taken[link] {
+			existing[target] = link
+		}
+	}
+
+	linked := map[string]bool{}
+	for _, target := range targets {
+		if link, ok := existing[target]; ok && !taken[link] {
+			taken[link], linked[target] = true, true
+		}
+	}
+
+	for _, target := range targets {
+		if linked[target] {
+			continue
+		}
+		base := filepath.Base(target)
+		ext := filepath.Ext(base)
+		link := filepath.Join(albumDir, base)
+		for i := 1; ; i++ {
+			if !taken[link] {
+				current, err := os.Readlink(link)
+				if err == nil && !archived[current] {
+					if err := os.Remove(link); err != nil {
+						return err
+					}
+				}
+				if _, err := os.Lstat(link); os.IsNotExist(err) {
+					break
+				}
+			}
+			link = filepath.Join(albumDir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), i, ext))
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+		taken[link], linked[target] = true, true
+	}
+	return nil
+}
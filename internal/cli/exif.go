@@ -4,11 +4,11 @@ import (
 	"fmt"
 	"os"
 	"pt/internal/fileutil"
+	"pt/internal/raw"
 
 	"github.com/dsoprea/go-exif/v3"
 	pngstructure "github.com/dsoprea/go-png-image-structure/v2"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 func exifCmd(cli *cli) *cobra.Command {
@@ -17,21 +17,32 @@ func exifCmd(cli *cli) *cobra.Command {
 	}
 	var cmd = &cobra.Command{
 		Use: "exif",
-		PreRun: func(cmd *cobra.Command, args []string) {
-			_ = viper.BindPFlag("source-file", cmd.Flags().Lookup("source-file"))
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// Raw files are detected from their header and extension as
+			// their content type is not known to http.DetectContentType.
+			rawEntries, err := raw.ReadExifTags(flags.sourceFile)
+			switch {
+			case err == nil:
+				for _, i := range rawEntries {
+					fmt.Fprintf(cli.stdout(), "NAME=[%s] VALUE=[%s]\n", i.TagName, i.Formatted)
+				}
+				return nil
+			case err != raw.ErrNotRaw:
+				return err
+			}
+
 			fh, err := os.Open(flags.sourceFile)
 			if err != nil {
 				return err
 			}
+			defer fh.Close()
 
 			contentType, err := fileutil.GetContentType(fh)
 			if err != nil {
 				return err
 			}
 
-			fmt.Printf("DEBUG:%s\n", contentType)
+			fmt.Fprintf(cli.stdout(), "DEBUG:%s\n", contentType)
 
 			switch contentType {
 			case "image/jpeg", "image/heic":
@@ -45,7 +56,7 @@ func exifCmd(cli *cli) *cobra.Command {
 				}
 
 				for _, i := range entries {
-					fmt.Printf("NAME=[%s] VALUE=[%s]\n", i.TagName, i.Formatted)
+					fmt.Fprintf(cli.stdout(), "NAME=[%s] VALUE=[%s]\n", i.TagName, i.Formatted)
 				}
 			case "image/png":
 				pmp := pngstructure.NewPngMediaParser()
@@ -60,7 +71,7 @@ func exifCmd(cli *cli) *cobra.Command {
 				if err != nil {
 					return fmt.Errorf("DEBUG3:%w", err)
 				}
-				fmt.Printf("%#v\n", e)
+				fmt.Fprintf(cli.stdout(), "%#v\n", e)
 
 			}
 
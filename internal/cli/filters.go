@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"pt/internal/filter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// filterFlags are the flags selecting the files walked by copy, scan and
+// cr2dupe.
+type filterFlags struct {
+	include    []string
+	exclude    []string
+	minSize    string
+	maxSize    string
+	mediaTypes []string
+	since      string
+	until      string
+}
+
+// addFilterFlags adds the filter flags to cmd.
+func addFilterFlags(cmd *cobra.Command, flags *filterFlags) {
+	cmd.Flags().StringArrayVar(&flags.include, "include", nil, "Only walk files matching this glob pattern, or regular expression prefixed with re:, may be repeated")
+	cmd.Flags().StringArrayVar(&flags.exclude, "exclude", nil, "Skip files and directories matching this glob pattern, or regular expression prefixed with re:, may be repeated")
+	cmd.Flags().StringVar(&flags.minSize, "min-size", "", "Skip files smaller than this size (eg; 100K)")
+	cmd.Flags().StringVar(&flags.maxSize, "max-size", "", "Skip files larger than this size (eg; 2G)")
+	cmd.Flags().StringArrayVar(&flags.mediaTypes, "media-type", nil, "Only walk files of this media type (photo, video or raw), may be repeated")
+	cmd.Flags().StringVar(&flags.since, "since", "", "Skip files captured before this date (2006-01-02) or this long ago (eg; 14d)")
+	cmd.Flags().StringVar(&flags.until, "until", "", "Skip files captured at or after this date (2006-01-02) or this long ago (eg; 1d)")
+}
+
+// filter returns the flags parsed as a filter.Filter.
+func (flags filterFlags) filter() (filter.Filter, error) {
+	f := filter.Filter{}
+	for _, s := range flags.include {
+		p, err := filter.ParsePattern(s)
+		if err != nil {
+			return f, err
+		}
+		f.Include = append(f.Include, p)
+	}
+	for _, s := range flags.exclude {
+		p, err := filter.ParsePattern(s)
+		if err != nil {
+			return f, err
+		}
+		f.Exclude = append(f.Exclude, p)
+	}
+
+	var err error
+	if flags.minSize != "" {
+		if f.MinSize, err = filter.ParseSize(flags.minSize); err != nil {
+			return f, err
+		}
+	}
+	if flags.maxSize != "" {
+		if f.MaxSize, err = filter.ParseSize(flags.maxSize); err != nil {
+			return f, err
+		}
+	}
+
+	for _, s := range flags.mediaTypes {
+		t, err := filter.ParseMediaType(s)
+		if err != nil {
+			return f, err
+		}
+		f.MediaTypes = append(f.MediaTypes, t)
+	}
+
+	now := time.Now()
+	if f.Since, err = filter.ParseTime(flags.since, now); err != nil {
+		return f, err
+	}
+	if f.Until, err = filter.ParseTime(flags.until, now); err != nil {
+		return f, err
+	}
+	return f, nil
+}
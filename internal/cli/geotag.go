@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/gpx"
+	"pt/internal/store"
+	"pt/internal/tag"
+	"pt/internal/walk"
+	"pt/internal/xmp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func geotagCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+		gpxFiles       []string
+		deviceName     string
+		timezone       string
+		offset         string
+		maxGap         time.Duration
+		overwrite      bool
+		sidecar        bool
+		backupDir      string
+		noBackup       bool
+		dryRun         bool
+		walk           walkFlags
+	}
+	var cmd = &cobra.Command{
+		Use: "geotag path...",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if len(args) == 0 {
+				return errors.New("no files to geotag")
+			}
+			if len(flags.gpxFiles) == 0 {
+				return errors.New("no GPX files given with --gpx")
+			}
+
+			track, err := gpx.ReadFiles(flags.gpxFiles...)
+			if err != nil {
+				return err
+			}
+
+			// offset is added to the camera time to get the time of the
+			// track.
+			var offset time.Duration
+			if flags.offset != "" {
+				if offset, err = file.ParseTimeShift(flags.offset); err != nil {
+					return err
+				}
+			}
+
+			var location *time.Location
+			if flags.timezone != "" {
+				if location, err = time.LoadLocation(flags.timezone); err != nil {
+					return err
+				}
+			}
+
+			pathLayout, err := cli.config.pathLayout()
+			if err != nil {
+				return err
+			}
+			deviceLocations, err := cli.config.deviceLocations()
+			if err != nil {
+				return err
+			}
+			clockOffsets, err := cli.config.clockOffsets()
+			if err != nil {
+				return err
+			}
+
+			opts := tag.Options{Sidecar: flags.sidecar}
+			if !flags.noBackup {
+				opts.BackupDir = cli.backupDir(flags.backupDir)
+			}
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+			destinationDir, err = filepath.Abs(destinationDir)
+			if err != nil {
+				return err
+			}
+
+			walkOptions, err := flags.walk.options()
+			if err != nil {
+				return err
+			}
+			walker := walk.New(walkOptions)
+			paths := []string{}
+			for _, p := range args {
+				p, err := filepath.Abs(p)
+				if err != nil {
+					return err
+				}
+				paths = append(paths, p)
+			}
+			files, err := walkFiles(walker, paths)
+			if err != nil {
+				return err
+			}
+
+			var matched, unmatched, skipped int
+			var maxGap time.Duration
+			for _, gf := range files {
+				supported, err := file.IsSupportedFileType(gf.filePath)
+				if err != nil && err != fileutil.ErrUnknownFileType {
+					return err
+				}
+				if !supported {
+					continue
+				}
+
+				// The device name recorded by copy is used for files
+				// in the archive, falling back to the one in the path.
+				deviceName := flags.deviceName
+				var meta map[string]string
+				if strings.HasPrefix(gf.filePath, destinationDir+string(os.PathSeparator)) {
+					relPath := store.RelPath(destinationDir, gf.filePath)
+					if meta, err = recordedMeta(ctx, db, relPath); err != nil {
+						return err
+					}
+					recordedDevice, ok := meta[store.MetaDevice]
+					if !ok {
+						archivePath, inArchive := file.ParseArchivePath(relPath, pathLayout)
+						recordedDevice, ok = archivePath.DeviceName, inArchive
+					}
+					if deviceName == "" && ok {
+						deviceName = recordedDevice
+					}
+				}
+
+				f := file.NewFile(gf.filePath, gf.info)
+				if location != nil {
+					f = f.WithOptions(file.WithLocation(location))
+				} else if loc, ok := deviceLocations[deviceName]; ok {
+					f = f.WithOptions(file.WithLocation(loc))
+				}
+
+				if _, ok := f.GPS(); ok && !flags.overwrite {
+					skipped++
+					fmt.Fprintf(cli.stdout(), "geotag %s: already has a location\n", gf.filePath)
+					continue
+				}
+
+				// The recorded timestamp was resolved without --timezone.
+				if location != nil {
+					meta = nil
+				}
+				timestamp, source := geotagTimestamp(f, meta, deviceName, clockOffsets)
+				// The capture time of photos is the wall clock of the
+				// camera, without a timezone it is matched as UTC. The
+				// creation time of videos is UTC.
+				if source == file.TimestampSourceMetadata && f.MediaType() != file.MediaTypeVideo {
+					fmt.Fprintf(os.Stderr, "geotag %s: warning: the capture time has no timezone and is matched as UTC, set --timezone or the device timezone\n", gf.filePath)
+				}
+
+				p, gap, ok := track.Locate(timestamp.Add(offset), flags.maxGap)
+				if !ok {
+					unmatched++
+					fmt.Fprintf(cli.stdout(), "geotag %s: no match (nearest track point %s away)\n", gf.filePath, gap)
+					continue
+				}
+				matched++
+				if gap > maxGap {
+					maxGap = gap
+				}
+				fmt.Fprintf(cli.stdout(), "geotag %s: %s (%s from track point)\n", gf.filePath, p, gap)
+
+				if flags.dryRun {
+					continue
+				}
+
+				props := xmp.Properties{GPS: &p}
+				if _, err := tag.Write(gf.filePath, props, opts); err != nil {
+					return err
+				}
+				if err := recordTag(ctx, db, destinationDir, gf.filePath, props); err != nil {
+					return err
+				}
+			}
+
+			cli.printSkipped(walker)
+			fmt.Fprintf(cli.stdout(), "matched %d, unmatched %d, skipped %d, max gap %s\n", matched, unmatched, skipped, maxGap)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().StringArrayVar(&flags.gpxFiles, "gpx", nil, "GPX track file, may be repeated")
+	cmd.Flags().StringVar(&flags.deviceName, "device", "", "Device name used for its timezone and clock offsets (default: recorded by copy, or from the archive path)")
+	cmd.Flags().StringVar(&flags.timezone, "timezone", "", "Timezone of the camera clock when the files don't record one (eg; Europe/Paris)")
+	cmd.Flags().StringVar(&flags.offset, "offset", "", "Amount to add to the camera time to match the GPS time (eg; -1m30s)")
+	cmd.Flags().DurationVar(&flags.maxGap, "max-gap", 5*time.Minute, "Maximum time between a file and the nearest track point")
+	cmd.Flags().BoolVar(&flags.overwrite, "overwrite", false, "Geotag files which already have a location")
+	cmd.Flags().BoolVar(&flags.sidecar, "sidecar", false, "Write an XMP sidecar for JPEGs instead of modifying them")
+	cmd.Flags().StringVar(&flags.backupDir, "backup-dir", "", "Directory to back up modified files to (default: backup next to the database)")
+	cmd.Flags().BoolVar(&flags.noBackup, "no-backup", false, "Don't back up modified files")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the matches without writing them")
+	addWalkFlags(cmd, &flags.walk)
+	return cmd
+}
+
+// geotagTimestamp returns the capture time of f. The timestamp recorded in
+// meta, the meta values of the file in the database, is preferred as it
+// includes earlier clock corrections, otherwise the configured clock offsets
+// for the device are applied. Where the timezone of the timestamp came from
+// is returned with it.
+func geotagTimestamp(f file.File, meta map[string]string, deviceName string, clockOffsets file.ClockOffsets) (time.Time, file.TimestampSource) {
+	if t, err := time.Parse(store.TimeFormat, meta[store.MetaTimestamp]); err == nil {
+		return t, file.TimestampSource(meta[store.MetaTimestampSource])
+	}
+
+	t, source := f.TimestampWithSource()
+	return t.Add(clockOffsets.Offset(deviceName, t)), source
+}
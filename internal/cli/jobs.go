@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"pt/internal/iolimit"
+	"runtime"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultJobs is the number of workers in each pool.
+const defaultJobs = 4
+
+// Worker pools of the commands that process files in parallel.
+const (
+	poolProbe = "probe"
+	poolHash  = "hash"
+	poolCopy  = "copy"
+)
+
+// jobsConfig is the number of workers in each pool set in the config file.
+type jobsConfig struct {
+	Probe int `json:"probe,omitempty"`
+	Hash  int `json:"hash,omitempty"`
+	Copy  int `json:"copy,omitempty"`
+
+	// Adaptive limits the reads from each source device as --jobs auto
+	// does.
+	Adaptive bool `json:"adaptive,omitempty"`
+}
+
+// jobsFlags are the flags setting the number of workers of a command.
+type jobsFlags struct {
+	jobs  string
+	pools map[string]*int
+}
+
+// addJobsFlags adds --jobs and a --<pool>-jobs flag for each of pools to cmd.
+func addJobsFlags(cmd *cobra.Command, flags *jobsFlags, pools ...string) {
+	cmd.Flags().StringVar(&flags.jobs, "jobs", "", fmt.Sprintf("Number of workers in each pool (default %d), or auto to read one file at a time from spinning disks and SD cards", defaultJobs))
+	flags.pools = map[string]*int{}
+	for _, pool := range pools {
+		flags.pools[pool] = new(int)
+		cmd.Flags().IntVar(flags.pools[pool], pool+"-jobs", 0, fmt.Sprintf("Number of %s workers, overriding --jobs", pool))
+	}
+}
+
+// workerPools is the number of workers in each pool of a command.
+type workerPools struct {
+	sizes map[string]int
+
+	// limiter limits the reads from each source device, it is nil unless
+	// the pools are adaptive.
+	limiter *iolimit.Limiter
+}
+
+// size returns the number of workers in pool.
+func (p workerPools) size(pool string) int {
+	return p.sizes[pool]
+}
+
+// workerPools returns the pools set by the jobs config and flags.
+func (c *cli) workerPools(flags jobsFlags) (workerPools, error) {
+	p := workerPools{sizes: map[string]int{}}
+	for pool := range flags.pools {
+		p.sizes[pool] = defaultJobs
+	}
+
+	adaptive := false
+	if cfg := c.config.Jobs; cfg != nil {
+		for pool, n := range map[string]int{poolProbe: cfg.Probe, poolHash: cfg.Hash, poolCopy: cfg.Copy} {
+			if n < 0 {
+				return p, fmt.Errorf("jobs: %s must not be negative", pool)
+			}
+			if _, ok := p.sizes[pool]; ok && n > 0 {
+				p.sizes[pool] = n
+			}
+		}
+		adaptive = cfg.Adaptive
+	}
+
+	switch flags.jobs {
+	case "":
+	case "auto":
+		// Reads from each device are limited, so solid state drives
+		// can be given more workers.
+		adaptive = true
+		for pool := range p.sizes {
+			if n := runtime.NumCPU(); n > p.sizes[pool] {
+				p.sizes[pool] = n
+			}
+		}
+	default:
+		n, err := strconv.Atoi(flags.jobs)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("--jobs must be a number above zero or auto: %q", flags.jobs)
+		}
+		for pool := range p.sizes {
+			p.sizes[pool] = n
+		}
+	}
+
+	for pool, n := range flags.pools {
+		if *n < 0 {
+			return p, fmt.Errorf("--%s-jobs must not be negative", pool)
+		}
+		if *n > 0 {
+			p.sizes[pool] = *n
+		}
+	}
+
+	if adaptive {
+		p.limiter = iolimit.New()
+	}
+	return p, nil
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"pt/internal/filter"
+	"pt/internal/logwrap"
+
+	"github.com/spf13/cobra"
+)
+
+// Defaults of log_max_size and log_max_files.
+const (
+	defaultLogMaxSize  = "10M"
+	defaultLogMaxFiles = 5
+)
+
+// logFlags are the global flags setting where log messages go.
+type logFlags struct {
+	level     string
+	format    string
+	file      string
+	fileLevel string
+}
+
+// addLogFlags adds the log flags to the persistent flags of cmd.
+func addLogFlags(cmd *cobra.Command, flags *logFlags) {
+	cmd.PersistentFlags().StringVar(&flags.level, "log-level", "none", "Level of messages logged to stderr (none, debug, info, warning, error)")
+	cmd.PersistentFlags().StringVar(&flags.format, "log-format", logwrap.FormatText, "Format of log messages (text or json)")
+	cmd.PersistentFlags().StringVar(&flags.file, "log-file", "", "File to log to as well as stderr, rotated at log_max_size")
+	cmd.PersistentFlags().StringVar(&flags.fileLevel, "log-file-level", "info", "Level of messages logged to --log-file")
+}
+
+// setupLogging creates the pt logger, writing to stderr and the --log-file.
+// --debug logs debug messages to stderr.
+func (c *cli) setupLogging() error {
+	switch c.log.format {
+	case logwrap.FormatText, logwrap.FormatJSON:
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", c.log.format)
+	}
+	level, err := logwrap.ParseLevel(c.log.level)
+	if err != nil {
+		return err
+	}
+	if c.debug {
+		level = logwrap.DEBUG
+	}
+	outputs := []logwrap.Output{{W: os.Stderr, Level: level, Format: c.log.format, Source: c.debug}}
+
+	if c.log.file != "" {
+		fileLevel, err := logwrap.ParseLevel(c.log.fileLevel)
+		if err != nil {
+			return err
+		}
+		maxSize, maxFiles, err := c.config.logRotation()
+		if err != nil {
+			return err
+		}
+		f, err := logwrap.OpenRotatingFile(c.log.file, maxSize, maxFiles)
+		if err != nil {
+			return err
+		}
+		c.logCloser = f
+		outputs = append(outputs, logwrap.Output{W: f, Level: fileLevel, Format: c.log.format})
+	}
+
+	logwrap.New("pt", outputs...)
+	return nil
+}
+
+// logRotation returns the size log files are rotated at and the number of
+// rotated files kept, log_max_size and log_max_files or their defaults.
+func (cfg config) logRotation() (int64, int, error) {
+	maxSize := cfg.LogMaxSize
+	if maxSize == "" {
+		maxSize = defaultLogMaxSize
+	}
+	size, err := filter.ParseSize(maxSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("log_max_size: %w", err)
+	}
+	maxFiles := cfg.LogMaxFiles
+	if maxFiles == 0 {
+		maxFiles = defaultLogMaxFiles
+	}
+	return size, maxFiles, nil
+}
+
+// closeLog closes the --log-file.
+func (c *cli) closeLog() error {
+	if c.logCloser == nil {
+		return nil
+	}
+	return c.logCloser.Close()
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"pt/internal/eventstream"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Values of --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// jsonOutput returns true if the summary of the run is written as JSON.
+func (c *cli) jsonOutput() bool {
+	return c.output == outputJSON
+}
+
+// stdout returns where the output of commands meant to be read by people is
+// written. With --output json it is stderr, leaving stdout to the report.
+func (c *cli) stdout() io.Writer {
+	if c.jsonOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// openOutput checks --output and opens the --events stream.
+func (c *cli) openOutput() error {
+	switch c.output {
+	case outputText, outputJSON:
+	default:
+		return fmt.Errorf("invalid output %q, expected text or json", c.output)
+	}
+
+	switch c.eventsFile {
+	case "":
+	case "-":
+		if c.jsonOutput() {
+			return errors.New("--events - can't be used with --output json as both are written to stdout")
+		}
+		c.events = eventstream.New(os.Stdout)
+	default:
+		fh, err := os.Create(c.eventsFile)
+		if err != nil {
+			return err
+		}
+		c.events, c.eventsCloser = eventstream.New(fh), fh
+	}
+	return nil
+}
+
+// closeEvents closes the --events stream, returning the error of the first
+// event that couldn't be written.
+func (c *cli) closeEvents() error {
+	err := c.events.Err()
+	if c.eventsCloser != nil {
+		if closeErr := c.eventsCloser.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// printReport writes the report of cmd, which ran from started and failed
+// with err if it isn't nil, to w as JSON.
+func (c *cli) printReport(w io.Writer, root, cmd *cobra.Command, started time.Time, err error) {
+	r := c.report
+	if cmd != nil {
+		r.Command = strings.TrimPrefix(cmd.CommandPath(), root.Name()+" ")
+	}
+	r.Started = started
+	r.Finished = time.Now()
+	r.DurationSeconds = r.Finished.Sub(started).Seconds()
+	if sig := c.interruptedBy(); sig != nil {
+		r.Interrupted = sig.String()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(r)
+}
@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"os"
+	"pt/internal/progress"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// progressFlags are the flags of commands reporting their progress.
+type progressFlags struct {
+	mode     string
+	interval time.Duration
+}
+
+// addProgressFlags adds the progress flags to cmd.
+func addProgressFlags(cmd *cobra.Command, flags *progressFlags) {
+	cmd.Flags().StringVar(&flags.mode, "progress", string(progress.ModeAuto), "How progress is reported: auto (a live line on a terminal, plain otherwise), plain or none")
+	cmd.Flags().DurationVar(&flags.interval, "progress-interval", 30*time.Second, "How often plain progress lines are written")
+}
+
+// reporter returns the progress reporter of command, writing to stderr so
+// it doesn't mix with the output of the command. It is nil if progress
+// isn't reported.
+func (flags progressFlags) reporter(command string) (*progress.Reporter, error) {
+	mode, err := progress.ParseMode(flags.mode)
+	if err != nil {
+		return nil, err
+	}
+	return progress.New(os.Stderr, command, mode, flags.interval), nil
+}
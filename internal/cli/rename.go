@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/movelog"
+	"pt/internal/walk"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func renameCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		timezone   string
+		useModTime bool
+		moveLogDir string
+		dryRun     bool
+		walk       walkFlags
+	}
+	var cmd = &cobra.Command{
+		Use:         "rename dir...",
+		Annotations: map[string]string{annotationGraceful: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no directories to rename files in")
+			}
+
+			var location *time.Location
+			if flags.timezone != "" {
+				var err error
+				if location, err = time.LoadLocation(flags.timezone); err != nil {
+					return err
+				}
+			}
+
+			walkOptions, err := flags.walk.options()
+			if err != nil {
+				return err
+			}
+			walker := walk.New(walkOptions)
+
+			// Collect the files before renaming any so renamed files are
+			// not walked again.
+			files, err := walkFiles(walker, args)
+			if err != nil {
+				return err
+			}
+
+			// Plan every rename before making any so files are not
+			// renamed onto each other.
+			moves := []movelog.Move{}
+			taken := map[string]bool{}
+			for _, rf := range files {
+				f := file.NewFile(rf.filePath, rf.info)
+				supported, err := f.IsSupported()
+				if err != nil && err != fileutil.ErrUnknownFileType {
+					return err
+				}
+				if !supported {
+					continue
+				}
+
+				if location != nil {
+					f = f.WithOptions(file.WithLocation(location))
+				}
+				timestamp, source := f.TimestampWithSource()
+				if source == file.TimestampSourceModTime && !flags.useModTime {
+					fmt.Fprintf(cli.stdout(), "skip %s: no capture time in its metadata\n", rf.filePath)
+					continue
+				}
+
+				dir := filepath.Dir(rf.filePath)
+				if sameDestination(rf.filePath, filepath.Join(dir, f.FileName(timestamp))) {
+					continue
+				}
+				dst, err := uniqueFilePath(rf.filePath, func(opts ...file.Option) string {
+					return filepath.Join(dir, f.FileName(timestamp, opts...))
+				}, taken)
+				if err != nil {
+					return err
+				}
+
+				moves = append(moves, movelog.Move{Src: rf.filePath, Dst: dst})
+				fmt.Fprintf(cli.stdout(), "rename %s: %s\n", rf.filePath, filepath.Base(dst))
+			}
+
+			cli.printSkipped(walker)
+			fmt.Fprintf(cli.stdout(), "%d files to rename\n", len(moves))
+			if flags.dryRun || len(moves) == 0 {
+				return nil
+			}
+
+			moveLogDir := filepath.Join(filepath.Dir(cli.config.DBFile), "movelog")
+			if flags.moveLogDir != "" {
+				moveLogDir = flags.moveLogDir
+			}
+			log, err := movelog.Create(moveLogDir, "rename")
+			if err != nil {
+				return err
+			}
+			defer log.Close()
+			fmt.Fprintf(cli.stdout(), "move log: %s\n", log.Path())
+
+			for i, m := range moves {
+				if err := cli.stopMoves(cmd, i); err != nil {
+					return err
+				}
+				if err := moveWithSidecars(log, m.Src, m.Dst, nil); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.timezone, "timezone", "", "Timezone of the camera clock when the files don't record one (eg; Europe/Paris)")
+	cmd.Flags().BoolVar(&flags.useModTime, "use-mtime", false, "Name files without a capture time in their metadata after their modification time")
+	cmd.Flags().StringVar(&flags.moveLogDir, "move-log-dir", "", "Directory to write the move log to (default: movelog next to the database)")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the renames without making them")
+	addWalkFlags(cmd, &flags.walk)
+	return cmd
+}
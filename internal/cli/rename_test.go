@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"pt/internal/jpegmeta/jpegmetatest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameUndo(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "from-mum")
+	moveLogDir := filepath.Join(t.TempDir(), "movelog")
+	c := &cli{}
+
+	// The JPEGs have no capture time, they're named after their
+	// modification time.
+	mtime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFiles(t, filepath.Join(dir, "IMG_0001.xmp"), filepath.Join(dir, "20220102-030405000.xmp"))
+	for _, name := range []string{"IMG_0001.JPG", "IMG_0002.JPG"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, jpegmetatest.JFIF(), 0644))
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+	}
+
+	// Without --use-mtime nothing is renamed.
+	require.NoError(t, runCmd(ctx, renameCmd(c), "--move-log-dir", moveLogDir, dir))
+	assert.NoDirExists(t, moveLogDir)
+
+	// The sidecar of IMG_0001.JPG would replace 20220102-030405000.xmp, so
+	// it gets a suffix along with its sidecar. IMG_0002.JPG has no sidecar
+	// and gets the name without a suffix.
+	renamed := map[string]string{
+		"IMG_0001.JPG": "20220102-030405000-1.JPG",
+		"IMG_0001.xmp": "20220102-030405000-1.xmp",
+		"IMG_0002.JPG": "20220102-030405000.JPG",
+	}
+	require.NoError(t, runCmd(ctx, renameCmd(c), "--use-mtime", "--move-log-dir", moveLogDir, dir))
+	for src, dst := range renamed {
+		assert.NoFileExists(t, filepath.Join(dir, src))
+		assert.FileExists(t, filepath.Join(dir, dst))
+	}
+	assert.FileExists(t, filepath.Join(dir, "20220102-030405000.xmp"))
+
+	// The names are already those of the archive.
+	require.NoError(t, runCmd(ctx, renameCmd(c), "--use-mtime", "--move-log-dir", moveLogDir, dir))
+	logs, err := filepath.Glob(filepath.Join(moveLogDir, "*"))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+
+	require.NoError(t, runCmd(ctx, undoCmd(c), "--destination-dir", t.TempDir(), logs[0]))
+	for src, dst := range renamed {
+		assert.FileExists(t, filepath.Join(dir, src))
+		assert.NoFileExists(t, filepath.Join(dir, dst))
+	}
+}
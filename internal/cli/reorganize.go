@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/movelog"
+	"pt/internal/store"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// plannedMove is an archived file to be moved to dst along with the device
+// name and album it is moved with.
+type plannedMove struct {
+	af         archivedFile
+	src        string
+	dst        string
+	deviceName string
+	album      string
+}
+
+func reorganizeCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+		moveLogDir     string
+		dryRun         bool
+	}
+	var cmd = &cobra.Command{
+		Use:         "reorganize",
+		Annotations: map[string]string{annotationGraceful: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+			destinationDir, err = filepath.Abs(destinationDir)
+			if err != nil {
+				return err
+			}
+
+			pathLayout, err := cli.config.pathLayout()
+			if err != nil {
+				return err
+			}
+
+			deviceRules, err := cli.config.deviceRules()
+			if err != nil {
+				return err
+			}
+
+			files, err := loadArchivedFiles(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			// Plan every move before making any so files are not moved
+			// onto each other.
+			moves := []plannedMove{}
+			taken := map[string]bool{}
+			for _, af := range files {
+				src := filepath.Join(destinationDir, af.hash.Filepath)
+				timestamp, ok := af.timestamp()
+				if !ok {
+					fmt.Fprintf(cli.stdout(), "skip %s: no recorded timestamp, run pt scan first\n", src)
+					continue
+				}
+
+				deviceName, album, ok := af.deviceAlbum(pathLayout)
+				if !ok {
+					fmt.Fprintf(cli.stdout(), "skip %s: unknown device and album\n", src)
+					continue
+				}
+
+				// Files copied since the source path was recorded get
+				// their device name and album from the current rules.
+				if sourcePath := af.meta[store.MetaSourcePath]; sourcePath != "" {
+					deviceName = deviceRules.Match(sourcePath, file.NewFile(src, nil).Camera())
+					album = file.NewFile(sourcePath, nil).Album()
+				}
+				if name := af.meta[store.MetaEventName]; name != "" {
+					album = name
+				}
+
+				f := file.NewFile(src, nil).WithOptions(file.WithAlbum(album), file.WithPathLayout(pathLayout))
+				if sameDestination(src, f.DestinationFilePath(destinationDir, deviceName, timestamp)) {
+					continue
+				}
+
+				dst, err := uniqueDestinationFilePath(f, destinationDir, deviceName, timestamp, taken)
+				if err != nil {
+					return err
+				}
+				moves = append(moves, plannedMove{af, src, dst, deviceName, album})
+				fmt.Fprintf(cli.stdout(), "move %s: %s\n", src, dst)
+			}
+
+			fmt.Fprintf(cli.stdout(), "%d of %d files to move\n", len(moves), len(files))
+			if flags.dryRun || len(moves) == 0 {
+				return nil
+			}
+
+			moveLogDir := filepath.Join(filepath.Dir(cli.config.DBFile), "movelog")
+			if flags.moveLogDir != "" {
+				moveLogDir = flags.moveLogDir
+			}
+			log, err := movelog.Create(moveLogDir, "reorganize")
+			if err != nil {
+				return err
+			}
+			defer log.Close()
+			fmt.Fprintf(cli.stdout(), "move log: %s\n", log.Path())
+
+			// A move that was started is recorded in the database even
+			// if a signal stops the command.
+			ctx = context.WithoutCancel(ctx)
+			for i, m := range moves {
+				if err := cli.stopMoves(cmd, i); err != nil {
+					return err
+				}
+				meta := map[string]string{
+					store.MetaDevice: m.deviceName,
+					store.MetaAlbum:  m.album,
+				}
+				if err := moveRecorded(ctx, db, log, m.src, m.dst, m.af.previousMeta(meta), func(exec boil.ContextExecutor) error {
+					if err := store.UpdateFilepath(ctx, exec, m.af.hash.Filepath, store.RelPath(destinationDir, m.dst)); err != nil {
+						return err
+					}
+					return store.SetMetaMap(ctx, exec, m.af.hash.ID, meta)
+				}); err != nil {
+					return err
+				}
+				removeEmptyDirs(filepath.Dir(m.src), destinationDir)
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().StringVar(&flags.moveLogDir, "move-log-dir", "", "Directory to write the move log to (default: movelog next to the database)")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the moves without making them")
+	return cmd
+}
+
+// sameDestination returns true if src is already at dst, allowing for a
+// numbered suffix added to the file name of src to avoid a collision.
+func sameDestination(src, dst string) bool {
+	if filepath.Dir(src) != filepath.Dir(dst) || filepath.Ext(src) != filepath.Ext(dst) {
+		return false
+	}
+	srcName := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	dstName := strings.TrimSuffix(filepath.Base(dst), filepath.Ext(dst))
+	return srcName == dstName || strings.HasPrefix(srcName, dstName+"-")
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"pt/internal/fileutil"
+	"pt/internal/jpegmeta/jpegmetatest"
+	"pt/internal/store"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestArchive returns a cli with an empty archive and database in a
+// temporary directory, along with the database.
+func newTestArchive(t *testing.T) (*cli, *sql.DB) {
+	t.Helper()
+	db, dbFile := openTestDB(t)
+	c := &cli{config: config{DBFile: dbFile, DestinationDir: filepath.Join(t.TempDir(), "photos")}}
+	return c, db
+}
+
+// archiveFile writes a JPEG at rel within the archive of c and records it
+// with meta.
+func archiveFile(t *testing.T, c *cli, db *sql.DB, rel string, meta map[string]string) {
+	t.Helper()
+	p := filepath.Join(c.config.DestinationDir, rel)
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, os.WriteFile(p, append(jpegmetatest.JFIF(), rel...), 0644))
+	hash, err := fileutil.GetFileHash(p)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	h, err := store.InsertHash(ctx, db, rel, hash)
+	require.NoError(t, err)
+	require.NoError(t, store.SetMetaMap(ctx, db, h.ID, meta))
+}
+
+// runCmd runs cmd with args and ctx.
+func runCmd(ctx context.Context, cmd *cobra.Command, args ...string) error {
+	cmd.SetArgs(args)
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	return cmd.ExecuteContext(ctx)
+}
+
+// archivedMeta returns the meta values of the file recorded at rel.
+func archivedMeta(t *testing.T, db *sql.DB, rel string) map[string]string {
+	t.Helper()
+	meta, err := recordedMeta(context.Background(), db, rel)
+	require.NoError(t, err)
+	require.NotNil(t, meta, rel)
+	return meta
+}
+
+func TestReorganizeUndo(t *testing.T) {
+	ctx := context.Background()
+	c, db := newTestArchive(t)
+	c.config.PathLayout = "{{.Year}}/{{.Device}}"
+	c.config.DeviceNames = map[string][]string{"camera": {"card"}}
+	photos := c.config.DestinationDir
+	moveLogDir := filepath.Join(t.TempDir(), "movelog")
+
+	// The first two files have the same destination in the new layout, the
+	// third gets its device name from the path it was copied from.
+	beach := map[string]string{store.MetaDevice: "phone", store.MetaAlbum: "Beach", store.MetaTimestamp: "2022-01-02T03:04:05.000Z"}
+	archiveFile(t, c, db, "2022/01/phone/Beach/20220102-030405000.JPG", beach)
+	require.NoError(t, os.WriteFile(filepath.Join(photos, "2022/01/phone/Beach/20220102-030405000.xmp"), []byte("<x:xmpmeta/>"), 0644))
+	archiveFile(t, c, db, "2022/01/phone/Recents/20220102-030405000.JPG", map[string]string{store.MetaDevice: "phone", store.MetaAlbum: "Recents", store.MetaTimestamp: "2022-01-02T03:04:05.000Z"})
+	archiveFile(t, c, db, "2022/01/phone/Recents/20220103-000000000.JPG", map[string]string{
+		store.MetaDevice: "phone", store.MetaAlbum: "Recents", store.MetaTimestamp: "2022-01-03T00:00:00.000Z",
+		store.MetaSourcePath: "/media/card/DCIM/100CANON/IMG_0003.JPG",
+	})
+	before := map[string]string{
+		"2022/01/phone/Beach/20220102-030405000.JPG":   "2022/phone/20220102-030405000.JPG",
+		"2022/01/phone/Beach/20220102-030405000.xmp":   "2022/phone/20220102-030405000.xmp",
+		"2022/01/phone/Recents/20220102-030405000.JPG": "2022/phone/20220102-030405000-1.JPG",
+		"2022/01/phone/Recents/20220103-000000000.JPG": "2022/camera/20220103-000000000.JPG",
+	}
+
+	require.NoError(t, runCmd(ctx, reorganizeCmd(c), "--move-log-dir", moveLogDir))
+	for src, dst := range before {
+		assert.NoFileExists(t, filepath.Join(photos, src))
+		assert.FileExists(t, filepath.Join(photos, dst))
+	}
+	assert.Equal(t, "camera", archivedMeta(t, db, "2022/camera/20220103-000000000.JPG")[store.MetaDevice])
+	assert.Equal(t, "Recents", archivedMeta(t, db, "2022/phone/20220102-030405000-1.JPG")[store.MetaAlbum])
+	assert.NoDirExists(t, filepath.Join(photos, "2022/01"))
+
+	// The files are where the layout puts them, including the one with a
+	// suffix, so nothing is moved again.
+	require.NoError(t, runCmd(ctx, reorganizeCmd(c), "--move-log-dir", moveLogDir))
+	logs, err := filepath.Glob(filepath.Join(moveLogDir, "*"))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+
+	require.NoError(t, runCmd(ctx, undoCmd(c), logs[0]))
+	for src, dst := range before {
+		assert.FileExists(t, filepath.Join(photos, src))
+		assert.NoFileExists(t, filepath.Join(photos, dst))
+	}
+	assert.Equal(t, beach, archivedMeta(t, db, "2022/01/phone/Beach/20220102-030405000.JPG"))
+	meta := archivedMeta(t, db, "2022/01/phone/Recents/20220103-000000000.JPG")
+	assert.Equal(t, "phone", meta[store.MetaDevice])
+	assert.Equal(t, "Recents", meta[store.MetaAlbum])
+	assert.NoDirExists(t, filepath.Join(photos, "2022/phone"))
+}
+
+func TestSameDestination(t *testing.T) {
+	tests := []struct {
+		src  string
+		dst  string
+		want bool
+	}{
+		{"/photos/2022/20220102-030405000.JPG", "/photos/2022/20220102-030405000.JPG", true},
+		{"/photos/2022/20220102-030405000-1.JPG", "/photos/2022/20220102-030405000.JPG", true},
+		{"/photos/2022/20220102-030405000-1.JPG", "/photos/2023/20220102-030405000.JPG", false},
+		{"/photos/2022/20220102-030405000.CR2", "/photos/2022/20220102-030405000.JPG", false},
+		{"/photos/2022/20220102-030405001.JPG", "/photos/2022/20220102-030405000.JPG", false},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.src), func(t *testing.T) {
+			assert.Equal(t, tt.want, sameDestination(tt.src, tt.dst))
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/store"
+	"pt/internal/walk"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+func retimeCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+		deviceName     string
+		from           string
+		until          string
+		timeShift      string
+		dryRun         bool
+		walk           walkFlags
+	}
+	var cmd = &cobra.Command{
+		Use:         "retime [path...]",
+		Annotations: map[string]string{annotationGraceful: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+
+			deviceLocations, err := cli.config.deviceLocations()
+			if err != nil {
+				return err
+			}
+
+			clockOffsets, err := cli.config.clockOffsets()
+			if err != nil {
+				return err
+			}
+
+			pathLayout, err := cli.config.pathLayout()
+			if err != nil {
+				return err
+			}
+
+			// Without --time-shift the configured clock offsets are applied.
+			var timeShift *time.Duration
+			if flags.timeShift != "" {
+				d, err := file.ParseTimeShift(flags.timeShift)
+				if err != nil {
+					return err
+				}
+				timeShift = &d
+			}
+
+			from, err := file.ParseClockTime(flags.from)
+			if err != nil {
+				return err
+			}
+			until, err := file.ParseClockTime(flags.until)
+			if err != nil {
+				return err
+			}
+			dateRange := file.ClockOffset{From: from, Until: until}
+
+			paths := args
+			if len(paths) == 0 {
+				paths = []string{destinationDir}
+			}
+
+			walkOptions, err := flags.walk.options()
+			if err != nil {
+				return err
+			}
+			walker := walk.New(walkOptions)
+
+			// Collect the files before moving any so moved files are not
+			// walked again.
+			files, err := walkFiles(walker, paths)
+			if err != nil {
+				return err
+			}
+
+			// A move that was started is recorded in the database even
+			// if a signal stops the command.
+			ctx = context.WithoutCancel(ctx)
+			moved := 0
+			for _, rf := range files {
+				if err := cli.stopMoves(cmd, moved); err != nil {
+					return err
+				}
+				supported, err := file.IsSupportedFileType(rf.filePath)
+				if err != nil && err != fileutil.ErrUnknownFileType {
+					return err
+				}
+				if !supported {
+					continue
+				}
+
+				relPath := store.RelPath(destinationDir, rf.filePath)
+				hash, err := store.FindHashByFilepath(ctx, db, relPath)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return err
+				}
+				meta := map[string]string{}
+				if hash != nil {
+					if meta, err = store.Meta(ctx, db, hash.ID); err != nil {
+						return err
+					}
+				}
+
+				// The device name and album recorded by copy are used
+				// if present, otherwise they're taken from the path,
+				// which can only be parsed with the default layout.
+				deviceName, ok := meta[store.MetaDevice]
+				album := meta[store.MetaAlbum]
+				if !ok {
+					archivePath, ok := file.ParseArchivePath(relPath, pathLayout)
+					if !ok {
+						return fmt.Errorf("%s has no recorded device and album and is not in the default path layout within %s", rf.filePath, destinationDir)
+					}
+					deviceName, album = archivePath.DeviceName, archivePath.Album
+				}
+				if flags.deviceName != "" && deviceName != flags.deviceName {
+					continue
+				}
+
+				f := file.NewFile(rf.filePath, rf.info).WithOptions(file.WithAlbum(album), file.WithPathLayout(pathLayout))
+				if loc, ok := deviceLocations[deviceName]; ok {
+					f = f.WithOptions(file.WithLocation(loc))
+				}
+
+				// Prefer the timestamp recorded in the database as it
+				// includes earlier corrections.
+				timestamp, err := time.Parse(store.TimeFormat, meta[store.MetaTimestamp])
+				if err != nil {
+					var timestampSource file.TimestampSource
+					timestamp, timestampSource = f.TimestampWithSource()
+					meta[store.MetaTimestampSource] = string(timestampSource)
+				}
+
+				applied, _ := time.ParseDuration(meta[store.MetaClockOffset])
+				cameraTimestamp := timestamp.Add(-applied)
+				if !dateRange.Contains(cameraTimestamp) {
+					continue
+				}
+
+				shift := clockOffsets.Offset(deviceName, cameraTimestamp) - applied
+				if timeShift != nil {
+					shift = *timeShift
+				}
+				if shift == 0 {
+					continue
+				}
+
+				timestamp = timestamp.Add(shift)
+				destinationFilePath, err := uniqueDestinationFilePath(f, destinationDir, deviceName, timestamp, nil)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cli.stdout(), "retime %s %s: %s\n", shift, rf.filePath, destinationFilePath)
+				if flags.dryRun {
+					continue
+				}
+
+				// A file missing from the database is hashed before it's
+				// moved so a failure leaves it where it was.
+				var fileHash string
+				if hash == nil {
+					if fileHash, err = fileutil.GetFileHash(rf.filePath); err != nil {
+						return err
+					}
+				}
+
+				newRelPath := store.RelPath(destinationDir, destinationFilePath)
+				if err := moveRecorded(ctx, db, nil, rf.filePath, destinationFilePath, nil, func(exec boil.ContextExecutor) error {
+					recorded := hash
+					if recorded == nil {
+						var err error
+						if recorded, err = store.InsertHash(ctx, exec, newRelPath, fileHash); err != nil {
+							return err
+						}
+					} else if err := store.UpdateFilepath(ctx, exec, relPath, newRelPath); err != nil {
+						return err
+					}
+
+					return store.SetMetaMap(ctx, exec, recorded.ID, map[string]string{
+						store.MetaTimestamp:       timestamp.Format(store.TimeFormat),
+						store.MetaTimestampSource: meta[store.MetaTimestampSource],
+						store.MetaClockOffset:     (applied + shift).String(),
+						store.MetaDevice:          deviceName,
+						store.MetaAlbum:           album,
+					})
+				}); err != nil {
+					return err
+				}
+				moved++
+			}
+
+			cli.printSkipped(walker)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().StringVar(&flags.deviceName, "device", "", "Only retime files from this device")
+	cmd.Flags().StringVar(&flags.from, "from", "", "Only retime files with a camera time at or after this date (2006-01-02)")
+	cmd.Flags().StringVar(&flags.until, "until", "", "Only retime files with a camera time before this date (2006-01-02)")
+	cmd.Flags().StringVar(&flags.timeShift, "time-shift", "", "Shift timestamps by this amount instead of the configured clock offsets (eg; +1h)")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the changes without making them")
+	addWalkFlags(cmd, &flags.walk)
+	return cmd
+}
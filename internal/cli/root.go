@@ -2,8 +2,9 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"os"
-	"path"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -17,7 +18,34 @@ func Execute() {
 	rootCmd.AddCommand(exifCmd(cli))
 	rootCmd.AddCommand(scanCmd(cli))
 	rootCmd.AddCommand(cr2DupeCmd(cli))
-	if err := rootCmd.ExecuteContext(context.TODO()); err != nil {
+	rootCmd.AddCommand(retimeCmd(cli))
+	rootCmd.AddCommand(tagCmd(cli))
+	rootCmd.AddCommand(geotagCmd(cli))
+	rootCmd.AddCommand(searchCmd(cli))
+	rootCmd.AddCommand(eventsCmd(cli))
+	rootCmd.AddCommand(reorganizeCmd(cli))
+	rootCmd.AddCommand(renameCmd(cli))
+	rootCmd.AddCommand(undoCmd(cli))
+	rootCmd.AddCommand(devicesCmd(cli))
+	rootCmd.AddCommand(configCmd(cli))
+	rootCmd.AddCommand(watchMountsCmd(cli))
+	started := time.Now()
+	cmd, err := rootCmd.ExecuteContextC(cli.handleSignals())
+	if closeErr := cli.closeEvents(); err == nil {
+		err = closeErr
+	}
+	if closeErr := cli.closeLog(); err == nil {
+		err = closeErr
+	}
+	if cli.jsonOutput() {
+		cli.printReport(os.Stdout, rootCmd, cmd, started, err)
+	}
+	// A signal can also stop a command through its cancelled context, such
+	// as while a command reads the database before moving files.
+	if sig := cli.interruptedBy(); sig != nil && (errors.Is(err, errInterrupted) || errors.Is(err, context.Canceled)) {
+		os.Exit(exitCode(sig))
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 }
@@ -26,10 +54,18 @@ func buildRootCmd(cli *cli) *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use: "pt",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return cli.setup(cmd.Context())
+			cli.setGraceful(cmd)
+			if err := cli.openOutput(); err != nil {
+				return err
+			}
+			return cli.setup(cmd)
 		},
 	}
-	rootCmd.PersistentFlags().BoolVar(&cli.debug, "debug", false, "Enable debug")
-	rootCmd.PersistentFlags().StringVar(&cli.configFile, "config-file", path.Join(os.Getenv("HOME"), ".config", "pt", "config.json"), "Config file path")
+	rootCmd.PersistentFlags().BoolVar(&cli.debug, "debug", false, "Enable debug, logging debug messages to stderr")
+	rootCmd.PersistentFlags().StringVar(&cli.output, "output", outputText, "How the summary of the run is written (text or json)")
+	rootCmd.PersistentFlags().StringVar(&cli.eventsFile, "events", "", "File to write a stream of JSON events for each file to, - for stdout")
+	addLogFlags(rootCmd, &cli.log)
+	rootCmd.PersistentFlags().StringVar(&cli.configFile, "config-file", os.Getenv("PT_CONFIG_FILE"), "Config file path (default: config.json, .yaml or .toml in $XDG_CONFIG_HOME/pt or $XDG_CONFIG_DIRS/pt)")
+	rootCmd.PersistentFlags().StringVar(&cli.profile, "profile", os.Getenv("PT_PROFILE"), "Profile of the config file to apply (eg; nas)")
 	return rootCmd
 }
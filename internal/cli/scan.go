@@ -5,64 +5,256 @@ import (
 	"database/sql"
 	"errors"
 	"os"
-	"path"
-	"path/filepath"
+	"pt/internal/eventstream"
 	"pt/internal/file"
 	"pt/internal/fileutil"
-	"pt/internal/model"
-	"strings"
+	"pt/internal/filter"
+	"pt/internal/geo"
+	"pt/internal/iolimit"
+	"pt/internal/logwrap"
+	"pt/internal/progress"
+	"pt/internal/store"
+	"pt/internal/summary"
+	"pt/internal/walk"
+	"sync"
+	"text/template"
+	"time"
 
-	"github.com/mattn/go-sqlite3"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"github.com/volatiletech/sqlboiler/v4/boil"
 	"golang.org/x/sync/errgroup"
 )
 
 type scanFile struct {
 	FilePath string
+	FileInfo os.FileInfo
 }
 
-func hasher(ctx context.Context, db *sql.DB, destinationDir string, c <-chan scanFile) error {
+// hasherConfig holds the settings shared by prober and hasher workers.
+type hasherConfig struct {
+	db              *sql.DB
+	destinationDir  string
+	pathLayout      *template.Template
+	deviceLocations map[string]*time.Location
+	filter          filter.Filter
+
+	// summary counts the outcome of each file. With keepGoing, files that
+	// fail are recorded in it instead of stopping the scan.
+	summary   *summary.Summary
+	keepGoing bool
+
+	// limiter limits the files read at once from each device, reads
+	// aren't limited if it is nil.
+	limiter *iolimit.Limiter
+
+	// progress is told of each file processed if it is not nil.
+	progress *progress.Reporter
+
+	// events receives an event for each file scanned or failed if it is
+	// not nil.
+	events *eventstream.Stream
+}
+
+// outcomeScanned is the outcome counted for each file hashed by scan.
+const outcomeScanned = "scanned"
+
+// probedFile is a file to hash along with the metadata read by prober.
+type probedFile struct {
+	scanFile
+	relPath         string
+	timestamp       time.Time
+	timestampSource file.TimestampSource
+	gps             *geo.Point
+}
+
+// finish counts f with outcome in cfg.summary and cfg.progress, as skipped
+// if it has no outcome. err is returned unless cfg.keepGoing is set and err is
+// the failure of f, which is recorded instead.
+func (cfg hasherConfig) finish(f scanFile, outcome string, err error) error {
+	size := f.FileInfo.Size()
+	if err != nil {
+		cfg.progress.Done(progress.OutcomeFailed, size)
+		cfg.events.Emit(eventstream.ErrorEvent(f.FilePath, err))
+		if cfg.keepGoing && cfg.summary.Fail(err) {
+			return nil
+		}
+		return err
+	}
+
+	if outcome == "" {
+		cfg.progress.Done(progress.OutcomeSkipped, size)
+		return nil
+	}
+	cfg.progress.Done(outcome, size)
+	cfg.summary.Add(outcome, size)
+	return nil
+}
+
+// prober reads the metadata of the files from c, sending the files to hash
+// to out.
+func prober(ctx context.Context, cfg hasherConfig, c <-chan scanFile, out chan<- probedFile) error {
 	for f := range c {
-		fileSupported, err := file.IsSupportedFileType(f.FilePath)
-		if err != nil && err != fileutil.ErrUnknownFileType {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		if !fileSupported {
+		cfg.progress.Begin(f.FilePath)
+		pf, err := probeScanFile(ctx, cfg, f)
+		if pf == nil {
+			if err := cfg.finish(f, "", err); err != nil {
+				return err
+			}
 			continue
 		}
+		select {
+		case out <- *pf:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
 
-		hash := model.Hash{Filepath: strings.TrimLeft(f.FilePath, destinationDir)}
-		hash.Hash, err = fileutil.GetFileHash(f.FilePath)
-		if err != nil {
+// hasher records the hashes of the files from c. Once ctx is cancelled no
+// more files are started, but a file being hashed is finished and recorded.
+func hasher(ctx context.Context, cfg hasherConfig, c <-chan probedFile) error {
+	for f := range c {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cfg.progress.Begin(f.FilePath)
+		if err := cfg.finish(f.scanFile, outcomeScanned, hashFile(ctx, cfg, f)); err != nil {
 			return err
 		}
+	}
 
-		if err = hash.Insert(ctx, db, boil.Infer()); err != nil {
-			var sqliteErr sqlite3.Error
-			if errors.As(err, &sqliteErr) {
-				if sqliteErr.Code != sqlite3.ErrConstraint {
-					return err
-				}
-			} else {
-				return err
-			}
+	return nil
+}
+
+// probeScanFile reads the metadata of f, returning nil if f is skipped.
+// Errors specific to f are returned as a *summary.Error.
+func probeScanFile(ctx context.Context, cfg hasherConfig, f scanFile) (*probedFile, error) {
+	release, err := cfg.limiter.Acquire(ctx, f.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	sf := file.NewFile(f.FilePath, f.FileInfo)
+	fileSupported, err := sf.IsSupported()
+	if err != nil && err != fileutil.ErrUnknownFileType {
+		return nil, summary.Wrap(summary.ClassRead, f.FilePath, err)
+	}
+	if !fileSupported {
+		if cfg.keepGoing && file.HasMediaExtension(f.FilePath) {
+			return nil, summary.Wrap(summary.ClassUnsupported, f.FilePath, fileutil.ErrUnknownFileType)
 		}
+		return nil, nil
 	}
 
+	if !cfg.filter.MatchMediaType(sf.MediaType()) {
+		return nil, nil
+	}
+
+	relPath := store.RelPath(cfg.destinationDir, f.FilePath)
+
+	// The device name of an archived file is the one recorded by copy,
+	// falling back to the one in its path.
+	if len(cfg.deviceLocations) > 0 {
+		meta, err := recordedMeta(ctx, cfg.db, relPath)
+		if err != nil {
+			return nil, err
+		}
+		deviceName, ok := meta[store.MetaDevice]
+		if !ok {
+			archivePath, inArchive := file.ParseArchivePath(relPath, cfg.pathLayout)
+			deviceName, ok = archivePath.DeviceName, inArchive
+		}
+		if loc, found := cfg.deviceLocations[deviceName]; ok && found {
+			sf = sf.WithOptions(file.WithLocation(loc))
+		}
+	}
+
+	// Files are selected by the capture time in their metadata, clock
+	// corrections recorded for them are not known until they are
+	// hashed.
+	timestamp, timestampSource := sf.TimestampWithSource()
+	if timestampSource == file.TimestampSourceNautical {
+		if logger := logwrap.Get("pt"); logger != nil {
+			logger.Warning("timezone derived from the longitude, the location is far from a known city", "path", f.FilePath, "timezone", timestamp.Location().String())
+		}
+	}
+	if cfg.filter.HasTimeRange() && !cfg.filter.MatchTime(timestamp) {
+		return nil, nil
+	}
+	if cfg.keepGoing && timestampSource == file.TimestampSourceModTime {
+		if err := sf.MetadataErr(); err != nil {
+			return nil, summary.Wrap(summary.ClassMetadata, f.FilePath, err)
+		}
+	}
+
+	pf := &probedFile{scanFile: f, relPath: relPath, timestamp: timestamp, timestampSource: timestampSource}
+	if p, ok := sf.GPS(); ok {
+		pf.gps = &p
+	}
+	return pf, nil
+}
+
+// hashFile records the hash and metadata of f. Errors specific to f are
+// returned as a *summary.Error.
+func hashFile(ctx context.Context, cfg hasherConfig, f probedFile) error {
+	release, err := cfg.limiter.Acquire(ctx, f.FilePath)
+	if err != nil {
+		return err
+	}
+	fileHash, err := fileutil.GetFileHash(f.FilePath)
+	release()
+	if err != nil {
+		return summary.Wrap(summary.ClassRead, f.FilePath, err)
+	}
+
+	// The hash is recorded even if ctx was cancelled while it was read.
+	ctx = context.Background()
+	hash, err := store.InsertHash(ctx, cfg.db, f.relPath, fileHash)
+	if err != nil {
+		return err
+	}
+
+	// Keep clock corrections made by copy or retime.
+	meta, err := store.Meta(ctx, cfg.db, hash.ID)
+	if err != nil {
+		return err
+	}
+	timestamp := f.timestamp
+	if clockOffset, err := time.ParseDuration(meta[store.MetaClockOffset]); err == nil {
+		timestamp = timestamp.Add(clockOffset)
+	}
+	m := map[string]string{
+		store.MetaTimestamp:       timestamp.Format(store.TimeFormat),
+		store.MetaTimestampSource: string(f.timestampSource),
+	}
+	if f.gps != nil {
+		for k, v := range store.LocationMeta(geo.Default(), *f.gps) {
+			m[k] = v
+		}
+	}
+	if err := store.SetMetaMap(ctx, cfg.db, hash.ID, m); err != nil {
+		return err
+	}
+	cfg.events.Emit(eventstream.Event{Type: eventstream.FileScanned, Path: f.FilePath, Size: f.FileInfo.Size(), Hash: fileHash})
 	return nil
 }
 
 func scanCmd(cli *cli) *cobra.Command {
 	var flags struct {
 		destinationDir string
+		filter         filterFlags
+		walk           walkFlags
+		keepGoing      keepGoingFlags
+		jobs           jobsFlags
+		progress       progressFlags
 	}
 	var cmd = &cobra.Command{
-		Use: "scan",
-		PreRun: func(cmd *cobra.Command, args []string) {
-			_ = viper.BindPFlag("destination-dir", cmd.Flags().Lookup("destination-dir"))
-		},
+		Use:         "scan",
+		Annotations: map[string]string{annotationGraceful: "true"},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			db, err := sql.Open("sqlite3", cli.config.DBFile)
 			if err != nil {
@@ -74,44 +266,126 @@ func scanCmd(cli *cli) *cobra.Command {
 			if flags.destinationDir != "" {
 				destinationDir = flags.destinationDir
 			}
+			if destinationDir == "" {
+				return errors.New("no destination directory, set destination_dir in the config or give --destination-dir")
+			}
 
+			pathLayout, err := cli.config.pathLayout()
+			if err != nil {
+				return err
+			}
+			deviceLocations, err := cli.config.deviceLocations()
+			if err != nil {
+				return err
+			}
+
+			fileFilter, err := flags.filter.filter()
+			if err != nil {
+				return err
+			}
+			walkOptions, err := flags.walk.options()
+			if err != nil {
+				return err
+			}
+			walkOptions.Filter = fileFilter
+			walker := walk.New(walkOptions)
+
+			roots, err := flags.keepGoing.roots(destinationDir)
+			if err != nil {
+				return err
+			}
+
+			pools, err := cli.workerPools(flags.jobs)
+			if err != nil {
+				return err
+			}
+
+			reporter, err := flags.progress.reporter("scan")
+			if err != nil {
+				return err
+			}
+
+			reporter.Start()
 			g, ctx := errgroup.WithContext(cmd.Context())
 			c := make(chan scanFile)
 
 			g.Go(func() error {
 				defer close(c)
-				return filepath.Walk(destinationDir, func(p string, info os.FileInfo, err error) error {
-					if !info.Mode().IsRegular() {
-						return nil
-					}
-					if strings.HasPrefix(path.Base(p), ".") {
+				for _, root := range roots {
+					err := walker.Walk(root, func(p string, info os.FileInfo) error {
+						reporter.Discovered(info.Size())
+						cli.events.Emit(eventstream.Event{Type: eventstream.FileDiscovered, Path: p, Size: info.Size()})
+						select {
+						case c <- scanFile{p, info}:
+						case <-ctx.Done():
+							return ctx.Err()
+						}
 						return nil
+					})
+					if err != nil {
+						return err
 					}
+				}
+				reporter.DiscoveryDone()
+				return nil
+			})
 
-					select {
-					case c <- scanFile{p}:
-					case <-ctx.Done():
-						return ctx.Err()
-					}
-					return nil
+			hasherConfig := hasherConfig{
+				db:              db,
+				destinationDir:  destinationDir,
+				pathLayout:      pathLayout,
+				deviceLocations: deviceLocations,
+				filter:          fileFilter,
+				summary:         summary.New(),
+				keepGoing:       flags.keepGoing.keepGoing,
+				limiter:         pools.limiter,
+				progress:        reporter,
+				events:          cli.events,
+			}
+
+			probed := make(chan probedFile)
+			var probers sync.WaitGroup
+			for i := 0; i < pools.size(poolProbe); i++ {
+				probers.Add(1)
+				g.Go(func() error {
+					defer probers.Done()
+					return prober(ctx, hasherConfig, c, probed)
 				})
+			}
+			g.Go(func() error {
+				probers.Wait()
+				close(probed)
+				return nil
 			})
 
-			const numHashers = 4
-			for i := 0; i < numHashers; i++ {
+			for i := 0; i < pools.size(poolHash); i++ {
 				g.Go(func() error {
-					return hasher(ctx, db, destinationDir, c)
+					return hasher(ctx, hasherConfig, probed)
 				})
 			}
 
 			// End of pipeline.
-			if err := g.Wait(); err != nil {
+			err = g.Wait()
+			reporter.Stop()
+			// A run stopped by a signal still reports the files it
+			// finished.
+			interrupted := cli.interrupted()
+			if err != nil && interrupted == nil {
 				return err
 			}
 
-			return nil
+			if err := cli.finishSummary("scan", hasherConfig.summary, flags.keepGoing, walker); interrupted == nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			return interrupted
 		},
 	}
 	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	addFilterFlags(cmd, &flags.filter)
+	addWalkFlags(cmd, &flags.walk)
+	addKeepGoingFlags(cmd, &flags.keepGoing)
+	addJobsFlags(cmd, &flags.jobs, poolProbe, poolHash)
+	addProgressFlags(cmd, &flags.progress)
 	return cmd
 }
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"pt/internal/model"
+	"pt/internal/search"
+	"pt/internal/store"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func searchCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+	}
+	var cmd = &cobra.Command{
+		Use: "search [term...]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			query, err := search.Parse(args)
+			if err != nil {
+				return err
+			}
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+
+			hashes, err := model.Hashes().All(ctx, db)
+			if err != nil {
+				return err
+			}
+			meta, err := store.AllMeta(ctx, db)
+			if err != nil {
+				return err
+			}
+
+			// Files are listed in the order they were captured.
+			matches := model.HashSlice{}
+			for _, h := range hashes {
+				if query.Match(h.Filepath, meta[h.ID]) {
+					matches = append(matches, h)
+				}
+			}
+			sort.SliceStable(matches, func(i, j int) bool {
+				ti, _ := time.Parse(store.TimeFormat, meta[matches[i].ID][store.MetaTimestamp])
+				tj, _ := time.Parse(store.TimeFormat, meta[matches[j].ID][store.MetaTimestamp])
+				if !ti.Equal(tj) {
+					return ti.Before(tj)
+				}
+				return matches[i].Filepath < matches[j].Filepath
+			})
+
+			for _, h := range matches {
+				fmt.Fprintln(cli.stdout(), filepath.Join(destinationDir, h.Filepath))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	return cmd
+}
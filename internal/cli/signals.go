@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"pt/internal/fileutil"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// annotationGraceful marks commands which stop cleanly on SIGINT or SIGTERM,
+// finishing the files in progress and printing a partial summary. Other
+// commands exit straight away.
+const annotationGraceful = "graceful"
+
+// errInterrupted is returned by commands stopped by a signal.
+var errInterrupted = errors.New("interrupted")
+
+// interruption is the signal stopping the running command.
+type interruption struct {
+	mu       sync.Mutex
+	graceful bool
+	signal   os.Signal
+}
+
+// setGraceful sets if cmd stops cleanly on a signal.
+func (c *cli) setGraceful(cmd *cobra.Command) {
+	c.interruption.mu.Lock()
+	defer c.interruption.mu.Unlock()
+	c.interruption.graceful = cmd.Annotations[annotationGraceful] == "true"
+}
+
+// handleSignals returns a context cancelled by the first SIGINT or SIGTERM
+// if the running command stops cleanly, otherwise the process exits. A
+// second signal always exits, removing the partial files of copies in
+// progress.
+func (c *cli) handleSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		c.interruption.mu.Lock()
+		c.interruption.signal = sig
+		graceful := c.interruption.graceful
+		c.interruption.mu.Unlock()
+		if !graceful {
+			c.exitNow(sig)
+		}
+		fmt.Fprintf(os.Stderr, "\n%s: finishing the files in progress, repeat to stop now\n", sig)
+		cancel()
+		c.exitNow(<-signals)
+	}()
+	return ctx
+}
+
+// exitNow exits the process stopped by sig without waiting for the running
+// command.
+func (c *cli) exitNow(sig os.Signal) {
+	for _, p := range fileutil.RemovePartials() {
+		fmt.Fprintf(os.Stderr, "removed partial copy %s\n", p)
+	}
+	_ = c.closeEvents()
+	_ = c.closeLog()
+	os.Exit(exitCode(sig))
+}
+
+// interrupted returns errInterrupted, naming the signal, if the running
+// command was stopped by a signal, otherwise nil.
+func (c *cli) interrupted() error {
+	c.interruption.mu.Lock()
+	defer c.interruption.mu.Unlock()
+	if c.interruption.signal == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errInterrupted, c.interruption.signal)
+}
+
+// interruptedBy returns the signal that stopped the running command, or nil.
+func (c *cli) interruptedBy() os.Signal {
+	c.interruption.mu.Lock()
+	defer c.interruption.mu.Unlock()
+	return c.interruption.signal
+}
+
+// stopMoves returns errInterrupted if the running command was stopped by a
+// signal, after printing the number of files it moved. Commands that move
+// files call it before each move so the move in progress is finished and
+// recorded, with a context that isn't cancelled by the signal.
+func (c *cli) stopMoves(cmd *cobra.Command, moved int) error {
+	if cmd.Context().Err() == nil {
+		return nil
+	}
+	cmd.SilenceUsage = true
+	fmt.Fprintf(c.stdout(), "stopped after moving %d files\n", moved)
+	if err := c.interrupted(); err != nil {
+		return err
+	}
+	return cmd.Context().Err()
+}
+
+// exitCode returns the exit status of a process stopped by sig, 128 plus the
+// signal number as shells report it (eg; 130 for SIGINT).
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 130
+}
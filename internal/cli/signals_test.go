@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"pt/internal/store"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopMoves(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		signal os.Signal
+		want   error
+	}{
+		{name: "running", ctx: context.Background()},
+		{name: "signal", ctx: cancelled, signal: syscall.SIGTERM, want: errInterrupted},
+		{name: "cancelled", ctx: cancelled, want: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cli{}
+			c.interruption.signal = tt.signal
+			cmd := &cobra.Command{}
+			cmd.SetContext(tt.ctx)
+
+			err := c.stopMoves(cmd, 2)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.want), err)
+			assert.True(t, cmd.SilenceUsage)
+		})
+	}
+}
+
+func TestInterruptedMoves(t *testing.T) {
+	c, db := newTestArchive(t)
+	c.config.PathLayout = "{{.Year}}/{{.Device}}"
+	photos := c.config.DestinationDir
+	// The files are moved in the order they were recorded.
+	for _, day := range []string{"02", "03"} {
+		rel := "2022/01/phone/Recents/202201" + day + "-030405000.JPG"
+		archiveFile(t, c, db, rel, map[string]string{store.MetaDevice: "phone", store.MetaAlbum: "Recents", store.MetaTimestamp: "2022-01-" + day + "T03:04:05.000Z"})
+	}
+
+	moveLogDir := t.TempDir()
+	require.NoError(t, runCmd(context.Background(), reorganizeCmd(c), "--move-log-dir", moveLogDir))
+	logs, err := filepath.Glob(filepath.Join(moveLogDir, "*"))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+
+	// A signal before the first move stops undo without moving anything.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.interruption.signal = os.Interrupt
+	err = runCmd(cancelled, undoCmd(c), logs[0])
+	assert.True(t, errors.Is(err, errInterrupted), err)
+	for _, rel := range []string{"2022/phone/20220102-030405000.JPG", "2022/phone/20220103-030405000.JPG"} {
+		assert.FileExists(t, filepath.Join(photos, rel))
+		archivedMeta(t, db, rel)
+	}
+
+	// Undo stopped after its first move leaves that file and its row
+	// restored and the other where reorganize put it.
+	stop := func(moved int) error {
+		if moved == 1 {
+			return errInterrupted
+		}
+		return nil
+	}
+	err = undoMoves(context.Background(), io.Discard, db, photos, logs[0], false, stop)
+	assert.True(t, errors.Is(err, errInterrupted), err)
+	assert.FileExists(t, filepath.Join(photos, "2022/01/phone/Recents/20220103-030405000.JPG"))
+	archivedMeta(t, db, "2022/01/phone/Recents/20220103-030405000.JPG")
+	assert.FileExists(t, filepath.Join(photos, "2022/phone/20220102-030405000.JPG"))
+	archivedMeta(t, db, "2022/phone/20220102-030405000.JPG")
+}
@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/mounts"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Post import actions of a source.
+const (
+	postImportKeep = "keep"
+	postImportMove = "move"
+)
+
+// sourceConfig is a directory files are copied from, such as the card of a
+// camera or a folder of phone backups, with the options of its files.
+type sourceConfig struct {
+	Name string `json:"name"`
+	// Path is the directory copied from, or a directory relative to the
+	// volume of Label or UUID (eg; DCIM) wherever it is mounted.
+	Path string `json:"path,omitempty"`
+
+	// Label and UUID identify the volume of the source, such as a camera
+	// card, when it is mounted.
+	Label string `json:"label,omitempty"`
+	UUID  string `json:"uuid,omitempty"`
+
+	// Device is the device name of files matched by neither device_names
+	// nor device_rules.
+	Device string `json:"device,omitempty"`
+
+	// Timezone is the timezone of the clock of the files, overriding
+	// device_timezones.
+	Timezone string `json:"timezone,omitempty"`
+
+	// ClockOffset is added to the timestamp of every file (eg; +1h).
+	ClockOffset string `json:"clock_offset,omitempty"`
+
+	Filters sourceFilters `json:"filters,omitempty"`
+
+	// PostImport is keep (the default) to leave files in the source once
+	// they are copied, or move to remove them.
+	PostImport string `json:"post_import,omitempty"`
+}
+
+// sourceFilters select the files copied from a source, as the filter flags
+// do.
+type sourceFilters struct {
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+	MinSize    string   `json:"min_size,omitempty"`
+	MaxSize    string   `json:"max_size,omitempty"`
+	MediaTypes []string `json:"media_types,omitempty"`
+	Since      string   `json:"since,omitempty"`
+	Until      string   `json:"until,omitempty"`
+}
+
+// merge returns flags with the filters of the source added. Patterns and
+// media types are added to those of the flags, the sizes and times of the
+// flags override those of the source.
+func (f sourceFilters) merge(flags filterFlags) filterFlags {
+	merged := filterFlags{
+		include:    append(append([]string{}, f.Include...), flags.include...),
+		exclude:    append(append([]string{}, f.Exclude...), flags.exclude...),
+		mediaTypes: append(append([]string{}, f.MediaTypes...), flags.mediaTypes...),
+		minSize:    f.MinSize,
+		maxSize:    f.MaxSize,
+		since:      f.Since,
+		until:      f.Until,
+	}
+	for _, s := range []struct{ flag, source *string }{
+		{&flags.minSize, &merged.minSize},
+		{&flags.maxSize, &merged.maxSize},
+		{&flags.since, &merged.since},
+		{&flags.until, &merged.until},
+	} {
+		if *s.flag != "" {
+			*s.source = *s.flag
+		}
+	}
+	return merged
+}
+
+// source is a sourceConfig parsed for copy.
+type source struct {
+	name        string
+	path        string
+	label       string
+	uuid        string
+	device      string
+	location    *time.Location
+	clockOffset time.Duration
+	filters     sourceFilters
+	move        bool
+}
+
+// parse returns the source parsed from s.
+func (s sourceConfig) parse() (source, error) {
+	src := source{name: s.Name, path: s.Path, label: s.Label, uuid: s.UUID, device: s.Device, filters: s.Filters}
+	if s.Name == "" {
+		return src, errors.New("source without a name")
+	}
+	if s.Label == "" && s.UUID == "" && !filepath.IsAbs(s.Path) {
+		return src, fmt.Errorf("source %s: path must be absolute, or relative to the volume of label or uuid", s.Name)
+	}
+
+	var err error
+	if s.Timezone != "" {
+		if src.location, err = time.LoadLocation(s.Timezone); err != nil {
+			return src, fmt.Errorf("source %s: %w", s.Name, err)
+		}
+	}
+	if s.ClockOffset != "" {
+		if src.clockOffset, err = file.ParseTimeShift(s.ClockOffset); err != nil {
+			return src, fmt.Errorf("source %s: %w", s.Name, err)
+		}
+	}
+	if _, err := s.Filters.merge(filterFlags{}).filter(); err != nil {
+		return src, fmt.Errorf("source %s: filters: %w", s.Name, err)
+	}
+	switch s.PostImport {
+	case "", postImportKeep:
+	case postImportMove:
+		src.move = true
+	default:
+		return src, fmt.Errorf("source %s: invalid post_import %q, expected keep or move", s.Name, s.PostImport)
+	}
+	return src, nil
+}
+
+// sources returns Sources parsed, checking their names are unique.
+func (cfg config) sources() ([]source, error) {
+	sources := []source{}
+	names := map[string]bool{}
+	for _, s := range cfg.Sources {
+		src, err := s.parse()
+		if err != nil {
+			return nil, err
+		}
+		if names[src.name] {
+			return nil, fmt.Errorf("source %s: name is used by more than one source", src.name)
+		}
+		names[src.name] = true
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// sourceFlags are the flags selecting the sources copied from.
+type sourceFlags struct {
+	names []string
+	all   bool
+}
+
+// addSourceFlags adds the source flags to cmd.
+func addSourceFlags(cmd *cobra.Command, flags *sourceFlags) {
+	cmd.Flags().StringArrayVar(&flags.names, "source", nil, "Copy from this source of the config, may be repeated")
+	cmd.Flags().BoolVar(&flags.all, "all-sources", false, "Copy from every source of the config whose path exists")
+}
+
+// selected returns the sources of cfg selected by the flags. Without
+// --source or --all-sources the source is sourceDir. Sources selected by
+// --all-sources whose path doesn't exist, such as cards that aren't
+// mounted, are left out.
+func (flags sourceFlags) selected(cfg config, sourceDir string) ([]source, error) {
+	if len(flags.names) == 0 && !flags.all {
+		return []source{{path: sourceDir}}, nil
+	}
+	if len(flags.names) > 0 && flags.all {
+		return nil, errors.New("--source and --all-sources can't be used together")
+	}
+
+	sources, err := cfg.sources()
+	if err != nil {
+		return nil, err
+	}
+	if flags.all {
+		found := []source{}
+		for _, src := range sources {
+			src, err := src.mounted()
+			if err == nil {
+				_, err = os.Stat(src.path)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "source %s: %v, skipped\n", src.name, err)
+				continue
+			}
+			found = append(found, src)
+		}
+		return found, nil
+	}
+
+	selected := []source{}
+	for _, name := range flags.names {
+		i := indexSource(sources, name)
+		if i < 0 {
+			return nil, fmt.Errorf("no source %s in the config", name)
+		}
+		src, err := sources[i].mounted()
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		selected = append(selected, src)
+	}
+	return selected, nil
+}
+
+// onVolume returns true if the path of src is relative to its volume.
+func (src source) onVolume() bool {
+	return (src.label != "" || src.uuid != "") && !filepath.IsAbs(src.path)
+}
+
+// matches returns true if m is the volume of src, by label or UUID, or for
+// sources without either if the path of src is within m.
+func (src source) matches(m mounts.Mount) bool {
+	if src.label != "" || src.uuid != "" {
+		return (src.label == "" || strings.EqualFold(src.label, m.Label)) &&
+			(src.uuid == "" || strings.EqualFold(src.uuid, m.UUID))
+	}
+	return isWithin(m.MountPoint, src.path)
+}
+
+// at returns src copied from the volume mounted at m.
+func (src source) at(m mounts.Mount) source {
+	if src.onVolume() {
+		src.path = filepath.Join(m.MountPoint, src.path)
+	}
+	return src
+}
+
+// mounted returns src with its path within the mount point of its volume,
+// if the path is relative to it.
+func (src source) mounted() (source, error) {
+	if !src.onVolume() {
+		return src, nil
+	}
+	mnts, err := mounts.Read(mounts.MountInfo)
+	if err != nil {
+		return src, err
+	}
+	for _, m := range mnts {
+		m.Identify(mounts.DiskDir)
+		if src.matches(m) {
+			return src.at(m), nil
+		}
+	}
+	return src, errors.New("its volume is not mounted")
+}
+
+func indexSource(sources []source, name string) int {
+	for i, src := range sources {
+		if src.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// within returns the paths within the path of src.
+func (src source) within(paths []string) []string {
+	within := []string{}
+	for _, p := range paths {
+		if isWithin(src.path, p) {
+			within = append(within, filepath.Clean(p))
+		}
+	}
+	return within
+}
+
+// isWithin returns true if p is root or a path below it.
+func isWithin(root, p string) bool {
+	root, p = filepath.Clean(root), filepath.Clean(p)
+	return p == root || strings.HasPrefix(p, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceFiltersMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters sourceFilters
+		flags   filterFlags
+		want    filterFlags
+	}{
+		{
+			name:    "source only",
+			filters: sourceFilters{Include: []string{"*.jpg"}, MinSize: "1K", Since: "2022-01-01"},
+			want:    filterFlags{include: []string{"*.jpg"}, exclude: []string{}, mediaTypes: []string{}, minSize: "1K", since: "2022-01-01"},
+		},
+		{
+			name:    "patterns and media types are added",
+			filters: sourceFilters{Include: []string{"*.jpg"}, Exclude: []string{".trash"}, MediaTypes: []string{"photo"}},
+			flags:   filterFlags{include: []string{"*.cr3"}, exclude: []string{"tmp"}, mediaTypes: []string{"raw"}},
+			want:    filterFlags{include: []string{"*.jpg", "*.cr3"}, exclude: []string{".trash", "tmp"}, mediaTypes: []string{"photo", "raw"}},
+		},
+		{
+			name:    "flags override sizes and times",
+			filters: sourceFilters{MinSize: "1K", MaxSize: "1G", Since: "2022-01-01", Until: "2023-01-01"},
+			flags:   filterFlags{minSize: "2K", until: "1d"},
+			want:    filterFlags{include: []string{}, exclude: []string{}, mediaTypes: []string{}, minSize: "2K", maxSize: "1G", since: "2022-01-01", until: "1d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filters.merge(tt.flags))
+		})
+	}
+}
+
+func TestSourceConfigParse(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		config sourceConfig
+		want   source
+		hasErr bool
+	}{
+		{
+			name:   "path",
+			config: sourceConfig{Name: "card", Path: "/media/card", Device: "eos"},
+			want:   source{name: "card", path: "/media/card", device: "eos"},
+		},
+		{
+			name: "volume with options",
+			config: sourceConfig{
+				Name: "card", Path: "DCIM", Label: "EOS_DIGITAL", Timezone: "Europe/Paris", ClockOffset: "+1h",
+				Filters: sourceFilters{MediaTypes: []string{"photo"}}, PostImport: postImportMove,
+			},
+			want: source{
+				name: "card", path: "DCIM", label: "EOS_DIGITAL", location: paris, clockOffset: time.Hour,
+				filters: sourceFilters{MediaTypes: []string{"photo"}}, move: true,
+			},
+		},
+		{name: "keep", config: sourceConfig{Name: "card", Path: "/media/card", PostImport: postImportKeep}, want: source{name: "card", path: "/media/card"}},
+		{name: "no name", config: sourceConfig{Path: "/media/card"}, hasErr: true},
+		{name: "relative path", config: sourceConfig{Name: "card", Path: "DCIM"}, hasErr: true},
+		{name: "invalid timezone", config: sourceConfig{Name: "card", Path: "/media/card", Timezone: "Mars/Olympus"}, hasErr: true},
+		{name: "invalid clock offset", config: sourceConfig{Name: "card", Path: "/media/card", ClockOffset: "soon"}, hasErr: true},
+		{name: "invalid filters", config: sourceConfig{Name: "card", Path: "/media/card", Filters: sourceFilters{MinSize: "big"}}, hasErr: true},
+		{name: "invalid post import", config: sourceConfig{Name: "card", Path: "/media/card", PostImport: "delete"}, hasErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.parse()
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSourceFlagsSelected(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "card"), 0755))
+	cfg := config{Sources: []sourceConfig{
+		{Name: "card", Path: filepath.Join(dir, "card")},
+		{Name: "usb", Path: filepath.Join(dir, "usb")},
+	}}
+
+	tests := []struct {
+		name   string
+		flags  sourceFlags
+		cfg    config
+		want   []string
+		hasErr bool
+	}{
+		{name: "source dir", cfg: cfg, want: []string{"/media/import"}},
+		{name: "named", flags: sourceFlags{names: []string{"usb", "card"}}, cfg: cfg, want: []string{"usb", "card"}},
+		{name: "all that exist", flags: sourceFlags{all: true}, cfg: cfg, want: []string{"card"}},
+		{name: "unknown name", flags: sourceFlags{names: []string{"phone"}}, cfg: cfg, hasErr: true},
+		{name: "names and all", flags: sourceFlags{names: []string{"card"}, all: true}, cfg: cfg, hasErr: true},
+		{
+			name:   "duplicate names",
+			flags:  sourceFlags{all: true},
+			cfg:    config{Sources: []sourceConfig{cfg.Sources[0], cfg.Sources[0]}},
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.flags.selected(tt.cfg, "/media/import")
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			names := []string{}
+			for _, src := range got {
+				if src.name == "" {
+					names = append(names, src.path)
+				} else {
+					names = append(names, src.name)
+				}
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
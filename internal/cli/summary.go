@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/summary"
+	"pt/internal/walk"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// keepGoingFlags are the flags of commands which can carry on past files
+// that fail.
+type keepGoingFlags struct {
+	keepGoing   bool
+	failedPaths string
+	filesFrom   string
+}
+
+// addKeepGoingFlags adds the keep going flags to cmd.
+func addKeepGoingFlags(cmd *cobra.Command, flags *keepGoingFlags) {
+	cmd.Flags().BoolVar(&flags.keepGoing, "keep-going", false, "Carry on past files that fail, reporting them at the end")
+	cmd.Flags().StringVar(&flags.failedPaths, "failed-paths", "", "File to write the paths of failed files to (default: failed next to the database)")
+	cmd.Flags().StringVar(&flags.filesFrom, "files-from", "", "Only walk the paths listed in this file, such as the failed paths of an earlier run")
+}
+
+// roots returns the paths listed in the --files-from file, one per line, or
+// root if it isn't set.
+func (flags keepGoingFlags) roots(root string) ([]string, error) {
+	if flags.filesFrom == "" {
+		return []string{root}, nil
+	}
+
+	fh, err := os.Open(flags.filesFrom)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	paths := []string{}
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		if p := strings.TrimSpace(scanner.Text()); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// finishSummary prints the entries the walkers skipped and sum, or adds them
+// to the report with --output json, and writes the paths of the files that
+// failed for the retry of command. Entries the walkers couldn't read are
+// failures too. An error is returned if any file failed.
+func (c *cli) finishSummary(command string, sum *summary.Summary, flags keepGoingFlags, walkers ...*walk.Walker) error {
+	for _, w := range walkers {
+		for _, s := range w.Skipped() {
+			if s.Reason == walk.ReasonError {
+				sum.Fail(summary.Wrap(summary.ClassRead, s.Path, s.Err))
+			}
+		}
+	}
+	c.printWalkSkipped(walkers, false)
+
+	if c.jsonOutput() {
+		sum.Fill(&c.report)
+	} else {
+		sum.Print(os.Stdout)
+	}
+	if len(sum.Failures()) == 0 {
+		return nil
+	}
+
+	p := flags.failedPaths
+	if p == "" {
+		p = filepath.Join(filepath.Dir(c.config.DBFile), "failed", command+"-"+time.Now().Format("20060102T150405")+".txt")
+	}
+	if err := sum.WriteFailedPaths(p); err != nil {
+		return err
+	}
+	if c.jsonOutput() {
+		c.report.FailedPaths = p
+	} else {
+		fmt.Printf("failed paths: %s (retry with --files-from)\n", p)
+	}
+	return sum.Err()
+}
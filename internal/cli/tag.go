@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/geo"
+	"pt/internal/store"
+	"pt/internal/tag"
+	"pt/internal/xmp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func tagCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+		date           string
+		gps            string
+		description    string
+		keywords       []string
+		sidecar        bool
+		backupDir      string
+		noBackup       bool
+	}
+	var cmd = &cobra.Command{
+		Use: "tag file...",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if len(args) == 0 {
+				return errors.New("no files to tag")
+			}
+
+			props := xmp.Properties{
+				Description: flags.description,
+				Keywords:    flags.keywords,
+			}
+			if flags.date != "" {
+				t, err := parseTagDate(flags.date)
+				if err != nil {
+					return err
+				}
+				props.DateTimeOriginal = t
+			}
+			if flags.gps != "" {
+				p, err := geo.ParsePoint(flags.gps)
+				if err != nil {
+					return err
+				}
+				props.GPS = &p
+			}
+			if props.IsZero() {
+				return errors.New("nothing to tag: use --date, --gps, --description or --keyword")
+			}
+
+			opts := tag.Options{Sidecar: flags.sidecar}
+			if !flags.noBackup {
+				opts.BackupDir = cli.backupDir(flags.backupDir)
+			}
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+			destinationDir, err = filepath.Abs(destinationDir)
+			if err != nil {
+				return err
+			}
+
+			for _, p := range args {
+				p, err := filepath.Abs(p)
+				if err != nil {
+					return err
+				}
+
+				written, err := tag.Write(p, props, opts)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cli.stdout(), "tag %s: %s\n", p, written)
+
+				if err := recordTag(ctx, db, destinationDir, p, props); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().StringVar(&flags.date, "date", "", "Capture date (eg; 1987-06-01, 1987-06-01 12:30:00 or 1987-06-01T12:30:00+10:00)")
+	cmd.Flags().StringVar(&flags.gps, "gps", "", "GPS location as latitude,longitude (eg; -37.8136,144.9631)")
+	cmd.Flags().StringVar(&flags.description, "description", "", "Description")
+	cmd.Flags().StringArrayVar(&flags.keywords, "keyword", nil, "Keyword to add, may be repeated")
+	cmd.Flags().BoolVar(&flags.sidecar, "sidecar", false, "Write an XMP sidecar for JPEGs instead of modifying them")
+	cmd.Flags().StringVar(&flags.backupDir, "backup-dir", "", "Directory to back up modified files to (default: backup next to the database)")
+	cmd.Flags().BoolVar(&flags.noBackup, "no-backup", false, "Don't back up modified files")
+	return cmd
+}
+
+// backupDir returns the directory files modified by this run are backed up
+// to, below dir or the backup directory next to the database.
+func (c *cli) backupDir(dir string) string {
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(c.config.DBFile), "backup")
+	}
+	return filepath.Join(dir, time.Now().Format("20060102T150405"))
+}
+
+// recordTag updates the hash and meta rows of p after props have been written
+// to it. Nothing is recorded for files outside of destinationDir.
+func recordTag(ctx context.Context, db *sql.DB, destinationDir, p string, props xmp.Properties) error {
+	if !strings.HasPrefix(p, destinationDir+string(os.PathSeparator)) {
+		return nil
+	}
+
+	relPath := store.RelPath(destinationDir, p)
+	fileHash, err := fileutil.GetFileHash(p)
+	if err != nil {
+		return err
+	}
+	hash, err := store.FindHashByFilepath(ctx, db, relPath)
+	if errors.Is(err, sql.ErrNoRows) {
+		hash, err = store.InsertHash(ctx, db, relPath, fileHash)
+	}
+	if err != nil {
+		return err
+	}
+	if hash.Hash != fileHash {
+		if err := store.UpdateHash(ctx, db, relPath, fileHash); err != nil {
+			return err
+		}
+	}
+
+	meta, err := store.Meta(ctx, db, hash.ID)
+	if err != nil {
+		return err
+	}
+	return store.SetMetaMap(ctx, db, hash.ID, tagMeta(props, meta))
+}
+
+// parseTagDate parses a date given to pt tag. Dates without an offset are in
+// the local timezone.
+func parseTagDate(s string) (time.Time, error) {
+	for _, layout := range []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %q", s)
+}
+
+// tagMeta returns the meta values to record for props given the current meta
+// values of the file.
+func tagMeta(props xmp.Properties, meta map[string]string) map[string]string {
+	m := map[string]string{}
+	if !props.DateTimeOriginal.IsZero() {
+		m[store.MetaTimestamp] = props.DateTimeOriginal.Format(store.TimeFormat)
+		m[store.MetaTimestampSource] = string(file.TimestampSourceOffset)
+		// The date is given as it should be, earlier clock corrections no
+		// longer apply.
+		m[store.MetaClockOffset] = time.Duration(0).String()
+	}
+	if props.GPS != nil {
+		for k, v := range store.LocationMeta(geo.Default(), *props.GPS) {
+			m[k] = v
+		}
+	}
+	if props.Description != "" {
+		m[store.MetaDescription] = props.Description
+	}
+	if len(props.Keywords) > 0 {
+		existing := xmp.Properties{}
+		if meta[store.MetaKeywords] != "" {
+			existing.Keywords = strings.Split(meta[store.MetaKeywords], ",")
+		}
+		m[store.MetaKeywords] = strings.Join(existing.Merge(props).Keywords, ",")
+	}
+	return m
+}
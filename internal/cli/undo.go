@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pt/internal/fileutil"
+	"pt/internal/movelog"
+	"pt/internal/store"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// undoMoves undoes the moves of the move log p, updating the hash and meta
+// rows of files within destinationDir, and prints each move to w. stop is
+// called with the number of files moved before each move, the undo stops with
+// its error.
+func undoMoves(ctx context.Context, w io.Writer, db *sql.DB, destinationDir, p string, dryRun bool, stop func(moved int) error) error {
+	moves, err := movelog.Read(p)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range movelog.Reverse(moves) {
+		if err := stop(i); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "move %s: %s\n", m.Src, m.Dst)
+		if dryRun {
+			continue
+		}
+
+		// Sidecars have their own entries in the log.
+		if err := fileutil.Move(m.Src, m.Dst); err != nil {
+			return err
+		}
+		removeEmptyDirs(filepath.Dir(m.Src), destinationDir)
+
+		if !strings.HasPrefix(m.Src, destinationDir+string(os.PathSeparator)) {
+			continue
+		}
+		relSrc, relDst := store.RelPath(destinationDir, m.Src), store.RelPath(destinationDir, m.Dst)
+		if err := store.UpdateFilepath(ctx, db, relSrc, relDst); err != nil {
+			return err
+		}
+		if len(m.Meta) == 0 {
+			continue
+		}
+		hash, err := store.FindHashByFilepath(ctx, db, relDst)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for k, v := range m.Meta {
+			if v == nil {
+				err = store.DeleteMeta(ctx, db, hash.ID, k)
+			} else {
+				err = store.SetMeta(ctx, db, hash.ID, k, *v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func undoCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		destinationDir string
+		dryRun         bool
+	}
+	var cmd = &cobra.Command{
+		Use:         "undo move-log",
+		Annotations: map[string]string{annotationGraceful: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("expected a move log")
+			}
+
+			db, err := sql.Open("sqlite3", cli.config.DBFile)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			destinationDir := cli.config.DestinationDir
+			if flags.destinationDir != "" {
+				destinationDir = flags.destinationDir
+			}
+			destinationDir, err = filepath.Abs(destinationDir)
+			if err != nil {
+				return err
+			}
+
+			// A move that was started is recorded in the database even
+			// if a signal stops the command.
+			stop := func(moved int) error { return cli.stopMoves(cmd, moved) }
+			return undoMoves(context.WithoutCancel(cmd.Context()), cli.stdout(), db, destinationDir, args[0], flags.dryRun, stop)
+		},
+	}
+	cmd.Flags().StringVar(&flags.destinationDir, "destination-dir", "", "Destination directory")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the moves without making them")
+	return cmd
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"pt/internal/walk"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// walkedFile is a file found walking the paths given to a command.
+type walkedFile struct {
+	filePath string
+	info     os.FileInfo
+}
+
+// walkFiles returns the files w finds walking paths.
+func walkFiles(w *walk.Walker, paths []string) ([]walkedFile, error) {
+	files := []walkedFile{}
+	for _, p := range paths {
+		err := w.Walk(p, func(p string, info os.FileInfo) error {
+			files = append(files, walkedFile{p, info})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// walkFlags are the flags setting the policies of the walker of a command.
+type walkFlags struct {
+	onError        string
+	followSymlinks bool
+	oneFileSystem  bool
+}
+
+// addWalkFlags adds the walk flags to cmd.
+func addWalkFlags(cmd *cobra.Command, flags *walkFlags) {
+	cmd.Flags().StringVar(&flags.onError, "on-error", string(walk.ErrorPolicySkip), "What to do with files and directories that can't be read (skip or abort)")
+	cmd.Flags().BoolVar(&flags.followSymlinks, "follow-symlinks", false, "Walk the targets of symlinks")
+	cmd.Flags().BoolVar(&flags.oneFileSystem, "one-file-system", false, "Skip directories on other filesystems")
+}
+
+// options returns the flags parsed as walk.Options.
+func (flags walkFlags) options() (walk.Options, error) {
+	onError, err := walk.ParseErrorPolicy(flags.onError)
+	if err != nil {
+		return walk.Options{}, err
+	}
+	return walk.Options{
+		OnError:        onError,
+		FollowSymlinks: flags.followSymlinks,
+		OneFileSystem:  flags.oneFileSystem,
+	}, nil
+}
+
+// printSkipped prints the entries the walkers skipped because they couldn't
+// be read or would loop, followed by the number of entries skipped for each
+// reason. With --output json the counts are added to the report instead.
+func (c *cli) printSkipped(walkers ...*walk.Walker) {
+	c.printWalkSkipped(walkers, true)
+}
+
+// printWalkSkipped is printSkipped, leaving out the entries that couldn't be
+// read unless errors is true.
+func (c *cli) printWalkSkipped(walkers []*walk.Walker, errors bool) {
+	skipped := []walk.Skipped{}
+	counts := map[walk.Reason]int{}
+	for _, w := range walkers {
+		skipped = append(skipped, w.Skipped()...)
+		for reason, n := range w.Counts() {
+			counts[reason] += n
+		}
+	}
+
+	if c.jsonOutput() {
+		if len(counts) > 0 {
+			c.report.Skipped = map[string]int{}
+			for reason, n := range counts {
+				c.report.Skipped[string(reason)] += n
+			}
+		}
+		return
+	}
+
+	for _, s := range skipped {
+		switch s.Reason {
+		case walk.ReasonError:
+			if !errors {
+				continue
+			}
+			fmt.Printf("skipped %s: %v\n", s.Path, s.Err)
+		case walk.ReasonSymlinkLoop:
+			fmt.Printf("skipped %s: %s\n", s.Path, s.Reason)
+		}
+	}
+
+	if len(counts) == 0 {
+		return
+	}
+	reasons := []string{}
+	for reason, n := range counts {
+		reasons = append(reasons, fmt.Sprintf("%d %s", n, reason))
+	}
+	sort.Strings(reasons)
+	fmt.Printf("skipped %d: %s\n", len(skipped), strings.Join(reasons, ", "))
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"pt/internal/fileutil"
+	"pt/internal/logwrap"
+	"pt/internal/mounts"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func watchMountsCmd(cli *cli) *cobra.Command {
+	var flags struct {
+		mountInfo string
+		diskDir   string
+		interval  time.Duration
+		copy      copyFlags
+	}
+	var cmd = &cobra.Command{
+		Use:         "watch-mounts",
+		Short:       "Copy from the sources of the config as their volumes are mounted",
+		Long:        "Watch for volumes with a DCIM directory being mounted, such as camera cards, and copy from the source of the config they are the volume of, matched by label or uuid, or by path for sources without either.",
+		Annotations: map[string]string{annotationGraceful: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cli.jsonOutput() {
+				return errors.New("watch-mounts prints each import as text, use --events for JSON")
+			}
+			if flags.copy.keepGoing.filesFrom != "" {
+				return errors.New("--files-from can't be used with watch-mounts")
+			}
+			sources, err := cli.config.sources()
+			if err != nil {
+				return err
+			}
+			if len(sources) == 0 {
+				return fmt.Errorf("no sources in %s to import", cli.configFile)
+			}
+
+			logger := logwrap.Get("pt")
+			fmt.Printf("watching %s for volumes with a DCIM directory\n", flags.mountInfo)
+			watcher := mounts.Watcher{Path: flags.mountInfo, Interval: flags.interval}
+			err = watcher.Watch(cmd.Context(), func(m mounts.Mount) error {
+				m.Identify(flags.diskDir)
+				if !m.HasDCIM() {
+					logger.Debug("mounted volume without DCIM", "mount_point", m.MountPoint, "device", m.Source)
+					return nil
+				}
+				i := indexMatch(sources, m)
+				if i < 0 {
+					fmt.Printf("%s: no source matches the volume, skipped\n", m)
+					logger.Info("mounted volume matches no source", "mount_point", m.MountPoint, "device", m.Source, "label", m.Label, "uuid", m.UUID)
+					return nil
+				}
+				src := sources[i].at(m)
+
+				fmt.Printf("%s: importing source %s\n", m, src.name)
+				logger.Info("importing mounted volume", "source", src.name, "mount_point", m.MountPoint, "device", m.Source, "label", m.Label, "uuid", m.UUID)
+				// Files that failed on an earlier mount, such as a card
+				// that was pulled out, are read again.
+				fileutil.ResetBadSources()
+				sum, walkers, err := cli.copySources(cmd.Context(), flags.copy, []source{src}, nil)
+				if interrupted := cli.interrupted(); interrupted != nil {
+					_ = cli.finishSummary("copy", sum, flags.copy.keepGoing, walkers...)
+					return interrupted
+				}
+				// A failed import is reported, the volume can be mounted
+				// again to retry it.
+				if err == nil {
+					err = cli.finishSummary("copy", sum, flags.copy.keepGoing, walkers...)
+				}
+				if err != nil {
+					fmt.Printf("%s: importing source %s failed: %v\n", m, src.name, err)
+					logger.Error("importing mounted volume failed", "source", src.name, "mount_point", m.MountPoint, "error", err)
+					return nil
+				}
+				fmt.Printf("%s: imported source %s\n", m, src.name)
+				logger.Info("imported mounted volume", "source", src.name, "mount_point", m.MountPoint)
+				return nil
+			})
+			if errors.Is(err, errInterrupted) {
+				cmd.SilenceUsage = true
+				return err
+			}
+			// Stopping while waiting for volumes is how watching ends.
+			if cli.interrupted() != nil {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&flags.mountInfo, "mountinfo", mounts.MountInfo, "File listing the mounted filesystems, in the format of /proc/self/mountinfo")
+	cmd.Flags().StringVar(&flags.diskDir, "disk-dir", mounts.DiskDir, "Directory of the by-label and by-uuid links to volumes")
+	cmd.Flags().DurationVar(&flags.interval, "interval", 2*time.Second, "How often mounts are checked")
+	addCopyFlags(cmd, &flags.copy)
+	return cmd
+}
+
+// indexMatch returns the index of the first source of sources m is the
+// volume of, or -1 if there is none.
+func indexMatch(sources []source, m mounts.Mount) int {
+	for i, src := range sources {
+		if src.matches(m) {
+			return i
+		}
+	}
+	return -1
+}
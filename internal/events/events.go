@@ -0,0 +1,80 @@
+// Package events groups archived files into events, being runs of files
+// captured close together in time and place.
+package events
+
+import (
+	"pt/internal/geo"
+	"sort"
+	"time"
+)
+
+// Item is an archived file to be grouped into an event.
+type Item struct {
+	// ID identifies the file, eg; its hash row id.
+	ID int64
+
+	Time time.Time
+
+	// Point is the location of the file, nil if it is unknown.
+	Point *geo.Point
+}
+
+// Event is a group of items ordered by time.
+type Event struct {
+	Items []Item
+}
+
+// ID returns an identifier of the event derived from the time of its first
+// item.
+func (e Event) ID() string {
+	return e.Start().Format("20060102-150405")
+}
+
+// Start returns the time of the first item of the event.
+func (e Event) Start() time.Time {
+	return e.Items[0].Time
+}
+
+// End returns the time of the last item of the event.
+func (e Event) End() time.Time {
+	return e.Items[len(e.Items)-1].Time
+}
+
+// Options control how items are grouped into events.
+type Options struct {
+	// MaxGap is the longest time between two items of the same event.
+	MaxGap time.Duration
+
+	// MaxDistance is the furthest, in km, an item can be from the previous
+	// located item of the same event.
+	MaxDistance float64
+}
+
+// Cluster groups items into events. A new event is started when an item is
+// more than opts.MaxGap after the previous item, or more than
+// opts.MaxDistance away from the last item with a location.
+func Cluster(items []Item, opts Options) []Event {
+	items = append([]Item{}, items...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Time.Before(items[j].Time) })
+
+	events := []Event{}
+	var current *Event
+	var last *geo.Point
+	for _, i := range items {
+		split := current == nil || i.Time.Sub(current.End()) > opts.MaxGap
+		if !split && i.Point != nil && last != nil && geo.Distance(*last, *i.Point) > opts.MaxDistance {
+			split = true
+		}
+
+		if split {
+			events = append(events, Event{})
+			current = &events[len(events)-1]
+			last = nil
+		}
+		current.Items = append(current.Items, i)
+		if i.Point != nil {
+			last = i.Point
+		}
+	}
+	return events
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"pt/internal/geo"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCluster(t *testing.T) {
+	base := time.Date(2022, 1, 2, 9, 0, 0, 0, time.UTC)
+	melbourne := &geo.Point{Latitude: -37.81, Longitude: 144.96}
+	geelong := &geo.Point{Latitude: -38.15, Longitude: 144.36}
+	stKilda := &geo.Point{Latitude: -37.87, Longitude: 144.98}
+
+	items := []Item{
+		{ID: 3, Time: base.Add(2 * time.Hour), Point: stKilda},
+		{ID: 1, Time: base, Point: melbourne},
+		{ID: 2, Time: base.Add(time.Hour)},
+		// Close in time but too far away.
+		{ID: 4, Time: base.Add(3 * time.Hour), Point: geelong},
+		{ID: 5, Time: base.Add(3*time.Hour + 30*time.Minute)},
+		// Too long after the previous item.
+		{ID: 6, Time: base.Add(24 * time.Hour), Point: geelong},
+	}
+
+	events := Cluster(items, Options{MaxGap: 2 * time.Hour, MaxDistance: 50})
+
+	ids := [][]int64{}
+	for _, e := range events {
+		i := []int64{}
+		for _, item := range e.Items {
+			i = append(i, item.ID)
+		}
+		ids = append(ids, i)
+	}
+	assert.Equal(t, [][]int64{{1, 2, 3}, {4, 5}, {6}}, ids)
+	assert.Equal(t, "20220102-090000", events[0].ID())
+	assert.Equal(t, base.Add(2*time.Hour), events[0].End())
+
+	assert.Empty(t, Cluster(nil, Options{}))
+}
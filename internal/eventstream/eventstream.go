@@ -0,0 +1,98 @@
+// Package eventstream writes what happens to each file processed by a
+// command as a stream of newline delimited JSON objects, for scripts and
+// dashboards to consume.
+package eventstream
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"pt/internal/summary"
+	"sync"
+	"time"
+)
+
+// Types of events.
+const (
+	FileDiscovered   = "file_discovered"
+	FileCopied       = "file_copied"
+	FileScanned      = "file_scanned"
+	DuplicateSkipped = "duplicate_skipped"
+	Error            = "error"
+)
+
+// Event is a single line of the stream.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Path is the file the event is about.
+	Path string `json:"path,omitempty"`
+
+	// Destination is where the file was copied to or, for a skipped
+	// duplicate, the file it duplicates.
+	Destination string `json:"destination,omitempty"`
+
+	Size int64  `json:"size,omitempty"`
+	Hash string `json:"hash,omitempty"`
+
+	// Reason is why a duplicate was skipped, duplicate for a file of the
+	// same name and size in the destination month or exists for a file at
+	// the destination path.
+	Reason string `json:"reason,omitempty"`
+
+	// Class and Error describe the failure of an error event.
+	Class string `json:"class,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ErrorEvent returns the error event of the file at p failing with err.
+func ErrorEvent(p string, err error) Event {
+	e := Event{Type: Error, Path: p, Error: err.Error()}
+	var se *summary.Error
+	if errors.As(err, &se) {
+		e.Path, e.Class, e.Error = se.Path, string(se.Class), se.Err.Error()
+	}
+	return e
+}
+
+// Stream writes events. The methods of a nil Stream do nothing, and a Stream
+// is safe for concurrent use.
+type Stream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+	err error
+}
+
+// New returns a Stream writing to w.
+func New(w io.Writer) *Stream {
+	return &Stream{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Emit writes e, setting its time if it isn't set. Emit does nothing after a
+// write fails, see Err.
+func (s *Stream) Emit(e Event) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = s.now()
+	}
+	s.err = s.enc.Encode(e)
+}
+
+// Err returns the error of the first write that failed.
+func (s *Stream) Err() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
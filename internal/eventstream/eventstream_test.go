@@ -0,0 +1,32 @@
+package eventstream
+
+import (
+	"bytes"
+	"errors"
+	"pt/internal/summary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := New(buf)
+	s.now = func() time.Time { return time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	s.Emit(Event{Type: FileDiscovered, Path: "/card/IMG_0001.JPG", Size: 1024})
+	s.Emit(Event{Type: FileCopied, Path: "/card/IMG_0001.JPG", Destination: "/photos/2022/01/20220102-030405000.JPG", Size: 1024, Hash: "abc"})
+	s.Emit(ErrorEvent("/card/IMG_0002.JPG", summary.Wrap(summary.ClassRead, "/card/IMG_0002.JPG", errors.New("input/output error"))))
+	assert.NoError(t, s.Err())
+
+	assert.Equal(t, `{"type":"file_discovered","time":"2022-01-02T03:04:05Z","path":"/card/IMG_0001.JPG","size":1024}
+{"type":"file_copied","time":"2022-01-02T03:04:05Z","path":"/card/IMG_0001.JPG","destination":"/photos/2022/01/20220102-030405000.JPG","size":1024,"hash":"abc"}
+{"type":"error","time":"2022-01-02T03:04:05Z","path":"/card/IMG_0002.JPG","class":"read error","error":"input/output error"}
+`, buf.String())
+
+	// The methods of a nil Stream do nothing.
+	var none *Stream
+	none.Emit(Event{Type: Error})
+	assert.NoError(t, none.Err())
+}
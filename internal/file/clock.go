@@ -0,0 +1,97 @@
+package file
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ClockOffset is a correction for a device whose clock was wrong between From
+// and Until.
+type ClockOffset struct {
+	DeviceName string
+
+	// From and Until are compared against the uncorrected wall clock of a
+	// files timestamp. A zero From or Until leaves that end of the range
+	// open.
+	From  time.Time
+	Until time.Time
+
+	Offset time.Duration
+}
+
+// ClockOffsets is a list of clock corrections.
+type ClockOffsets []ClockOffset
+
+// Contains checks if the wall clock of t is within From and Until.
+func (c ClockOffset) Contains(t time.Time) bool {
+	w := wallClock(t, time.UTC)
+	if !c.From.IsZero() && w.Before(c.From) {
+		return false
+	}
+	if !c.Until.IsZero() && !w.Before(c.Until) {
+		return false
+	}
+	return true
+}
+
+// Offset returns the sum of the offsets that apply to a file from deviceName
+// with the uncorrected timestamp t.
+func (c ClockOffsets) Offset(deviceName string, t time.Time) time.Duration {
+	var d time.Duration
+	for _, i := range c {
+		if i.DeviceName == deviceName && i.Contains(t) {
+			d += i.Offset
+		}
+	}
+	return d
+}
+
+var timeShiftRe = regexp.MustCompile(`^([+-]?)(?:(\d+)d)?(.*)$`)
+
+// ParseTimeShift parses a signed duration such as "+1h" or "-30m". Along with
+// the units understood by time.ParseDuration, whole days can be given with a
+// "d" suffix (eg; "+8036d2h").
+func ParseTimeShift(s string) (time.Duration, error) {
+	m := timeShiftRe.FindStringSubmatch(s)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return 0, fmt.Errorf("invalid time shift: %q", s)
+	}
+
+	var d time.Duration
+	if m[2] != "" {
+		days, err := strconv.Atoi(m[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time shift: %q", s)
+		}
+		d = time.Duration(days) * 24 * time.Hour
+	}
+
+	if m[3] != "" {
+		rest, err := time.ParseDuration(m[3])
+		if err != nil || rest < 0 {
+			return 0, fmt.Errorf("invalid time shift: %q", s)
+		}
+		d += rest
+	}
+
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// ParseClockTime parses the From and Until times of a ClockOffset which may
+// be a date (2006-01-02), a date and time (2006-01-02T15:04:05) or empty.
+func ParseClockTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %q", s)
+}
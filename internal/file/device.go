@@ -0,0 +1,115 @@
+package file
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DeviceRule names the device of files whose camera details or file name
+// match its patterns. Patterns are shell patterns (see filepath.Match)
+// matched ignoring case, and empty patterns match anything.
+type DeviceRule struct {
+	DeviceName string
+
+	// Priority orders the rules, higher priorities are tried first.
+	Priority int
+
+	// Make, Model and SerialNumber are matched against the Camera of the
+	// file.
+	Make         string
+	Model        string
+	SerialNumber string
+
+	// FileName is matched against the base name of the file (eg; PXL_*).
+	FileName string
+}
+
+// Match returns true if every pattern of r matches the file at
+// originalFilePath with camera details c.
+func (r DeviceRule) Match(originalFilePath string, c Camera) bool {
+	if r.Make == "" && r.Model == "" && r.SerialNumber == "" && r.FileName == "" {
+		return false
+	}
+	for _, i := range []struct{ pattern, value string }{
+		{r.Make, c.Make},
+		{r.Model, c.Model},
+		{r.SerialNumber, c.SerialNumber},
+		{r.FileName, filepath.Base(originalFilePath)},
+	} {
+		if i.pattern == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(strings.ToLower(i.pattern), strings.ToLower(i.value)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// DeviceRules resolves the device name of files.
+type DeviceRules struct {
+	// DeviceNames maps device names to paths as used by DeviceName.
+	DeviceNames map[string][]string
+
+	// Rules are tried, by priority, for files whose path doesn't match
+	// DeviceNames.
+	Rules []DeviceRule
+
+	// Fallback is the device name of files matched by neither.
+	Fallback string
+}
+
+// DeviceName returns the device name of f.
+func (d DeviceRules) DeviceName(f File) string {
+	if name := DeviceName(d.DeviceNames, f.OriginalFilePath); name != "" {
+		return name
+	}
+	if len(d.Rules) == 0 {
+		return d.Fallback
+	}
+	return d.Match(f.OriginalFilePath, f.Camera())
+}
+
+// Match returns the device name of a file at originalFilePath with camera
+// details c, without reading the file.
+func (d DeviceRules) Match(originalFilePath string, c Camera) string {
+	if name := DeviceName(d.DeviceNames, originalFilePath); name != "" {
+		return name
+	}
+
+	rules := append([]DeviceRule{}, d.Rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority > rules[j].Priority })
+	for _, r := range rules {
+		if r.Match(originalFilePath, c) {
+			return r.DeviceName
+		}
+	}
+	return d.Fallback
+}
+
+// SuggestDeviceName returns a device name for a camera made from its model
+// (eg; pixel-6 for a Pixel 6), or its make if the model is unknown. An empty
+// string is returned if both are unknown.
+func SuggestDeviceName(c Camera) string {
+	name := c.Model
+	if name == "" {
+		name = c.Make
+	}
+
+	b := strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
@@ -4,21 +4,23 @@ import (
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"pt/internal/fileutil"
+	"pt/internal/geo"
 	"pt/internal/logwrap"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/dsoprea/go-exif/v3"
 	exifcommon "github.com/dsoprea/go-exif/v3/common"
-
-	"github.com/h2non/filetype"
-	"github.com/h2non/filetype/types"
+	log "github.com/dsoprea/go-logging"
 )
 
 // File ...
@@ -28,12 +30,13 @@ type File struct {
 
 	FileInfo fs.FileInfo
 
-	// ExifData is a map of relevant exif data.
-	exifData map[string]string
+	// probed caches what is read from the file.
+	probed *probe
 
-	timestamp      time.Time
-	hash           string
 	filenameSuffix string
+	location       *time.Location
+	album          string
+	pathLayout     *template.Template
 	logger         *logwrap.LogWrap
 }
 
@@ -48,24 +51,94 @@ func WithFilenameSuffix(suffix string) Option {
 	}
 }
 
+// WithLocation is an Option that sets the timezone used for the files
+// timestamp when its metadata doesn't record one.
+func WithLocation(loc *time.Location) Option {
+	return func(f File) File {
+		f.location = loc
+		return f
+	}
+}
+
+// WithAlbum is an Option that sets the album of the file instead of deriving
+// it from the directory the file is in.
+func WithAlbum(album string) Option {
+	return func(f File) File {
+		f.album = album
+		return f
+	}
+}
+
+// WithPathLayout is an Option that sets the layout of the directories below
+// the destination directory used by DestinationFilePath. A nil layout is the
+// default layout.
+func WithPathLayout(layout *template.Template) Option {
+	return func(f File) File {
+		f.pathLayout = layout
+		return f
+	}
+}
+
+// WithOptions returns a copy of f with opts applied.
+func (f File) WithOptions(opts ...Option) File {
+	for _, opt := range opts {
+		f = opt(f)
+	}
+	return f
+}
+
 // NewFile ...
 func NewFile(originalFilePath string, fileInfo fs.FileInfo) File {
 	logger := logwrap.Get("pt")
-	return File{OriginalFilePath: originalFilePath, FileInfo: fileInfo, logger: logger}
+	return File{OriginalFilePath: originalFilePath, FileInfo: fileInfo, probed: &probe{}, logger: logger}
 }
 
+// TimestampSource is where the timezone of a files timestamp came from.
+type TimestampSource string
+
+const (
+	// TimestampSourceOffset is an offset recorded alongside the capture
+	// time (the exif OffsetTimeOriginal or OffsetTime tags, or the QuickTime
+	// creationdate key).
+	TimestampSourceOffset TimestampSource = "offset"
+	// TimestampSourceGPS is the timezone at the GPS location of the file.
+	TimestampSourceGPS TimestampSource = "gps"
+	// TimestampSourceNautical is a fixed zone derived from the longitude of
+	// the GPS location of the file, which is too far from a known city to
+	// look up its timezone. It ignores timezone borders and daylight saving
+	// time.
+	TimestampSourceNautical TimestampSource = "nautical"
+	// TimestampSourceDevice is the timezone configured for the device.
+	TimestampSourceDevice TimestampSource = "device"
+	// TimestampSourceMetadata is a capture time from the files metadata
+	// without a known timezone.
+	TimestampSourceMetadata TimestampSource = "metadata"
+	// TimestampSourceModTime is the files modification time.
+	TimestampSourceModTime TimestampSource = "mtime"
+)
+
 // Timestamp returns the creation date of the timestamp. If it's unable to find
 // the creation date from the files metadata, it will fall back to the files
 // modification time.
 func (f File) Timestamp() time.Time {
-	if !f.timestamp.IsZero() {
-		return f.timestamp
-	}
+	t, _ := f.TimestampWithSource()
+	return t
+}
 
+// TimestampWithSource returns the creation date of the file in the timezone
+// it was captured in along with where that timezone came from. The timezone is
+// taken from, in order, an offset stored with the capture time, the GPS
+// location of the file and the location set with WithLocation. A date in the
+// files XMP sidecar takes precedence over the files own metadata.
+func (f File) TimestampWithSource() (time.Time, TimestampSource) {
 	creationDate := time.Time{}
+	source := TimestampSourceMetadata
+	sidecar := f.sidecar()
 
 	switch {
-	case f.isImage():
+	case !sidecar.DateTimeOriginal.IsZero():
+		creationDate, source = sidecar.DateTimeOriginal, TimestampSourceOffset
+	case f.isRaw(), f.isImage():
 		exifData, err := f.getExifData()
 		if err != nil {
 			break
@@ -87,69 +160,267 @@ func (f File) Timestamp() time.Time {
 			t2 = "000"
 		}
 
-		ms, err := strconv.Atoi(t2)
+		subSec, err := parseSubSec(t2)
 		if err != nil {
 			break
 		}
 
-		creationDate = dateTimeOriginal.Add(time.Duration(ms) * time.Millisecond)
+		// DateTimeOriginal is the wall clock of the camera, so it is kept
+		// as is and only the timezone is set.
+		loc, src := f.exifLocation(exifData)
+		if loc != nil {
+			source = src
+		} else {
+			loc = time.UTC
+		}
+		creationDate = wallClock(dateTimeOriginal.Add(subSec), loc)
 	case f.isVideo():
-		fh, err := os.Open(f.OriginalFilePath)
-		if err != nil {
-			creationDate = f.FileInfo.ModTime()
+		metadata, creationTime, err := f.videoMetadata()
+
+		if t, err := time.Parse("2006-01-02T15:04:05-0700", metadata[fileutil.QuickTimeCreationDate]); err == nil {
+			creationDate, source = t, TimestampSourceOffset
 			break
 		}
-		defer fh.Close()
-		creationDate, err = fileutil.GetVideoCreationTimeMetadata(fh)
+
+		// The movie header creation time is UTC.
 		if err != nil {
-			creationDate = f.FileInfo.ModTime()
+			break
+		}
+		creationDate = creationTime
+
+		if p, err := geo.ParseISO6709(metadata[fileutil.QuickTimeLocation]); err == nil {
+			loc, src := gpsLocation(p)
+			creationDate, source = creationDate.In(loc), src
+		} else if f.location != nil {
+			creationDate, source = creationDate.In(f.location), TimestampSourceDevice
 		}
 	}
 
 	if creationDate.IsZero() {
 		creationDate = f.FileInfo.ModTime()
+		source = TimestampSourceModTime
+	}
+
+	return creationDate, source
+}
+
+// gpsLocation returns the timezone at p and where it came from: the nearest
+// known city, or the longitude of p if it is far from any.
+func gpsLocation(p geo.Point) (*time.Location, TimestampSource) {
+	loc, ok := geo.Default().LookupTimezone(p)
+	if !ok {
+		return loc, TimestampSourceNautical
+	}
+	return loc, TimestampSourceGPS
+}
+
+// exifLocation returns the timezone for the capture time in exifData and
+// where it came from. A nil location is returned if the timezone is unknown.
+func (f File) exifLocation(exifData map[string]string) (*time.Location, TimestampSource) {
+	for _, k := range []string{"OffsetTimeOriginal", "OffsetTime"} {
+		if loc, err := parseExifOffset(exifData[k]); err == nil {
+			return loc, TimestampSourceOffset
+		}
+	}
+
+	if p, ok := exifGPS(exifData); ok {
+		return gpsLocation(p)
 	}
 
-	f.timestamp = creationDate
+	if f.location != nil {
+		return f.location, TimestampSourceDevice
+	}
 
-	return creationDate
+	return nil, ""
 }
 
-func (f File) isVideo() bool {
-	buf, _ := ioutil.ReadFile(f.OriginalFilePath)
-	return filetype.IsVideo(buf)
+// GPS returns the location the file was captured at. A location in the files
+// XMP sidecar takes precedence over the files own metadata.
+func (f File) GPS() (geo.Point, bool) {
+	if sidecar := f.sidecar(); sidecar.GPS != nil {
+		return *sidecar.GPS, true
+	}
+
+	switch {
+	case f.isRaw(), f.isImage():
+		exifData, err := f.getExifData()
+		if err != nil {
+			return geo.Point{}, false
+		}
+		return exifGPS(exifData)
+	case f.isVideo():
+		metadata, _, _ := f.videoMetadata()
+		p, err := geo.ParseISO6709(metadata[fileutil.QuickTimeLocation])
+		return p, err == nil
+	}
+	return geo.Point{}, false
 }
 
-func (f File) isImage() bool {
-	buf, _ := ioutil.ReadFile(f.OriginalFilePath)
-	return filetype.IsImage(buf)
+// wallClock returns t with its wall clock unchanged in loc.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
 }
 
-// GetExifData returns the exif data for the file if it exists.
-func (f File) getExifData() (map[string]string, error) {
-	if len(f.exifData) >= 1 {
-		return f.exifData, nil
+// parseExifOffset parses an exif offset tag such as "+10:00".
+func parseExifOffset(s string) (*time.Location, error) {
+	t, err := time.Parse("-07:00", strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
 	}
+	_, offset := t.Zone()
+	return time.FixedZone(t.Format("-07:00"), offset), nil
+}
 
-	rawExif, err := exif.SearchFileAndExtractExif(f.OriginalFilePath)
+// exifGPS returns the GPS location found in exifData.
+func exifGPS(exifData map[string]string) (geo.Point, bool) {
+	lat, err := parseExifDegrees(exifData["GPSLatitude"])
+	if err != nil {
+		return geo.Point{}, false
+	}
+	lon, err := parseExifDegrees(exifData["GPSLongitude"])
 	if err != nil {
-		return f.exifData, err
+		return geo.Point{}, false
+	}
+	if strings.HasPrefix(exifData["GPSLatitudeRef"], "S") {
+		lat = -lat
 	}
+	if strings.HasPrefix(exifData["GPSLongitudeRef"], "W") {
+		lon = -lon
+	}
+	return geo.Point{Latitude: lat, Longitude: lon}, true
+}
+
+// parseExifDegrees parses a formatted exif degrees, minutes and seconds
+// rational value (eg; "[37/1 48/1 3000/100]") as decimal degrees.
+func parseExifDegrees(s string) (float64, error) {
+	fields := strings.Fields(strings.Trim(s, "[]"))
+	if len(fields) != 3 {
+		return 0, fmt.Errorf("invalid degrees: %q", s)
+	}
+
+	degrees := 0.0
+	for i, field := range fields {
+		var n, d float64
+		if _, err := fmt.Sscanf(field, "%g/%g", &n, &d); err != nil {
+			return 0, fmt.Errorf("invalid degrees: %q", s)
+		}
+		if d == 0 {
+			continue
+		}
+		degrees += n / d / math.Pow(60, float64(i))
+	}
+	return degrees, nil
+}
 
-	entries, _, err := exif.GetFlatExifData(rawExif, nil)
+// MediaType is the kind of media a file holds.
+type MediaType string
+
+const (
+	MediaTypePhoto MediaType = "photo"
+	MediaTypeVideo MediaType = "video"
+	MediaTypeRaw   MediaType = "raw"
+)
+
+// MediaType returns the kind of media in the file. Camera raw files are
+// MediaTypeRaw rather than MediaTypePhoto.
+func (f File) MediaType() MediaType {
+	switch {
+	case f.isRaw():
+		return MediaTypeRaw
+	case f.isVideo():
+		return MediaTypeVideo
+	}
+	return MediaTypePhoto
+}
+
+// MetadataErr returns the error reading the capture metadata of the file,
+// or nil if it was read or the file has none.
+func (f File) MetadataErr() error {
+	switch {
+	case f.isRaw(), f.isImage():
+		// go-exif wraps its errors so they can't be checked with errors.Is.
+		if _, err := f.getExifData(); err != nil && !log.Is(err, exif.ErrNoExif) {
+			return err
+		}
+	case f.isVideo():
+		if _, _, err := f.videoMetadata(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mediaExtensions are the extensions of the supported file types.
+var mediaExtensions = []string{".jpg", ".jpeg", ".png", ".heic", ".heif", ".cr2", ".cr3", ".nef", ".arw", ".dng", ".mov", ".mp4"}
+
+// HasMediaExtension returns true if p has the extension of a supported file
+// type, regardless of its content.
+func HasMediaExtension(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	for _, i := range mediaExtensions {
+		if ext == i {
+			return true
+		}
+	}
+	return false
+}
+
+// Camera describes the camera and lens that captured a file.
+type Camera struct {
+	Make         string
+	Model        string
+	SerialNumber string
+	LensModel    string
+}
+
+// Camera returns the camera details found in the exif data of the file, or
+// for videos the make and model in their QuickTime metadata.
+func (f File) Camera() Camera {
+	if f.isVideo() {
+		metadata, _, _ := f.videoMetadata()
+		return Camera{
+			Make:  strings.TrimSpace(metadata[fileutil.QuickTimeMake]),
+			Model: strings.TrimSpace(metadata[fileutil.QuickTimeModel]),
+		}
+	}
+
+	exifData, err := f.getExifData()
 	if err != nil {
-		return f.exifData, err
+		return Camera{}
 	}
 
-	m := map[string]string{}
+	c := Camera{
+		Make:         strings.TrimSpace(exifData["Make"]),
+		Model:        strings.TrimSpace(exifData["Model"]),
+		SerialNumber: strings.TrimSpace(exifData["BodySerialNumber"]),
+		LensModel:    strings.TrimSpace(exifData["LensModel"]),
+	}
+	if c.SerialNumber == "" {
+		c.SerialNumber = strings.TrimSpace(exifData["CameraSerialNumber"])
+	}
 
-	for _, i := range entries {
-		m[i.TagName] = fmt.Sprintf("%s", i.Value)
+	return c
+}
+
+// parseSubSec parses the digits of a SubSecTime exif value as the fraction of
+// a second they represent (eg; "5" is 500ms and "05" is 50ms).
+func parseSubSec(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
 	}
 
-	f.exifData = m
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	d := time.Duration(n) * time.Second
+	for i := 0; i < len(s); i++ {
+		d /= 10
+	}
 
-	return f.exifData, nil
+	return d.Truncate(time.Millisecond), nil
 }
 
 // DirName ...
@@ -164,11 +435,41 @@ func (f File) Hash() (string, error) {
 	return fileutil.GetFileHash(f.OriginalFilePath)
 }
 
-// DestinationFilePath returns the file path of the final destination of the file. The path includes the album except if the album is "recents" which is removed from the path.
-func (f File) DestinationFilePath(destinationDir string, deviceName string, creationDate time.Time, opts ...Option) string {
+// DefaultPathLayout is the layout of the directories below the destination
+// directory: year, month, device name and album.
+const DefaultPathLayout = "{{.Year}}/{{.Month}}/{{.Device}}/{{.Album}}"
+
+// PathFields are the fields available to a path layout.
+type PathFields struct {
+	Year    string
+	Month   string
+	Day     string
+	Device  string
+	Album   string
+	Country string
+	Region  string
+	City    string
+}
 
-	for _, opt := range opts {
-		f = opt(f)
+// ParsePathLayout parses layout, a text/template of a directory path using
+// PathFields (eg; {{.Year}}/{{.Country}}/{{.City}}).
+func ParsePathLayout(layout string) (*template.Template, error) {
+	t, err := template.New("path_layout").Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path layout: %w", err)
+	}
+	if err := t.Execute(ioutil.Discard, PathFields{}); err != nil {
+		return nil, fmt.Errorf("invalid path layout: %w", err)
+	}
+	return t, nil
+}
+
+// Album returns the album of the file, which is the directory the file is in
+// unless set with WithAlbum. Camera and date named directories are rewritten
+// to "Recents".
+func (f File) Album() string {
+	if f.album != "" {
+		return f.album
 	}
 
 	album := f.DirName()
@@ -189,51 +490,83 @@ func (f File) DestinationFilePath(destinationDir string, deviceName string, crea
 		}
 	}
 
-	p := path.Join(
-		destinationDir,
-		fmt.Sprintf("%d", creationDate.Year()),
-		fmt.Sprintf("%02d", creationDate.Month()),
-		deviceName,
-		album,
-		strings.Replace(creationDate.Format("20060102-150405.000"), ".", "", 1),
-	)
+	return album
+}
 
-	if f.filenameSuffix != "" {
-		p = fmt.Sprintf("%s-%s", p, f.filenameSuffix)
+// DestinationFilePath returns the file path of the final destination of the file. The path includes the album except if the album is "recents" which is removed from the path.
+func (f File) DestinationFilePath(destinationDir string, deviceName string, creationDate time.Time, opts ...Option) string {
+
+	for _, opt := range opts {
+		f = opt(f)
 	}
 
-	p = fmt.Sprintf("%s%s", p, path.Ext(f.OriginalFilePath))
+	var dir string
+	if f.pathLayout == nil {
+		dir = path.Join(
+			fmt.Sprintf("%d", creationDate.Year()),
+			fmt.Sprintf("%02d", creationDate.Month()),
+			deviceName,
+			f.Album(),
+		)
+	} else {
+		dir = f.layoutDir(deviceName, creationDate)
+	}
 
-	return p
+	return path.Join(destinationDir, dir, f.FileName(creationDate))
 }
 
-// IsSupportedFileType checks if the file is supported.  supportedTypes
-// contains the list of supported file types.
-func IsSupportedFileType(originalFilePath string) (bool, error) {
-	f, err := os.Open(originalFilePath)
-	if err != nil {
-		return false, err
+// FileName returns the file name of the file for creationDate, including the
+// suffix set with WithFilenameSuffix and the extension of the original file
+// (eg; 20120130-160001001.JPG).
+func (f File) FileName(creationDate time.Time, opts ...Option) string {
+	for _, opt := range opts {
+		f = opt(f)
 	}
-	defer f.Close()
 
-	supportedTypes := []types.Type{
-		types.Get("jpg"),
-		types.Get("png"),
-		types.Get("heif"),
-		types.Get("cr2"),
-		types.Get("mov"),
-		types.Get("mp4"),
+	name := strings.Replace(creationDate.Format("20060102-150405.000"), ".", "", 1)
+	if f.filenameSuffix != "" {
+		name = fmt.Sprintf("%s-%s", name, f.filenameSuffix)
+	}
+	return name + path.Ext(f.OriginalFilePath)
+}
+
+// layoutDir returns the directory path of the file using its path layout.
+// Place fields are empty if the file has no location or it's not near a known
+// city.
+func (f File) layoutDir(deviceName string, creationDate time.Time) string {
+	// Fields are used as path components so can't contain a separator.
+	clean := func(s string) string {
+		return strings.ReplaceAll(s, "/", "-")
 	}
 
-	head := make([]byte, 261)
-	f.Read(head)
-	for _, i := range supportedTypes {
-		if filetype.IsType(head, i) {
-			return true, nil
+	fields := PathFields{
+		Year:   fmt.Sprintf("%d", creationDate.Year()),
+		Month:  fmt.Sprintf("%02d", creationDate.Month()),
+		Day:    fmt.Sprintf("%02d", creationDate.Day()),
+		Device: clean(deviceName),
+		Album:  clean(f.Album()),
+	}
+	if p, ok := f.GPS(); ok {
+		if c, ok := geo.Default().Place(p); ok {
+			fields.Country, fields.Region, fields.City = clean(c.Country), clean(c.Region), clean(c.Name)
 		}
 	}
 
-	return false, nil
+	b := &strings.Builder{}
+	if err := f.pathLayout.Execute(b, fields); err != nil {
+		// The layout is checked by ParsePathLayout, so fall back to the
+		// default layout rather than failing the copy.
+		return path.Join(fields.Year, fields.Month, fields.Device, fields.Album)
+	}
+	return b.String()
+}
+
+// IsSupportedFileType checks if the file is supported.  supportedTypes
+// contains the list of supported file types. Camera raw files supported by
+// the raw package are also supported. Use File.IsSupported for a File that
+// is read further.
+func IsSupportedFileType(originalFilePath string) (bool, error) {
+	return NewFile(originalFilePath, nil).IsSupported()
 }
 
 // DirName returns the dirname of f.
@@ -243,6 +576,37 @@ func DirName(f string) string {
 	return dirs[len(dirs)-1]
 }
 
+// ArchivePath is a file path relative to the destination directory split
+// into the components of DestinationFilePath.
+type ArchivePath struct {
+	Year       string
+	Month      string
+	DeviceName string
+	Album      string
+	FileName   string
+}
+
+// ParseArchivePath splits rel, a file path relative to the destination
+// directory, into its year, month, device name, album and file name. The
+// device name is empty for files copied without a device name. Paths of a
+// custom layout, where a component may be empty or not be in the path at
+// all, aren't parsed: false is returned for any path unless layout is nil or
+// DefaultPathLayout.
+func ParseArchivePath(rel string, layout *template.Template) (ArchivePath, bool) {
+	if layout != nil && layout.Tree.Root.String() != DefaultPathLayout {
+		return ArchivePath{}, false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	switch len(parts) {
+	case 5:
+		return ArchivePath{parts[0], parts[1], parts[2], parts[3], parts[4]}, true
+	case 4:
+		return ArchivePath{parts[0], parts[1], "", parts[2], parts[3]}, true
+	}
+	return ArchivePath{}, false
+}
+
 // DeviceName ...
 func DeviceName(deviceNames map[string][]string, originalFilePath string) string {
 	// Check if deviceNames paths are absolute paths.
@@ -266,3 +630,35 @@ func DeviceName(deviceNames map[string][]string, originalFilePath string) string
 	}
 	return ""
 }
+
+// DeviceNameOverlaps describes each pair of paths of different devices in
+// deviceNames which can both match a file, as DeviceName returns either of
+// them: an absolute path within another, or the same directory name.
+func DeviceNameOverlaps(deviceNames map[string][]string) []string {
+	type devicePath struct{ name, path string }
+	paths := []devicePath{}
+	for name, v := range deviceNames {
+		for _, p := range v {
+			paths = append(paths, devicePath{name, p})
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].path != paths[j].path {
+			return paths[i].path < paths[j].path
+		}
+		return paths[i].name < paths[j].name
+	})
+
+	overlaps := []string{}
+	for i, a := range paths {
+		for _, b := range paths[i+1:] {
+			if a.name == b.name || strings.HasPrefix(a.path, "/") != strings.HasPrefix(b.path, "/") {
+				continue
+			}
+			if a.path == b.path || strings.HasPrefix(a.path, "/") && strings.HasPrefix(b.path, a.path) {
+				overlaps = append(overlaps, fmt.Sprintf("%s path %s overlaps %s path %s", a.name, a.path, b.name, b.path))
+			}
+		}
+	}
+	return overlaps
+}
@@ -1,9 +1,18 @@
 package file
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pt/internal/fileutil"
+	"pt/internal/geo"
+	"pt/internal/xmp"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDeviceName(t *testing.T) {
@@ -39,3 +48,284 @@ func TestDeviceName(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceNameOverlaps(t *testing.T) {
+	tests := []struct {
+		deviceNames map[string][]string
+		expect      []string
+	}{
+		{map[string][]string{"alice": {"phone", "/a/phone"}, "bob": {"/b/phone"}}, []string{}},
+		{map[string][]string{"alice": {"/a"}, "bob": {"/a/phone"}}, []string{"alice path /a overlaps bob path /a/phone"}},
+		{map[string][]string{"alice": {"phone"}, "bob": {"phone"}}, []string{"alice path phone overlaps bob path phone"}},
+		{map[string][]string{"alice": {"phone"}, "bob": {"/a/phone"}}, []string{}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expect, DeviceNameOverlaps(test.deviceNames))
+	}
+}
+
+func TestParseSubSec(t *testing.T) {
+	tests := []struct {
+		subSec string
+		expect time.Duration
+	}{
+		{"000", 0},
+		{"5", 500 * time.Millisecond},
+		{"05", 50 * time.Millisecond},
+		{"123", 123 * time.Millisecond},
+		{"1234", 123 * time.Millisecond},
+		{"", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.subSec, func(t *testing.T) {
+			d, err := parseSubSec(test.subSec)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expect, d)
+		})
+	}
+}
+
+func TestParseExifDegrees(t *testing.T) {
+	d, err := parseExifDegrees("[37/1 48/1 3000/100]")
+	assert.NoError(t, err)
+	assert.InDelta(t, 37.808333, d, 0.000001)
+
+	_, err = parseExifDegrees("[37/1]")
+	assert.Error(t, err)
+}
+
+func TestParseExifOffset(t *testing.T) {
+	tests := []struct {
+		offset string
+		expect int
+	}{
+		{"+10:00", 10 * 60 * 60},
+		{"-03:30", -(3*60 + 30) * 60},
+		{"+00:00", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.offset, func(t *testing.T) {
+			loc, err := parseExifOffset(test.offset)
+			assert.NoError(t, err)
+			_, offset := time.Date(2022, 1, 30, 16, 0, 1, 0, loc).Zone()
+			assert.Equal(t, test.expect, offset)
+		})
+	}
+
+	_, err := parseExifOffset("   :  ")
+	assert.Error(t, err)
+}
+
+func TestParseTimeShift(t *testing.T) {
+	tests := []struct {
+		shift  string
+		expect time.Duration
+		err    bool
+	}{
+		{"+1h", time.Hour, false},
+		{"-30m", -30 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"+2d", 48 * time.Hour, false},
+		{"-1d2h", -26 * time.Hour, false},
+		{"", 0, true},
+		{"+", 0, true},
+		{"1x", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.shift, func(t *testing.T) {
+			d, err := ParseTimeShift(test.shift)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expect, d)
+		})
+	}
+}
+
+func TestClockOffsets(t *testing.T) {
+	offsets := ClockOffsets{
+		{DeviceName: "alice", From: time.Date(2022, 3, 27, 0, 0, 0, 0, time.UTC), Until: time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC), Offset: time.Hour},
+		{DeviceName: "bob", Offset: -time.Minute},
+	}
+	melbourne, _ := time.LoadLocation("Australia/Melbourne")
+	tests := []struct {
+		deviceName string
+		t          time.Time
+		expect     time.Duration
+	}{
+		{"alice", time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC), time.Hour},
+		{"alice", time.Date(2022, 3, 27, 0, 0, 0, 0, melbourne), time.Hour},
+		{"alice", time.Date(2022, 10, 30, 0, 0, 0, 0, melbourne), 0},
+		{"alice", time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC), 0},
+		{"bob", time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), -time.Minute},
+		{"", time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.deviceName, func(t *testing.T) {
+			assert.Equal(t, test.expect, offsets.Offset(test.deviceName, test.t))
+		})
+	}
+}
+
+func TestDestinationFilePathLayout(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "100APPLE")
+	f := File{OriginalFilePath: filepath.Join(dir, "IMG_0001.JPG")}
+	timestamp := time.Date(2022, 1, 2, 3, 4, 5, 6e6, time.UTC)
+
+	layout, err := ParsePathLayout("{{.Year}}/{{.Country}}/{{.City}}/{{.Album}}")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/dst/2022/01/phone/Recents/20220102-030405006.JPG", f.DestinationFilePath("/dst", "phone", timestamp))
+	assert.Equal(t, "/dst/2022/01/phone/Holiday/20220102-030405006.JPG", f.DestinationFilePath("/dst", "phone", timestamp, WithAlbum("Holiday")))
+
+	// Place fields of files without a location are left out.
+	assert.Equal(t, "/dst/2022/Recents/20220102-030405006.JPG", f.DestinationFilePath("/dst", "phone", timestamp, WithPathLayout(layout)))
+
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	packet, err := xmp.Properties{GPS: &geo.Point{Latitude: -37.81, Longitude: 144.96}}.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "IMG_0001.xmp"), packet, 0644))
+	assert.Equal(t, "/dst/2022/AU/Melbourne/Recents/20220102-030405006.JPG", f.DestinationFilePath("/dst", "phone", timestamp, WithPathLayout(layout)))
+
+	_, err = ParsePathLayout("{{.Year}}/{{.Nope}}")
+	assert.Error(t, err)
+}
+
+func TestSidecars(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"IMG_0001.JPG", "IMG_0001.xmp", "IMG_0001.JPG.xmp", "img_0001.AAE", "IMG_0001.CR2", "IMG_0002.JPG", "IMG_0002.xmp", "MVI_0003.MOV", "MVI_0003.THM"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	sidecars, err := Sidecars(filepath.Join(dir, "IMG_0001.JPG"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{filepath.Join(dir, "IMG_0001.xmp"), filepath.Join(dir, "IMG_0001.JPG.xmp"), filepath.Join(dir, "img_0001.AAE")}, sidecars)
+
+	sidecars, err = Sidecars(filepath.Join(dir, "MVI_0003.MOV"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "MVI_0003.THM")}, sidecars)
+
+	assert.True(t, IsSidecar("IMG_0001.XMP"))
+	assert.False(t, IsSidecar("IMG_0001.JPG"))
+
+	owners, err := SidecarOwners(filepath.Join(dir, "IMG_0001.xmp"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "IMG_0001.CR2"), filepath.Join(dir, "IMG_0001.JPG")}, owners)
+	owners, err = SidecarOwners(filepath.Join(dir, "IMG_0001.JPG.xmp"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "IMG_0001.JPG")}, owners)
+
+	assert.Equal(t, "/photos/20220102-030405000.xmp", SidecarDestination("/card/IMG_0001.JPG", "/card/IMG_0001.xmp", "/photos/20220102-030405000.JPG"))
+	assert.Equal(t, "/photos/20220102-030405000.JPG.xmp", SidecarDestination("/card/IMG_0001.JPG", "/card/IMG_0001.JPG.xmp", "/photos/20220102-030405000.JPG"))
+}
+
+func TestDeviceRules(t *testing.T) {
+	rules := DeviceRules{
+		DeviceNames: map[string][]string{"rene": {"/media/phone-photos/rene"}},
+		Rules: []DeviceRule{
+			{DeviceName: "pixel", FileName: "PXL_*"},
+			{DeviceName: "kids-camera", Make: "NIKON*", SerialNumber: "3001234"},
+			{DeviceName: "nikon", Make: "nikon corporation"},
+			{DeviceName: "iphone", Model: "iPhone 1?*", Priority: 10},
+			{DeviceName: "empty"},
+		},
+		Fallback: "unknown",
+	}
+
+	tests := []struct {
+		path   string
+		camera Camera
+		expect string
+	}{
+		{"/media/phone-photos/rene/DCIM/PXL_20220102.jpg", Camera{}, "rene"},
+		{"/media/card/DCIM/PXL_20220102.jpg", Camera{}, "pixel"},
+		{"/media/card/DCIM/DSC_0001.NEF", Camera{Make: "NIKON CORPORATION", SerialNumber: "3001234"}, "kids-camera"},
+		{"/media/card/DCIM/DSC_0001.NEF", Camera{Make: "NIKON CORPORATION", SerialNumber: "3009999"}, "nikon"},
+		{"/media/card/DCIM/PXL_0001.HEIC", Camera{Make: "Apple", Model: "iPhone 13"}, "iphone"},
+		{"/media/card/DCIM/IMG_0001.JPG", Camera{Make: "Canon"}, "unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.expect, rules.Match(test.path, test.camera))
+		})
+	}
+}
+
+func TestSuggestDeviceName(t *testing.T) {
+	tests := []struct {
+		camera Camera
+		expect string
+	}{
+		{Camera{Make: "Google", Model: "Pixel 6"}, "pixel-6"},
+		{Camera{Make: "NIKON CORPORATION", Model: "NIKON D3400"}, "nikon-d3400"},
+		{Camera{Make: "Apple", Model: " iPhone 13 Pro "}, "iphone-13-pro"},
+		{Camera{Make: "Canon"}, "canon"},
+		{Camera{}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.expect, func(t *testing.T) {
+			assert.Equal(t, test.expect, SuggestDeviceName(test.camera))
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "IMG_0001.JPG")
+	require.NoError(t, ioutil.WriteFile(p, []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), 0644))
+
+	f := NewFile(p, nil)
+	supported, err := f.IsSupported()
+	require.NoError(t, err)
+	assert.True(t, supported)
+	ext, mime, err := f.FileType()
+	require.NoError(t, err)
+	assert.Equal(t, "jpg", ext)
+	assert.Equal(t, "image/jpeg", mime)
+	assert.Equal(t, MediaTypePhoto, f.MediaType())
+
+	// The file is probed once, copies of f share what was read.
+	require.NoError(t, ioutil.WriteFile(p, []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2"), 0644))
+	assert.Equal(t, MediaTypePhoto, f.WithOptions(WithAlbum("Holiday")).MediaType())
+	assert.Equal(t, MediaTypeVideo, NewFile(p, nil).MediaType())
+
+	require.NoError(t, ioutil.WriteFile(p, []byte("not a photo"), 0644))
+	supported, err = IsSupportedFileType(p)
+	require.NoError(t, err)
+	assert.False(t, supported)
+	_, _, err = NewFile(p, nil).FileType()
+	assert.Equal(t, fileutil.ErrUnknownFileType, err)
+
+	_, err = IsSupportedFileType(filepath.Join(dir, "missing.jpg"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseArchivePath(t *testing.T) {
+	defaultLayout, err := ParsePathLayout(DefaultPathLayout)
+	assert.NoError(t, err)
+	customLayout, err := ParsePathLayout("{{.Year}}/{{.Country}}/{{.City}}/{{.Album}}")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		rel    string
+		layout *template.Template
+		want   ArchivePath
+		ok     bool
+	}{
+		{"default", "2022/01/phone/Recents/20220102-030405006.JPG", nil, ArchivePath{"2022", "01", "phone", "Recents", "20220102-030405006.JPG"}, true},
+		{"no device", "2022/01/Recents/20220102-030405006.JPG", nil, ArchivePath{"2022", "01", "", "Recents", "20220102-030405006.JPG"}, true},
+		{"default layout", "2022/01/phone/Recents/20220102-030405006.JPG", defaultLayout, ArchivePath{"2022", "01", "phone", "Recents", "20220102-030405006.JPG"}, true},
+		{"not archived", "Recents/20220102-030405006.JPG", nil, ArchivePath{}, false},
+		{"custom layout", "2022/AU/Melbourne/Recents/20220102-030405006.JPG", customLayout, ArchivePath{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseArchivePath(tt.rel, tt.layout)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"pt/internal/fileutil"
+	"pt/internal/raw"
+	"pt/internal/xmp"
+	"sync"
+	"time"
+
+	"github.com/dsoprea/go-exif/v3"
+	"github.com/h2non/filetype"
+	"github.com/h2non/filetype/types"
+)
+
+// headerSize is the number of bytes at the start of a file read to detect its
+// type.
+const headerSize = 261
+
+// exifSearchSize is the number of bytes at the start of a file searched for
+// exif data before searching the whole file. The exif segment of a JPEG is at
+// most 64K and near the start.
+const exifSearchSize = 1 << 20
+
+// supportedTypes are the file types supported along with the camera raw files
+// supported by the raw package.
+var supportedTypes = []types.Type{
+	types.Get("jpg"),
+	types.Get("png"),
+	types.Get("heif"),
+	types.Get("cr2"),
+	types.Get("mov"),
+	types.Get("mp4"),
+}
+
+// probe holds what has been read from a file so it is read once. It is shared
+// by the copies of a File made by its value methods and options.
+type probe struct {
+	headerOnce sync.Once
+	header     []byte
+	headerErr  error
+
+	exifOnce sync.Once
+	exifData map[string]string
+	exifErr  error
+
+	videoOnce         sync.Once
+	videoMetadata     map[string]string
+	videoCreationTime time.Time
+	videoErr          error
+
+	sidecarOnce sync.Once
+	sidecar     xmp.Properties
+}
+
+// probe returns the probe of f. A File made without NewFile isn't cached.
+func (f File) probe() *probe {
+	if f.probed == nil {
+		return &probe{}
+	}
+	return f.probed
+}
+
+// header returns up to headerSize bytes from the start of the file.
+func (f File) header() ([]byte, error) {
+	p := f.probe()
+	p.headerOnce.Do(func() {
+		fh, err := os.Open(f.OriginalFilePath)
+		if err != nil {
+			p.headerErr = err
+			return
+		}
+		defer fh.Close()
+
+		head := make([]byte, headerSize)
+		n, err := io.ReadFull(fh, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			p.headerErr = err
+			return
+		}
+		p.header = head[:n]
+	})
+	return p.header, p.headerErr
+}
+
+func (f File) isVideo() bool {
+	head, _ := f.header()
+	return filetype.IsVideo(head)
+}
+
+func (f File) isRaw() bool {
+	head, _ := f.header()
+	_, ok := raw.Detect(head, path.Ext(f.OriginalFilePath))
+	return ok
+}
+
+func (f File) isImage() bool {
+	head, _ := f.header()
+	return filetype.IsImage(head)
+}
+
+// IsSupported returns true if the file is a supported type. An error is
+// returned if the file can't be read.
+func (f File) IsSupported() (bool, error) {
+	head, err := f.header()
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := raw.Detect(head, path.Ext(f.OriginalFilePath)); ok {
+		return true, nil
+	}
+
+	for _, i := range supportedTypes {
+		if filetype.IsType(head, i) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// FileType returns the extension and MIME type of the files content, or
+// fileutil.ErrUnknownFileType if it isn't known.
+func (f File) FileType() (string, string, error) {
+	head, err := f.header()
+	if err != nil {
+		return "", "", err
+	}
+	kind, _ := filetype.Match(head)
+	if kind == filetype.Unknown {
+		return "", "", fileutil.ErrUnknownFileType
+	}
+	return kind.Extension, kind.MIME.Value, nil
+}
+
+// getExifData returns the exif data for the file if it exists.
+func (f File) getExifData() (map[string]string, error) {
+	p := f.probe()
+	p.exifOnce.Do(func() {
+		p.exifData, p.exifErr = readExifData(f.OriginalFilePath)
+	})
+	return p.exifData, p.exifErr
+}
+
+// readExifData reads the exif tags of the file at name.
+func readExifData(name string) (map[string]string, error) {
+	// Raw files are parsed as TIFF or CR3 containers, everything else is
+	// searched for an exif blob.
+	entries, err := raw.ReadExifTags(name)
+	if err == raw.ErrNotRaw {
+		entries, err = searchExifTags(name)
+	}
+	if err != nil && len(entries) == 0 {
+		return nil, err
+	}
+
+	m := map[string]string{}
+
+	// Non string values such as the rationals of GPS tags are stored
+	// formatted.
+	for _, i := range entries {
+		if v, ok := i.Value.(string); ok {
+			m[i.TagName] = v
+		} else {
+			m[i.TagName] = i.Formatted
+		}
+	}
+
+	return m, nil
+}
+
+// searchExifTags returns the tags of the exif blob found in the file at name.
+// The start of the file is searched first so only files with exif data far
+// from their start, or none, are read in full.
+func searchExifTags(name string) ([]exif.ExifTag, error) {
+	fh, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	entries, err := flatExifTags(io.LimitReader(fh, exifSearchSize))
+	if err == nil || len(entries) > 0 {
+		return entries, err
+	}
+
+	// The blob was missing or cut short by the search size.
+	info, statErr := fh.Stat()
+	if statErr != nil || info.Size() <= exifSearchSize {
+		return nil, err
+	}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return flatExifTags(fh)
+}
+
+// flatExifTags returns the tags of the first exif blob read from r.
+func flatExifTags(r io.Reader) ([]exif.ExifTag, error) {
+	rawExif, err := exif.SearchAndExtractExifWithReader(r)
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := exif.GetFlatExifData(rawExif, nil)
+	return entries, err
+}
+
+// videoMetadata returns the QuickTime metadata and movie header creation time
+// of the file. The error is that of opening the file or reading the creation
+// time.
+func (f File) videoMetadata() (map[string]string, time.Time, error) {
+	p := f.probe()
+	p.videoOnce.Do(func() {
+		p.videoMetadata = map[string]string{}
+
+		fh, err := os.Open(f.OriginalFilePath)
+		if err != nil {
+			p.videoErr = err
+			return
+		}
+		defer fh.Close()
+
+		if info, err := fh.Stat(); err == nil {
+			p.videoMetadata, _ = fileutil.GetVideoMetadata(fh, info.Size())
+		}
+
+		if p.videoCreationTime, err = fileutil.GetVideoCreationTimeMetadata(fh); err != nil {
+			p.videoErr = fmt.Errorf("reading movie header: %w", err)
+		}
+	})
+	return p.videoMetadata, p.videoCreationTime, p.videoErr
+}
+
+// sidecar returns the properties of the files XMP sidecar. Empty properties
+// are returned if the file has no readable sidecar.
+func (f File) sidecar() xmp.Properties {
+	p := f.probe()
+	p.sidecarOnce.Do(func() {
+		sidecarPath, ok := xmp.FindSidecar(f.OriginalFilePath)
+		if !ok {
+			return
+		}
+		props, err := xmp.ReadSidecar(sidecarPath)
+		if err == nil {
+			p.sidecar = props
+		}
+	})
+	return p.sidecar
+}
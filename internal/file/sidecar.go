@@ -0,0 +1,69 @@
+package file
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"pt/internal/fileutil"
+	"strings"
+)
+
+// IsSidecar returns true if p is a sidecar file: XMP metadata, Apple photo
+// edits and Canon video thumbnails.
+func IsSidecar(p string) bool {
+	return fileutil.IsSidecar(p)
+}
+
+// Sidecars returns the paths of the sidecar files of p, being files in the
+// same directory with the same base name (ignoring case), or the name of p
+// (eg; IMG_0001.CR3.xmp), and a sidecar extension.
+func Sidecars(p string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	sidecars := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !IsSidecar(name) || name == filepath.Base(p) {
+			continue
+		}
+		if stem := strings.TrimSuffix(name, filepath.Ext(name)); strings.EqualFold(stem, base) || strings.EqualFold(stem, filepath.Base(p)) {
+			sidecars = append(sidecars, filepath.Join(filepath.Dir(p), name))
+		}
+	}
+	return sidecars, nil
+}
+
+// SidecarDestination returns the path of sidecar, a sidecar of p, once p is
+// moved to dst. A sidecar named after the name of p (eg; IMG_0001.CR3.xmp)
+// is named after the name of dst, others after its base name.
+func SidecarDestination(p, sidecar, dst string) string {
+	if stem := strings.TrimSuffix(filepath.Base(sidecar), filepath.Ext(sidecar)); strings.EqualFold(stem, filepath.Base(p)) {
+		return dst + filepath.Ext(sidecar)
+	}
+	return strings.TrimSuffix(dst, filepath.Ext(dst)) + filepath.Ext(sidecar)
+}
+
+// SidecarOwners returns the paths of the files sidecar is a sidecar of, such
+// as both files of a RAW+JPEG pair for IMG_0001.xmp.
+func SidecarOwners(sidecar string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Dir(sidecar))
+	if err != nil {
+		return nil, err
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(sidecar), filepath.Ext(sidecar))
+	owners := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || IsSidecar(name) {
+			continue
+		}
+		if strings.EqualFold(stem, name) || strings.EqualFold(stem, strings.TrimSuffix(name, filepath.Ext(name))) {
+			owners = append(owners, filepath.Join(filepath.Dir(sidecar), name))
+		}
+	}
+	return owners, nil
+}
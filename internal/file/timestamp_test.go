@@ -0,0 +1,262 @@
+package file
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"pt/internal/fileutil"
+	"pt/internal/geo"
+	"pt/internal/jpegmeta"
+	"pt/internal/jpegmeta/jpegmetatest"
+	"pt/internal/xmp"
+	"testing"
+	"time"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exifJPEG returns a JPEG with tags set in its exif IFD and, if gps isn't
+// nil, a GPS IFD with its location.
+func exifJPEG(t *testing.T, tags map[string]string, gps *geo.Point) []byte {
+	t.Helper()
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	require.NoError(t, err)
+	rootIb := exif.NewIfdBuilder(im, exif.NewTagIndex(), exifcommon.IfdStandardIfdIdentity, exifcommon.EncodeDefaultByteOrder)
+
+	exifIb, err := exif.GetOrCreateIbFromRootIb(rootIb, exifcommon.IfdExifStandardIfdIdentity.String())
+	require.NoError(t, err)
+	for name, value := range tags {
+		require.NoError(t, exifIb.SetStandardWithName(name, value))
+	}
+
+	if gps != nil {
+		gpsIb, err := exif.GetOrCreateIbFromRootIb(rootIb, exifcommon.IfdGpsInfoStandardIfdIdentity.String())
+		require.NoError(t, err)
+		degrees := func(v float64) []exifcommon.Rational {
+			return []exifcommon.Rational{{Numerator: uint32(v * 10000), Denominator: 10000}, {Numerator: 0, Denominator: 1}, {Numerator: 0, Denominator: 1}}
+		}
+		for name, value := range map[string]interface{}{
+			"GPSVersionID":    []uint8{2, 2, 0, 0},
+			"GPSLatitudeRef":  "N",
+			"GPSLatitude":     degrees(gps.Latitude),
+			"GPSLongitudeRef": "E",
+			"GPSLongitude":    degrees(gps.Longitude),
+		} {
+			require.NoError(t, gpsIb.SetStandardWithName(name, value))
+		}
+	}
+
+	exifData, err := exif.NewIfdByteEncoder().EncodeToExif(rootIb)
+	require.NoError(t, err)
+	data, err := jpegmeta.SetExif(jpegmetatest.JFIF(), exifData)
+	require.NoError(t, err)
+	return data
+}
+
+// box returns an ISO base media box of typ holding payload.
+func box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(size))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// quickTime returns a QuickTime movie with a movie header creation time of
+// created and the string values of keys in its metadata.
+func quickTime(created time.Time, keys map[string]string) []byte {
+	mvhd := make([]byte, 8)
+	binary.BigEndian.PutUint32(mvhd[4:], uint32(created.Unix()+2082844800))
+
+	keysPayload := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(keys)))
+	items := []byte{}
+	i := 0
+	for k, v := range keys {
+		i++
+		keysPayload = append(keysPayload, box("mdta", []byte(k))...)
+		data := append(binary.BigEndian.AppendUint32(nil, 1), make([]byte, 4)...)
+		items = append(items, box(string(binary.BigEndian.AppendUint32(nil, uint32(i))), box("data", data, []byte(v)))...)
+	}
+
+	return append(
+		box("ftyp", []byte("qt  "), make([]byte, 4), []byte("qt  ")),
+		box("moov", box("mvhd", mvhd), box("meta", box("keys", keysPayload), box("ilst", items)))...,
+	)
+}
+
+func TestTimestampWithSource(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	plus10 := time.FixedZone("", 10*60*60)
+	mtime := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+	captured := map[string]string{"DateTimeOriginal": "2022:01:02 03:04:05", "SubSecTimeOriginal": "006"}
+	withTags := func(tags map[string]string) map[string]string {
+		m := map[string]string{}
+		for _, tags := range []map[string]string{captured, tags} {
+			for k, v := range tags {
+				m[k] = v
+			}
+		}
+		return m
+	}
+	parisPoint := &geo.Point{Latitude: 48.8566, Longitude: 2.3522}
+
+	tests := []struct {
+		name     string
+		fileName string
+		content  []byte
+		sidecar  *xmp.Properties
+		location *time.Location
+		want     time.Time
+		source   TimestampSource
+	}{
+		{
+			name:     "capture time",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, captured, nil),
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 6e6, time.UTC),
+			source:   TimestampSourceMetadata,
+		},
+		{
+			name:     "offset time original",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, withTags(map[string]string{"OffsetTimeOriginal": "+10:00", "OffsetTime": "+02:00"}), parisPoint),
+			location: tokyo,
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 6e6, plus10),
+			source:   TimestampSourceOffset,
+		},
+		{
+			name:     "offset time",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, withTags(map[string]string{"OffsetTime": "+10:00"}), nil),
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 6e6, plus10),
+			source:   TimestampSourceOffset,
+		},
+		{
+			name:     "gps",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, captured, parisPoint),
+			location: tokyo,
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 6e6, paris),
+			source:   TimestampSourceGPS,
+		},
+		{
+			name:     "gps far from a city",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, captured, &geo.Point{Latitude: 40, Longitude: 170}),
+			location: tokyo,
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 6e6, time.FixedZone("UTC+11", 11*60*60)),
+			source:   TimestampSourceNautical,
+		},
+		{
+			name:     "device",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, captured, nil),
+			location: tokyo,
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 6e6, tokyo),
+			source:   TimestampSourceDevice,
+		},
+		{
+			name:     "sidecar over exif",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, withTags(map[string]string{"OffsetTimeOriginal": "+02:00"}), parisPoint),
+			sidecar:  &xmp.Properties{DateTimeOriginal: time.Date(1987, 6, 1, 12, 30, 0, 0, plus10)},
+			want:     time.Date(1987, 6, 1, 12, 30, 0, 0, plus10),
+			source:   TimestampSourceOffset,
+		},
+		{
+			name:     "sidecar without a date",
+			fileName: "IMG_0001.JPG",
+			content:  exifJPEG(t, captured, nil),
+			sidecar:  &xmp.Properties{Description: "beach"},
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 6e6, time.UTC),
+			source:   TimestampSourceMetadata,
+		},
+		{
+			name:     "no capture time",
+			fileName: "IMG_0001.JPG",
+			content:  jpegmetatest.JFIF(),
+			location: tokyo,
+			want:     mtime,
+			source:   TimestampSourceModTime,
+		},
+		{
+			name:     "video creation date",
+			fileName: "IMG_0001.MOV",
+			content: quickTime(time.Date(2022, 1, 1, 16, 4, 5, 0, time.UTC), map[string]string{
+				fileutil.QuickTimeCreationDate: "2022-01-02T03:04:05+1100",
+				fileutil.QuickTimeLocation:     "+48.8566+002.3522/",
+			}),
+			location: tokyo,
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 0, time.FixedZone("", 11*60*60)),
+			source:   TimestampSourceOffset,
+		},
+		{
+			name:     "video location",
+			fileName: "IMG_0001.MOV",
+			content:  quickTime(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), map[string]string{fileutil.QuickTimeLocation: "+48.8566+002.3522/"}),
+			location: tokyo,
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC).In(paris),
+			source:   TimestampSourceGPS,
+		},
+		{
+			name:     "video device",
+			fileName: "IMG_0001.MOV",
+			content:  quickTime(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), nil),
+			location: tokyo,
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC).In(tokyo),
+			source:   TimestampSourceDevice,
+		},
+		{
+			name:     "video creation time",
+			fileName: "IMG_0001.MOV",
+			content:  quickTime(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), nil),
+			want:     time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+			source:   TimestampSourceMetadata,
+		},
+		{
+			name:     "video sidecar",
+			fileName: "IMG_0001.MOV",
+			content:  quickTime(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), nil),
+			sidecar:  &xmp.Properties{DateTimeOriginal: time.Date(1987, 6, 1, 12, 30, 0, 0, plus10)},
+			want:     time.Date(1987, 6, 1, 12, 30, 0, 0, plus10),
+			source:   TimestampSourceOffset,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p := filepath.Join(dir, tt.fileName)
+			require.NoError(t, os.WriteFile(p, tt.content, 0644))
+			require.NoError(t, os.Chtimes(p, mtime, mtime))
+			if tt.sidecar != nil {
+				packet, err := tt.sidecar.Marshal()
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(xmp.SidecarPath(p), packet, 0644))
+			}
+			info, err := os.Stat(p)
+			require.NoError(t, err)
+
+			f := NewFile(p, info)
+			if tt.location != nil {
+				f = f.WithOptions(WithLocation(tt.location))
+			}
+			got, source := f.TimestampWithSource()
+			assert.Equal(t, tt.source, source)
+			assert.True(t, tt.want.Equal(got), "got %s, want %s", got, tt.want)
+			_, wantOffset := tt.want.Zone()
+			_, gotOffset := got.Zone()
+			assert.Equal(t, wantOffset, gotOffset)
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path"
 	"pt/internal/logwrap"
+	"sync"
 	"time"
 
 	"github.com/h2non/filetype"
@@ -21,59 +22,222 @@ var (
 	ErrFileExists = errors.New("file exists")
 )
 
-// Copy ...
+// Copy copies src to dst. dst is never overwritten, ErrFileExists is
+// returned instead. The copy is written to a hidden partial file next to dst
+// which is renamed to dst once complete, a partial file left by an earlier
+// copy is resumed from the last byte matching src. Transient errors reading
+// src or writing dst are retried (see SetRetryPolicy).
 func Copy(src, dst string, BUFFERSIZE int64) error {
+	_, err := CopyAndHash(src, dst, BUFFERSIZE)
+	return err
+}
+
+// CopyAndHash copies src to dst as Copy does and returns the hash of the
+// copied bytes, as GetFileHash would return for dst, so the copy isn't read
+// again to hash it.
+func CopyAndHash(src, dst string, BUFFERSIZE int64) (string, error) {
 	logger := logwrap.Get("pt")
 	if logger == nil {
-		return fmt.Errorf("Failed to get logwrap")
+		return "", fmt.Errorf("Failed to get logwrap")
 	}
 
+	// Copies to the same destination share its partial file, they are
+	// made one at a time so the later copies find dst exists.
+	unlock := lockDestination(dst)
+	defer unlock()
+
 	_, err := os.Stat(dst)
 	if err == nil {
-		return ErrFileExists
+		return "", ErrFileExists
 	}
 
-	os.MkdirAll(path.Dir(dst), os.ModePerm)
+	if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+		return "", err
+	}
 	sourceFileStat, err := os.Stat(src)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if !sourceFileStat.Mode().IsRegular() {
-		return fmt.Errorf("%s is not a regular file", src)
+		return "", fmt.Errorf("%s is not a regular file", src)
 	}
 
-	source, err := os.Open(src)
+	partial := partialPath(dst)
+	destination, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer source.Close()
+	defer destination.Close()
+	partials.Store(partial, true)
+	defer partials.Delete(partial)
 
+	h := sha256.New()
+	offset, err := verifiedOffset(src, destination, BUFFERSIZE, h)
+	if err != nil {
+		return "", err
+	}
+	if err := destination.Truncate(offset); err != nil {
+		return "", err
+	}
+	if offset > 0 {
+		logger.Info("resuming copy", "path", src, "destination", dst, "offset", offset)
+	}
 
-	destination, err := os.Create(dst)
+	source, err := openRetryReader(src, offset)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer destination.Close()
+	defer source.Close()
 
 	buf := make([]byte, BUFFERSIZE)
 	for {
 		n, err := source.Read(buf)
 		if err != nil && err != io.EOF {
-			return err
+			return "", err
 		}
 		if n == 0 {
 			break
 		}
-		if _, err := destination.Write(buf[:n]); err != nil {
-			return err
+		if err := writeAt(destination, buf[:n], offset); err != nil {
+			return "", err
 		}
+		h.Write(buf[:n])
+		offset += int64(n)
+	}
+
+	if err := destination.Sync(); err != nil {
+		return "", err
+	}
+	if err := destination.Close(); err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		os.Remove(partial)
+		return "", ErrFileExists
 	}
+	if err := os.Rename(partial, dst); err != nil {
+		return "", err
+	}
+
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	logger.Info("copied file", "path", src, "destination", dst, "hash", hash)
 
-	logger.Info(fmt.Sprintf("Copied file successfully: %s, %s", src, dst))
+	return hash, nil
+
+}
+
+// destinationLock is the lock of a destination, held by the copies waiting
+// for it.
+type destinationLock struct {
+	sync.Mutex
+	holders int
+}
 
-	return nil
+var (
+	destinationsMu sync.Mutex
+	// destinations are the locks of the destinations being copied to.
+	destinations = map[string]*destinationLock{}
+)
+
+// lockDestination locks the destination dst until the returned func is
+// called. The lock is forgotten once no copy holds it, so long runs don't
+// keep a lock for every file copied.
+func lockDestination(dst string) func() {
+	destinationsMu.Lock()
+	l, ok := destinations[dst]
+	if !ok {
+		l = &destinationLock{}
+		destinations[dst] = l
+	}
+	l.holders++
+	destinationsMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		destinationsMu.Lock()
+		defer destinationsMu.Unlock()
+		if l.holders--; l.holders == 0 {
+			delete(destinations, dst)
+		}
+	}
+}
+
+// partials are the partial files of the copies in progress.
+var partials sync.Map
+
+// RemovePartials removes the partial files of the copies in progress, for a
+// process exiting before they finish, and returns their paths.
+func RemovePartials() []string {
+	removed := []string{}
+	partials.Range(func(k, v interface{}) bool {
+		if err := os.Remove(k.(string)); err == nil {
+			removed = append(removed, k.(string))
+		}
+		return true
+	})
+	return removed
+}
+
+// partialPath returns the path Copy writes dst to until it is complete.
+func partialPath(dst string) string {
+	return path.Join(path.Dir(dst), "."+path.Base(dst)+".partial")
+}
+
+// verifiedOffset returns the number of bytes at the start of partial which
+// match src, writing them to w.
+func verifiedOffset(src string, partial *os.File, BUFFERSIZE int64, w io.Writer) (int64, error) {
+	info, err := partial.Stat()
+	if err != nil || info.Size() == 0 {
+		return 0, err
+	}
+
+	source, err := openRetryReader(src, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	var offset int64
+	a := make([]byte, BUFFERSIZE)
+	b := make([]byte, BUFFERSIZE)
+	for offset < info.Size() {
+		n, err := io.ReadFull(source, a)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		m, err := partial.ReadAt(b[:n], offset)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := 0; i < m; i++ {
+			if a[i] != b[i] {
+				w.Write(a[:i])
+				return offset + int64(i), nil
+			}
+		}
+		w.Write(a[:m])
+		offset += int64(m)
+		if m < len(a) {
+			break
+		}
+	}
+	return offset, nil
+}
+
+// Move renames src to dst, creating the directory of dst if needed. dst is
+// never overwritten, ErrFileExists is returned instead.
+func Move(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return ErrFileExists
+	}
+
+	if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
 
+	return os.Rename(src, dst)
 }
 
 // GetContentType ...
@@ -169,9 +333,10 @@ func GetFileType(p string) (string, string, error) {
 	return kind.Extension, kind.MIME.Value, nil
 }
 
-// GetFileHash returns a string hash of a file.
+// GetFileHash returns a string hash of a file. Transient errors reading it
+// are retried (see SetRetryPolicy).
 func GetFileHash(p string) (string, error) {
-	fh, err := os.Open(p)
+	fh, err := openRetryReader(p, 0)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,118 @@
+package fileutil
+
+import (
+	"encoding/binary"
+	"io"
+	"pt/internal/bmff"
+)
+
+// QuickTime metadata keys returned by GetVideoMetadata.
+const (
+	// QuickTimeCreationDate is the local capture time including its UTC
+	// offset (eg; 2022-01-30T16:00:01+1100).
+	QuickTimeCreationDate = "com.apple.quicktime.creationdate"
+	// QuickTimeLocation is the capture location as an ISO 6709 string.
+	QuickTimeLocation = "com.apple.quicktime.location.ISO6709"
+	// QuickTimeMake is the make of the device that recorded the video.
+	QuickTimeMake = "com.apple.quicktime.make"
+	// QuickTimeModel is the model of the device that recorded the video.
+	QuickTimeModel = "com.apple.quicktime.model"
+)
+
+// GetVideoMetadata returns the string values of the moov/meta keys and ilst
+// boxes of a QuickTime or MP4 file of size bytes read from r. The location
+// from the moov/udta/©xyz box written by Android phones is returned as
+// QuickTimeLocation if the meta box has no location.
+func GetVideoMetadata(r io.ReaderAt, size int64) (map[string]string, error) {
+	m := map[string]string{}
+
+	moov, err := bmff.Find(r, 0, size, "moov")
+	if err != nil {
+		return m, err
+	}
+
+	if meta, err := bmff.Find(r, moov.DataOffset, moov.End(), "meta"); err == nil {
+		if err := readMetaKeys(r, meta, m); err != nil {
+			return m, err
+		}
+	}
+
+	if _, ok := m[QuickTimeLocation]; !ok {
+		if xyz, err := bmff.Find(r, moov.DataOffset, moov.End(), "udta", "\xa9xyz"); err == nil && xyz.DataSize() > 4 {
+			buf := make([]byte, xyz.DataSize())
+			if _, err := r.ReadAt(buf, xyz.DataOffset); err == nil {
+				// A 2 byte string length and 2 byte language code precede
+				// the location.
+				n := int(binary.BigEndian.Uint16(buf[0:2]))
+				if 4+n <= len(buf) {
+					m[QuickTimeLocation] = string(buf[4 : 4+n])
+				}
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// readMetaKeys reads the keys and ilst boxes of the meta box into m. Items in
+// the ilst box have a box type of their 1-based index into the keys box.
+func readMetaKeys(r io.ReaderAt, meta bmff.Box, m map[string]string) error {
+	start := bmff.ChildrenOffset(r, meta)
+
+	keysBox, err := bmff.Find(r, start, meta.End(), "keys")
+	if err != nil {
+		return nil
+	}
+	ilst, err := bmff.Find(r, start, meta.End(), "ilst")
+	if err != nil {
+		return nil
+	}
+
+	buf := make([]byte, keysBox.DataSize())
+	if _, err := r.ReadAt(buf, keysBox.DataOffset); err != nil {
+		return err
+	}
+
+	// The keys box has a 4 byte version and flags and a 4 byte entry count
+	// followed by entries of size, namespace and name.
+	keys := []string{}
+	for offset := 8; offset+8 <= len(buf); {
+		size := int(binary.BigEndian.Uint32(buf[offset : offset+4]))
+		if size < 8 || offset+size > len(buf) {
+			break
+		}
+		keys = append(keys, string(buf[offset+8:offset+size]))
+		offset += size
+	}
+
+	items, err := bmff.ReadBoxes(r, ilst.DataOffset, ilst.End())
+	if err != nil && len(items) == 0 {
+		return err
+	}
+
+	for _, item := range items {
+		index := int(binary.BigEndian.Uint32([]byte(item.Type)))
+		if index < 1 || index > len(keys) {
+			continue
+		}
+
+		data, err := bmff.Find(r, item.DataOffset, item.End(), "data")
+		if err != nil || data.DataSize() < 8 {
+			continue
+		}
+
+		value := make([]byte, data.DataSize())
+		if _, err := r.ReadAt(value, data.DataOffset); err != nil {
+			return err
+		}
+
+		// The data box starts with a 4 byte type indicator, where 1 is
+		// UTF-8, and a 4 byte locale.
+		if binary.BigEndian.Uint32(value[0:4]) != 1 {
+			continue
+		}
+		m[keys[index-1]] = string(value[8:])
+	}
+
+	return nil
+}
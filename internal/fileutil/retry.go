@@ -0,0 +1,235 @@
+package fileutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"pt/internal/logwrap"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy is how reads and writes failing with transient errors, such as
+// those of card readers and network shares, are retried.
+type RetryPolicy struct {
+	// Attempts is the number of times an operation is tried, values below
+	// one are treated as one.
+	Attempts int
+
+	// Backoff is the wait before the first retry, doubled for each retry
+	// after it up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy tries operations three times, waiting a second then two
+// seconds between them.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second}
+
+var (
+	retryMu     sync.Mutex
+	retryPolicy = DefaultRetryPolicy
+)
+
+// SetRetryPolicy sets the policy used by Copy and GetFileHash.
+func SetRetryPolicy(p RetryPolicy) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	retryPolicy = p
+}
+
+func getRetryPolicy() RetryPolicy {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	return retryPolicy
+}
+
+// wait returns the backoff before retry (counted from 1).
+func (p RetryPolicy) wait(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// IsTransient returns true if err is an I/O error which may not happen
+// again, such as EIO from a card reader.
+func IsTransient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrBadSource is matched by the errors of sources that failed after every
+// retry. Later reads of a bad source fail straight away.
+var ErrBadSource = errors.New("bad source")
+
+// BadSourceError is the error of a read that failed after every retry.
+type BadSourceError struct {
+	Path     string
+	Attempts int
+	Err      error
+}
+
+func (e *BadSourceError) Error() string {
+	return fmt.Sprintf("%s: bad source after %d attempts: %v", e.Path, e.Attempts, e.Err)
+}
+
+func (e *BadSourceError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true for ErrBadSource.
+func (e *BadSourceError) Is(target error) bool {
+	return target == ErrBadSource
+}
+
+var badSources sync.Map
+
+// BadSources returns the paths of the sources that failed after every retry.
+func BadSources() []string {
+	paths := []string{}
+	badSources.Range(func(k, v interface{}) bool {
+		paths = append(paths, k.(string))
+		return true
+	})
+	return paths
+}
+
+// ResetBadSources forgets the sources that failed after every retry, so
+// they are read again, such as when a card is mounted again.
+func ResetBadSources() {
+	badSources.Range(func(k, v interface{}) bool {
+		badSources.Delete(k)
+		return true
+	})
+}
+
+// retryReader reads the file at path, reopening it and carrying on from the
+// last byte read after transient errors.
+type retryReader struct {
+	path   string
+	policy RetryPolicy
+	fh     io.ReadSeekCloser
+	offset int64
+	retry  int
+}
+
+// openRetryReader opens the file at path to be read from offset.
+func openRetryReader(path string, offset int64) (*retryReader, error) {
+	if err, ok := badSources.Load(path); ok {
+		return nil, err.(error)
+	}
+	r := &retryReader{path: path, policy: getRetryPolicy(), offset: offset}
+	if err := r.reopen(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reopen opens the file at the offset read up to, retrying transient errors.
+func (r *retryReader) reopen() error {
+	for {
+		err := r.open()
+		if err == nil || !IsTransient(err) {
+			return err
+		}
+		if err := r.backoff(err); err != nil {
+			return err
+		}
+	}
+}
+
+// openSource and sleep are replaced by tests.
+var (
+	openSource = func(p string) (io.ReadSeekCloser, error) { return os.Open(p) }
+	sleep      = time.Sleep
+)
+
+func (r *retryReader) open() error {
+	fh, err := openSource(r.path)
+	if err != nil {
+		return err
+	}
+	if _, err := fh.Seek(r.offset, io.SeekStart); err != nil {
+		fh.Close()
+		return err
+	}
+	r.fh = fh
+	return nil
+}
+
+// backoff closes the file and waits before the next retry after err. Once
+// the retries of the policy run out the source is marked as bad and its
+// error returned.
+func (r *retryReader) backoff(err error) error {
+	if r.fh != nil {
+		r.fh.Close()
+		r.fh = nil
+	}
+	r.retry++
+	if r.retry >= r.policy.Attempts {
+		bad := &BadSourceError{Path: r.path, Attempts: r.retry, Err: err}
+		badSources.Store(r.path, bad)
+		logwrap.Get("pt").Error("giving up reading file", "path", r.path, "attempts", r.retry, "error", err)
+		return bad
+	}
+	logwrap.Get("pt").Warning("retrying read", "path", r.path, "retry", r.retry, "error", err)
+	sleep(r.policy.wait(r.retry))
+	return nil
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	for {
+		if r.fh == nil {
+			if err := r.reopen(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.fh.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			// Retries are counted from the last read that made progress.
+			r.retry = 0
+		}
+		if err == nil || err == io.EOF || !IsTransient(err) {
+			return n, err
+		}
+		// The bytes read before the error are returned, the error is
+		// hit again by the next read.
+		if n > 0 {
+			return n, nil
+		}
+		if err := r.backoff(err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *retryReader) Close() error {
+	if r.fh == nil {
+		return nil
+	}
+	return r.fh.Close()
+}
+
+// writeAt writes b to fh at offset, retrying transient errors.
+func writeAt(fh *os.File, b []byte, offset int64) error {
+	policy := getRetryPolicy()
+	for retry := 1; ; retry++ {
+		_, err := fh.WriteAt(b, offset)
+		if err == nil || !IsTransient(err) || retry >= policy.Attempts {
+			return err
+		}
+		sleep(policy.wait(retry))
+	}
+}
@@ -0,0 +1,185 @@
+package fileutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pt/internal/logwrap"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyWait(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.wait(tt.retry), "retry %d", tt.retry)
+	}
+}
+
+// flakySource is a source whose reads fail with EIO once offset reaches
+// failAt, failures times in all.
+type flakySource struct {
+	*bytes.Reader
+	failAt   int64
+	failures *int
+}
+
+func (s flakySource) Read(p []byte) (int, error) {
+	offset, _ := s.Seek(0, io.SeekCurrent)
+	if *s.failures > 0 && offset >= s.failAt {
+		*s.failures--
+		return 0, &os.PathError{Op: "read", Path: "flaky", Err: syscall.EIO}
+	}
+	if offset < s.failAt && offset+int64(len(p)) > s.failAt {
+		p = p[:s.failAt-offset]
+	}
+	return s.Reader.Read(p)
+}
+
+func (s flakySource) Close() error {
+	return nil
+}
+
+// withFlakySource makes reads of files fail with EIO at failAt, failures
+// times, for the rest of the test.
+func withFlakySource(t *testing.T, failAt int64, failures int) {
+	open, wait := openSource, sleep
+	t.Cleanup(func() {
+		openSource, sleep = open, wait
+		ResetBadSources()
+	})
+	sleep = func(time.Duration) {}
+	openSource = func(p string) (io.ReadSeekCloser, error) {
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		return flakySource{Reader: bytes.NewReader(buf), failAt: failAt, failures: &failures}, nil
+	}
+	SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+	t.Cleanup(func() { SetRetryPolicy(DefaultRetryPolicy) })
+}
+
+func TestCopyRetry(t *testing.T) {
+	logwrap.New("pt", logwrap.Output{W: io.Discard})
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	tests := []struct {
+		name     string
+		failures int
+		partial  []byte
+		wantErr  bool
+	}{
+		{name: "no errors"},
+		{name: "transient", failures: 2},
+		{name: "bad source", failures: 3, wantErr: true},
+		{name: "resume", partial: content[:300]},
+		{name: "resume mismatch", partial: append(append([]byte{}, content[:200]...), []byte("garbage")...)},
+		{name: "resume longer", partial: append(append([]byte{}, content...), []byte("garbage")...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFlakySource(t, 512, tt.failures)
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.jpg")
+			dst := filepath.Join(dir, "dst", "dst.jpg")
+			require.NoError(t, os.WriteFile(src, content, 0644))
+			if tt.partial != nil {
+				require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0755))
+				require.NoError(t, os.WriteFile(partialPath(dst), tt.partial, 0644))
+			}
+
+			hash, err := CopyAndHash(src, dst, 64)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrBadSource), "%v", err)
+				assert.True(t, errors.Is(err, syscall.EIO), "%v", err)
+				assert.Equal(t, []string{src}, BadSources())
+				assert.NoFileExists(t, dst)
+
+				// Later reads of a bad source fail straight away.
+				_, err = GetFileHash(src)
+				assert.True(t, errors.Is(err, ErrBadSource), "%v", err)
+
+				ResetBadSources()
+				assert.Empty(t, BadSources())
+				return
+			}
+			require.NoError(t, err)
+			got, err := os.ReadFile(dst)
+			require.NoError(t, err)
+			assert.Equal(t, content, got)
+			assert.NoFileExists(t, partialPath(dst))
+
+			// The copy is hashed as it is written, including a resumed
+			// partial file.
+			want, err := GetFileHash(dst)
+			require.NoError(t, err)
+			assert.Equal(t, want, hash)
+		})
+	}
+}
+
+func TestRemovePartials(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "dst.jpg")
+	require.NoError(t, os.WriteFile(partialPath(dst), []byte("0123"), 0644))
+	partials.Store(partialPath(dst), true)
+	t.Cleanup(func() { partials.Delete(partialPath(dst)) })
+
+	assert.Equal(t, []string{partialPath(dst)}, RemovePartials())
+	assert.NoFileExists(t, partialPath(dst))
+}
+
+func TestCopySameDestination(t *testing.T) {
+	logwrap.New("pt", logwrap.Output{W: io.Discard})
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst", "dst.jpg")
+	srcs := []string{}
+	for i := 0; i < 8; i++ {
+		src := filepath.Join(dir, fmt.Sprintf("src%d.jpg", i))
+		require.NoError(t, os.WriteFile(src, bytes.Repeat([]byte{byte('a' + i)}, 1000000), 0644))
+		srcs = append(srcs, src)
+	}
+
+	// Exactly one copy wins, the others find the destination exists.
+	errs := make(chan error, len(srcs))
+	for _, src := range srcs {
+		go func(src string) {
+			_, err := CopyAndHash(src, dst, 1024)
+			errs <- err
+		}(src)
+	}
+	copied := 0
+	for range srcs {
+		if err := <-errs; err == nil {
+			copied++
+		} else {
+			assert.Equal(t, ErrFileExists, err)
+		}
+	}
+	assert.Equal(t, 1, copied)
+	b, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat(b[:1], 1000000), b)
+
+	// The lock of dst is forgotten once every copy is done.
+	destinationsMu.Lock()
+	defer destinationsMu.Unlock()
+	assert.Empty(t, destinations)
+}
@@ -0,0 +1,20 @@
+package fileutil
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// sidecarExtensions are the extensions of files which belong to the media
+// file with the same base name: XMP metadata, Apple photo edits and Canon
+// video thumbnails.
+var sidecarExtensions = map[string]bool{
+	".xmp": true,
+	".aae": true,
+	".thm": true,
+}
+
+// IsSidecar returns true if p is a sidecar file.
+func IsSidecar(p string) bool {
+	return sidecarExtensions[strings.ToLower(filepath.Ext(p))]
+}
@@ -0,0 +1,251 @@
+// Package filter selects the files commands walk by path, size, media type
+// and capture time, and reads the .ptignore files found in source trees.
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"pt/internal/file"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IgnoreFileName is the name of the files listing patterns to skip within
+// their directory.
+const IgnoreFileName = ".ptignore"
+
+// Pattern matches file paths relative to the walked directory. Glob patterns
+// (see path.Match) without a slash match the file name, other glob patterns
+// match the whole relative path. Patterns starting with re: are regular
+// expressions matched anywhere in the relative path.
+type Pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// ParsePattern parses s as a Pattern.
+func ParsePattern(s string) (Pattern, error) {
+	if strings.HasPrefix(s, "re:") {
+		re, err := regexp.Compile(strings.TrimPrefix(s, "re:"))
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+		}
+		return Pattern{re: re}, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return Pattern{}, fmt.Errorf("invalid pattern %q: %w", s, err)
+	}
+	return Pattern{glob: s}, nil
+}
+
+// Match returns true if p matches rel, a slash separated path relative to
+// the walked directory.
+func (p Pattern) Match(rel string) bool {
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	if !strings.Contains(p.glob, "/") {
+		rel = path.Base(rel)
+	}
+	ok, _ := path.Match(p.glob, rel)
+	return ok
+}
+
+// Filter selects files. The zero Filter selects every file.
+type Filter struct {
+	// Include, if not empty, selects only files matching one of its
+	// patterns.
+	Include []Pattern
+
+	// Exclude skips files and directories matching one of its patterns.
+	Exclude []Pattern
+
+	// MinSize and MaxSize are the inclusive bounds of the size of files in
+	// bytes. A zero bound is not checked.
+	MinSize int64
+	MaxSize int64
+
+	// MediaTypes, if not empty, selects only files of these types.
+	MediaTypes []file.MediaType
+
+	// Since and Until are the bounds of the capture time of files, Since is
+	// inclusive and Until exclusive. A zero bound is not checked.
+	Since time.Time
+	Until time.Time
+}
+
+// MatchDir returns false if the directory rel should be skipped.
+func (f Filter) MatchDir(rel string) bool {
+	return !matchAny(f.Exclude, filepath.ToSlash(rel))
+}
+
+// MatchFile returns true if the file rel with info passes the path and size
+// checks of f.
+func (f Filter) MatchFile(rel string, info os.FileInfo) bool {
+	rel = filepath.ToSlash(rel)
+	if len(f.Include) > 0 && !matchAny(f.Include, rel) {
+		return false
+	}
+	if matchAny(f.Exclude, rel) {
+		return false
+	}
+	if f.MinSize > 0 && info.Size() < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && info.Size() > f.MaxSize {
+		return false
+	}
+	return true
+}
+
+// MatchMediaType returns true if files of media type t are selected.
+func (f Filter) MatchMediaType(t file.MediaType) bool {
+	if len(f.MediaTypes) == 0 {
+		return true
+	}
+	for _, i := range f.MediaTypes {
+		if i == t {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTimeRange returns true if f selects files by capture time.
+func (f Filter) HasTimeRange() bool {
+	return !f.Since.IsZero() || !f.Until.IsZero()
+}
+
+// MatchTime returns true if files captured at t are selected.
+func (f Filter) MatchTime(t time.Time) bool {
+	if !f.Since.IsZero() && t.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !t.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []Pattern, rel string) bool {
+	for _, p := range patterns {
+		if p.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMediaType parses s as one of photo, video or raw.
+func ParseMediaType(s string) (file.MediaType, error) {
+	switch t := file.MediaType(strings.ToLower(s)); t {
+	case file.MediaTypePhoto, file.MediaTypeVideo, file.MediaTypeRaw:
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid media type %q, expected photo, video or raw", s)
+}
+
+var sizeRe = regexp.MustCompile(`^(?i)(\d+(?:\.\d+)?)\s*([kmgt]?)i?b?$`)
+
+// ParseSize parses a size in bytes with an optional K, M, G or T suffix
+// (eg; 500K, 1.5G). Suffixes are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	m := sizeRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	for i := strings.Index("kmgt", strings.ToLower(m[2])); m[2] != "" && i >= 0; i-- {
+		n *= 1024
+	}
+	return int64(n), nil
+}
+
+// ParseTime parses s as a date (2006-01-02) or date and time
+// (2006-01-02T15:04:05) in the local timezone, or as a duration before now
+// such as 14d or 36h (see file.ParseTimeShift).
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if !strings.HasPrefix(s, "-") && !strings.HasPrefix(s, "+") {
+		if d, err := file.ParseTimeShift(s); err == nil {
+			return now.Add(-d), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a date (2006-01-02) or a duration (eg; 14d)", s)
+}
+
+// Ignore is the patterns of a .ptignore file. Each line of the file is a
+// glob pattern, lines starting with # are comments. Patterns without a slash
+// match file and directory names anywhere below the directory of the
+// .ptignore file, other patterns match paths relative to it. Patterns ending
+// with a slash only match directories.
+type Ignore struct {
+	dir      string
+	patterns []string
+}
+
+// ReadIgnore reads the .ptignore file of dir. An empty Ignore is returned if
+// dir has no .ptignore file.
+func ReadIgnore(dir string) (Ignore, error) {
+	ignore := Ignore{dir: dir}
+	fh, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return ignore, nil
+	} else if err != nil {
+		return ignore, err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(strings.TrimSuffix(line, "/"), ""); err != nil {
+			return ignore, fmt.Errorf("%s: invalid pattern %q: %w", filepath.Join(dir, IgnoreFileName), line, err)
+		}
+		ignore.patterns = append(ignore.patterns, line)
+	}
+	return ignore, scanner.Err()
+}
+
+// Match returns true if p, a path below the directory of i, is ignored.
+func (i Ignore) Match(p string, isDir bool) bool {
+	rel, err := filepath.Rel(i.dir, p)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range i.patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name); ok {
+			return true
+		}
+	}
+	return false
+}
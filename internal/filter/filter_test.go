@@ -0,0 +1,90 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		expect  bool
+	}{
+		{"*.JPG", "DCIM/100CANON/IMG_0001.JPG", true},
+		{"*.JPG", "DCIM/100CANON/IMG_0001.CR2", false},
+		{"DCIM/*/IMG_*", "DCIM/100CANON/IMG_0001.JPG", true},
+		{"DCIM/*", "DCIM/100CANON/IMG_0001.JPG", false},
+		{`re:^DCIM/\d+CANON/`, "DCIM/100CANON/IMG_0001.JPG", true},
+		{`re:(?i)\.mp4$`, "DCIM/100CANON/MVI_0001.MP4", true},
+		{`re:^Screenshots/`, "DCIM/100CANON/IMG_0001.JPG", false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			p, err := ParsePattern(test.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, p.Match(test.rel))
+		})
+	}
+
+	_, err := ParsePattern("[")
+	assert.Error(t, err)
+	_, err = ParsePattern("re:(")
+	assert.Error(t, err)
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s      string
+		expect int64
+	}{
+		{"100", 100},
+		{"500K", 500 * 1024},
+		{"2MB", 2 * 1024 * 1024},
+		{"1.5g", 3 * 512 * 1024 * 1024},
+		{"1MiB", 1024 * 1024},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			n, err := ParseSize(test.s)
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, n)
+		})
+	}
+
+	_, err := ParseSize("big")
+	assert.Error(t, err)
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.Local)
+
+	since, err := ParseTime("14d", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 1, 12, 0, 0, 0, time.Local), since)
+
+	since, err = ParseTime("2022-06-01", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 1, 0, 0, 0, 0, time.Local), since)
+
+	since, err = ParseTime("", now)
+	require.NoError(t, err)
+	assert.True(t, since.IsZero())
+
+	_, err = ParseTime("-14d", now)
+	assert.Error(t, err)
+}
+
+func TestMatchTime(t *testing.T) {
+	f := Filter{
+		Since: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC),
+	}
+	assert.False(t, f.MatchTime(time.Date(2022, 5, 31, 23, 59, 59, 0, time.UTC)))
+	assert.True(t, f.MatchTime(time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, f.MatchTime(time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, Filter{}.MatchTime(time.Time{}))
+}
@@ -0,0 +1,223 @@
+// Package geo provides offline lookups of timezones from GPS coordinates
+// using an embedded list of cities.
+package geo
+
+import (
+	"bufio"
+	_ "embed" //nolint
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "time/tzdata" //nolint
+)
+
+// MaxTimezoneDistance is the distance in km from the nearest known city after
+// which a timezone is derived from longitude alone.
+const MaxTimezoneDistance = 500
+
+// MaxPlaceDistance is the distance in km from the nearest known city after
+// which a point is not considered to be part of it.
+const MaxPlaceDistance = 100
+
+const earthRadius = 6371.0
+
+//go:embed cities.tsv
+var citiesTSV string
+
+var (
+	defaultOnce      sync.Once
+	defaultGazetteer *Gazetteer
+)
+
+// Point is a GPS coordinate in decimal degrees.
+type Point struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// String returns the point formatted as "latitude,longitude".
+func (p Point) String() string {
+	return fmt.Sprintf("%.6f,%.6f", p.Latitude, p.Longitude)
+}
+
+// City is a populated place and the IANA timezone it observes.
+type City struct {
+	Name     string
+	Country  string
+	Region   string
+	Timezone string
+	Point
+}
+
+// Gazetteer is a list of cities that can be searched by location.
+type Gazetteer struct {
+	cities []City
+}
+
+// NewGazetteer returns a Gazetteer of cities.
+func NewGazetteer(cities []City) *Gazetteer {
+	return &Gazetteer{cities: cities}
+}
+
+// Default returns the Gazetteer of cities embedded in pt.
+func Default() *Gazetteer {
+	defaultOnce.Do(func() {
+		cities, err := ParseCities(strings.NewReader(citiesTSV))
+		if err != nil {
+			panic(fmt.Sprintf("geo: invalid embedded cities: %v", err))
+		}
+		defaultGazetteer = NewGazetteer(cities)
+	})
+	return defaultGazetteer
+}
+
+// SetDefault replaces the Gazetteer returned by Default, for example with one
+// loaded by LoadGeoNames.
+func SetDefault(g *Gazetteer) {
+	defaultOnce.Do(func() {})
+	defaultGazetteer = g
+}
+
+// ParseCities parses tab separated lines of name, country code, region,
+// latitude, longitude and timezone.
+func ParseCities(r io.Reader) ([]City, error) {
+	cities := []City{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("line %d: expected 6 fields, got %d", line, len(fields))
+		}
+		lat, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		lon, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		cities = append(cities, City{
+			Name:     fields[0],
+			Country:  fields[1],
+			Region:   fields[2],
+			Timezone: fields[5],
+			Point:    Point{Latitude: lat, Longitude: lon},
+		})
+	}
+	return cities, scanner.Err()
+}
+
+// Nearest returns the city nearest to p and its distance in km. false is
+// returned if the Gazetteer has no cities.
+func (g *Gazetteer) Nearest(p Point) (City, float64, bool) {
+	var nearest City
+	distance := math.Inf(1)
+	for _, c := range g.cities {
+		if d := Distance(p, c.Point); d < distance {
+			nearest, distance = c, d
+		}
+	}
+	return nearest, distance, len(g.cities) > 0
+}
+
+// Place returns the city p is part of, being the nearest city within
+// MaxPlaceDistance.
+func (g *Gazetteer) Place(p Point) (City, bool) {
+	c, d, ok := g.Nearest(p)
+	if !ok || d > MaxPlaceDistance {
+		return City{}, false
+	}
+	return c, true
+}
+
+// Timezone returns the timezone observed at p. The timezone of the nearest
+// city is used if it is within MaxTimezoneDistance, otherwise a fixed zone
+// is derived from the longitude of p.
+func (g *Gazetteer) Timezone(p Point) *time.Location {
+	loc, _ := g.LookupTimezone(p)
+	return loc
+}
+
+// LookupTimezone is like Timezone but also returns false if the timezone
+// was derived from the longitude of p. The derived zone ignores timezone
+// borders and daylight saving time, so it can be hours out over land far
+// from the cities of the Gazetteer (the embedded list only has a few hundred
+// of the largest cities).
+func (g *Gazetteer) LookupTimezone(p Point) (*time.Location, bool) {
+	if c, d, ok := g.Nearest(p); ok && d <= MaxTimezoneDistance {
+		if loc, err := time.LoadLocation(c.Timezone); err == nil {
+			return loc, true
+		}
+	}
+	return NauticalTimezone(p.Longitude), false
+}
+
+// NauticalTimezone returns the fixed zone whose meridian is nearest to
+// longitude.
+func NauticalTimezone(longitude float64) *time.Location {
+	hours := int(math.Round(longitude / 15))
+	return time.FixedZone(fmt.Sprintf("UTC%+d", hours), hours*60*60)
+}
+
+// Distance returns the great circle distance in km between a and b.
+func Distance(a, b Point) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+var iso6709Re = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+
+// ParseISO6709 parses the latitude and longitude of an ISO 6709 location
+// string in decimal degrees as written by phones into QuickTime metadata
+// (eg; "+37.3318-122.0312+010.000/").
+func ParseISO6709(s string) (Point, error) {
+	m := iso6709Re.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Point{}, fmt.Errorf("invalid ISO 6709 location: %q", s)
+	}
+	lat, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return Point{}, err
+	}
+	lon, err := strconv.ParseFloat(m[2], 64)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{Latitude: lat, Longitude: lon}, nil
+}
+
+// ParsePoint parses a point formatted as "latitude,longitude" in decimal
+// degrees.
+func ParsePoint(s string) (Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Point{}, fmt.Errorf("invalid point %q: expected latitude,longitude", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return Point{}, fmt.Errorf("invalid latitude in %q", s)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return Point{}, fmt.Errorf("invalid longitude in %q", s)
+	}
+	return Point{Latitude: lat, Longitude: lon}, nil
+}
@@ -0,0 +1,56 @@
+package geo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedTimezones(t *testing.T) {
+	for _, c := range Default().cities {
+		_, err := time.LoadLocation(c.Timezone)
+		assert.NoError(t, err, c.Name)
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	tests := []struct {
+		point  Point
+		expect string
+	}{
+		{Point{-37.80, 144.95}, "Australia/Melbourne"},
+		{Point{-31.95, 115.86}, "Australia/Perth"},
+		{Point{48.86, 2.35}, "Europe/Paris"},
+		{Point{-40.0, -140.0}, "UTC-9"},
+	}
+	for _, test := range tests {
+		t.Run(test.expect, func(t *testing.T) {
+			assert.Equal(t, test.expect, Default().Timezone(test.point).String())
+			_, found := Default().LookupTimezone(test.point)
+			assert.Equal(t, !strings.HasPrefix(test.expect, "UTC"), found)
+		})
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	p, err := ParseISO6709("+37.3318-122.0312+010.000/")
+	require.NoError(t, err)
+	assert.Equal(t, Point{37.3318, -122.0312}, p)
+
+	_, err = ParseISO6709("nowhere")
+	assert.Error(t, err)
+}
+
+func TestPlace(t *testing.T) {
+	c, ok := Default().Place(Point{-37.80, 144.95})
+	require.True(t, ok)
+	assert.Equal(t, "Melbourne", c.Name)
+	assert.Equal(t, "AU", c.Country)
+	assert.Equal(t, "Victoria", c.Region)
+
+	_, ok = Default().Place(Point{-40.0, -140.0})
+	assert.False(t, ok)
+}
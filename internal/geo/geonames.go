@@ -0,0 +1,100 @@
+package geo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseGeoNames parses a GeoNames cities file (eg; cities15000.txt from
+// https://download.geonames.org/export/dump/). Regions are named using
+// admin1, a map of GeoNames admin1 codes (eg; AU.07) to names, and are left
+// as the code if they are not found in it.
+func ParseGeoNames(r io.Reader, admin1 map[string]string) ([]City, error) {
+	cities := []City{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" || strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 18 {
+			return nil, fmt.Errorf("line %d: expected 19 fields, got %d", line, len(fields))
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		lon, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		country, region := fields[8], fields[10]
+		if name, ok := admin1[country+"."+region]; ok {
+			region = name
+		}
+		cities = append(cities, City{
+			Name:     fields[1],
+			Country:  country,
+			Region:   region,
+			Timezone: fields[17],
+			Point:    Point{Latitude: lat, Longitude: lon},
+		})
+	}
+	return cities, scanner.Err()
+}
+
+// ParseGeoNamesAdmin1 parses a GeoNames admin1 codes file
+// (admin1CodesASCII.txt) into a map of codes to names.
+func ParseGeoNamesAdmin1(r io.Reader) (map[string]string, error) {
+	admin1 := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected 4 fields, got %d", line, len(fields))
+		}
+		admin1[fields[0]] = fields[1]
+	}
+	return admin1, scanner.Err()
+}
+
+// LoadGeoNames returns a Gazetteer of the GeoNames cities file citiesPath.
+// admin1Path is an optional GeoNames admin1 codes file used to name regions.
+func LoadGeoNames(citiesPath, admin1Path string) (*Gazetteer, error) {
+	admin1 := map[string]string{}
+	if admin1Path != "" {
+		fh, err := os.Open(admin1Path)
+		if err != nil {
+			return nil, err
+		}
+		admin1, err = ParseGeoNamesAdmin1(fh)
+		fh.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", admin1Path, err)
+		}
+	}
+
+	fh, err := os.Open(citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	cities, err := ParseGeoNames(fh, admin1)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", citiesPath, err)
+	}
+	return NewGazetteer(cities), nil
+}
@@ -0,0 +1,37 @@
+package geo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGeoNames = "2158177\tMelbourne\tMelbourne\tMelburn\t-37.814\t144.96332\tP\tPPLA\tAU\t\t07\t24600\t\t\t4917750\t\t25\tAustralia/Melbourne\t2023-02-09\n" +
+	"2165087\tGold Coast\tGold Coast\t\t-28.00029\t153.43088\tP\tPPLA2\tAU\t\t04\t\t\t\t624918\t\t2\tAustralia/Brisbane\t2019-07-18\n"
+
+func TestParseGeoNames(t *testing.T) {
+	admin1, err := ParseGeoNamesAdmin1(strings.NewReader("AU.07\tVictoria\tVictoria\t2145234\n"))
+	require.NoError(t, err)
+
+	cities, err := ParseGeoNames(strings.NewReader(testGeoNames), admin1)
+	require.NoError(t, err)
+	require.Len(t, cities, 2)
+	assert.Equal(t, City{
+		Name:     "Melbourne",
+		Country:  "AU",
+		Region:   "Victoria",
+		Timezone: "Australia/Melbourne",
+		Point:    Point{-37.814, 144.96332},
+	}, cities[0])
+	assert.Equal(t, "04", cities[1].Region)
+
+	g := NewGazetteer(cities)
+	c, ok := g.Place(Point{-28.1, 153.4})
+	require.True(t, ok)
+	assert.Equal(t, "Gold Coast", c.Name)
+
+	_, err = ParseGeoNames(strings.NewReader("Melbourne\tAU\n"), nil)
+	assert.Error(t, err)
+}
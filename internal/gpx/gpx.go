@@ -0,0 +1,143 @@
+// Package gpx reads GPS tracks from GPX files and locates points in time
+// along them.
+package gpx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"pt/internal/geo"
+	"sort"
+	"time"
+)
+
+// TrackPoint is a location recorded at a point in time.
+type TrackPoint struct {
+	Time time.Time
+	geo.Point
+}
+
+// Track is a list of track points ordered by time.
+type Track []TrackPoint
+
+// gpx is the subset of a GPX document holding track and route points.
+type gpx struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []point `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []point `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type point struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+	Time      string  `xml:"time"`
+}
+
+// Parse reads the track and route points of the GPX document in r. Points
+// without a time are skipped.
+func Parse(r io.Reader) (Track, error) {
+	doc := gpx{}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid GPX: %w", err)
+	}
+
+	points := []point{}
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			points = append(points, seg.Points...)
+		}
+	}
+	for _, rte := range doc.Routes {
+		points = append(points, rte.Points...)
+	}
+
+	track := Track{}
+	for _, p := range points {
+		t, err := time.Parse(time.RFC3339Nano, p.Time)
+		if err != nil {
+			continue
+		}
+		track = append(track, TrackPoint{Time: t, Point: geo.Point{Latitude: p.Latitude, Longitude: p.Longitude}})
+	}
+	track.sort()
+	return track, nil
+}
+
+// ReadFiles reads and merges the tracks of the GPX files paths.
+func ReadFiles(paths ...string) (Track, error) {
+	track := Track{}
+	for _, p := range paths {
+		fh, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		t, err := Parse(fh)
+		fh.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		track = append(track, t...)
+	}
+	track.sort()
+	return track, nil
+}
+
+func (t Track) sort() {
+	sort.SliceStable(t, func(i, j int) bool { return t[i].Time.Before(t[j].Time) })
+}
+
+// Locate returns the location at ts along with the time between ts and the
+// nearest track point. The location is interpolated between the track points
+// either side of ts when both are within maxGap of it, otherwise it is the
+// nearest track point, so a gap in the track isn't filled with a straight
+// line. No location is returned if the nearest track point is more than maxGap
+// away.
+func (t Track) Locate(ts time.Time, maxGap time.Duration) (geo.Point, time.Duration, bool) {
+	if len(t) == 0 {
+		return geo.Point{}, 0, false
+	}
+
+	// i is the first track point at or after ts.
+	i := sort.Search(len(t), func(i int) bool { return !t[i].Time.Before(ts) })
+
+	var p geo.Point
+	var gap time.Duration
+	switch {
+	case i == 0:
+		p, gap = t[0].Point, t[0].Time.Sub(ts)
+	case i == len(t):
+		p, gap = t[i-1].Point, ts.Sub(t[i-1].Time)
+	default:
+		before, after := t[i-1], t[i]
+		toBefore, toAfter := ts.Sub(before.Time), after.Time.Sub(ts)
+		switch {
+		case toBefore <= maxGap && toAfter <= maxGap:
+			p, gap = interpolate(before, after, ts), min(toBefore, toAfter)
+		case toBefore <= toAfter:
+			p, gap = before.Point, toBefore
+		default:
+			p, gap = after.Point, toAfter
+		}
+	}
+
+	return p, gap, gap <= maxGap
+}
+
+// interpolate returns the location at ts on a straight line between a and b.
+func interpolate(a, b TrackPoint, ts time.Time) geo.Point {
+	span := b.Time.Sub(a.Time)
+	if span <= 0 {
+		return a.Point
+	}
+	f := float64(ts.Sub(a.Time)) / float64(span)
+	return geo.Point{
+		Latitude:  a.Latitude + (b.Latitude-a.Latitude)*f,
+		Longitude: a.Longitude + (b.Longitude-a.Longitude)*f,
+	}
+}
@@ -0,0 +1,93 @@
+package gpx
+
+import (
+	"pt/internal/geo"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <trkseg>
+      <trkpt lat="-37.80" lon="144.90"><time>2022-01-01T00:01:00Z</time></trkpt>
+      <trkpt lat="-37.90" lon="145.00"><time>2022-01-01T00:02:00Z</time></trkpt>
+      <trkpt lat="-37.00" lon="145.00"></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="-38.00" lon="145.10"><time>2022-01-01T00:03:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func TestParse(t *testing.T) {
+	track, err := Parse(strings.NewReader(testGPX))
+	require.NoError(t, err)
+	require.Len(t, track, 3)
+	assert.Equal(t, geo.Point{Latitude: -37.80, Longitude: 144.90}, track[0].Point)
+	assert.Equal(t, time.Date(2022, 1, 1, 0, 3, 0, 0, time.UTC), track[2].Time)
+
+	_, err = Parse(strings.NewReader("not xml"))
+	assert.Error(t, err)
+}
+
+func TestLocate(t *testing.T) {
+	track, err := Parse(strings.NewReader(testGPX))
+	require.NoError(t, err)
+
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		ts    time.Time
+		point geo.Point
+		gap   time.Duration
+		ok    bool
+	}{
+		{"exact", base.Add(2 * time.Minute), geo.Point{Latitude: -37.90, Longitude: 145.00}, 0, true},
+		{"interpolated", base.Add(90 * time.Second), geo.Point{Latitude: -37.85, Longitude: 144.95}, 30 * time.Second, true},
+		{"before start", base.Add(30 * time.Second), geo.Point{Latitude: -37.80, Longitude: 144.90}, 30 * time.Second, true},
+		{"after end", base.Add(time.Hour), geo.Point{Latitude: -38.00, Longitude: 145.10}, 57 * time.Minute, false},
+		{"other timezone", base.Add(90 * time.Second).In(time.FixedZone("", 11*3600)), geo.Point{Latitude: -37.85, Longitude: 144.95}, 30 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, gap, ok := track.Locate(tt.ts, 5*time.Minute)
+			assert.InDelta(t, tt.point.Latitude, p.Latitude, 1e-9)
+			assert.InDelta(t, tt.point.Longitude, p.Longitude, 1e-9)
+			assert.Equal(t, tt.gap, gap)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+
+	// Either side of the gap in the track, the nearest point is used rather
+	// than a line across it.
+	gappy := Track{
+		{Point: geo.Point{Latitude: -37.80, Longitude: 144.90}, Time: base},
+		{Point: geo.Point{Latitude: -38.80, Longitude: 145.90}, Time: base.Add(time.Hour)},
+	}
+	for _, tt := range []struct {
+		name  string
+		ts    time.Time
+		point geo.Point
+		gap   time.Duration
+		ok    bool
+	}{
+		{"near start of gap", base.Add(2 * time.Minute), gappy[0].Point, 2 * time.Minute, true},
+		{"near end of gap", base.Add(56 * time.Minute), gappy[1].Point, 4 * time.Minute, true},
+		{"middle of gap", base.Add(30 * time.Minute), gappy[0].Point, 30 * time.Minute, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, gap, ok := gappy.Locate(tt.ts, 5*time.Minute)
+			assert.Equal(t, tt.point, p)
+			assert.Equal(t, tt.gap, gap)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+
+	_, _, ok := Track{}.Locate(base, time.Hour)
+	assert.False(t, ok)
+}
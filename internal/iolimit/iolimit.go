@@ -0,0 +1,136 @@
+// Package iolimit limits the number of files read at once from each storage
+// device, so parallel workers don't make spinning disks and SD cards seek
+// back and forth between files.
+package iolimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Class is the kind of storage device a file is on.
+type Class string
+
+const (
+	ClassUnknown    Class = "unknown"
+	ClassRotational Class = "rotational"
+	ClassRemovable  Class = "removable"
+	ClassSolidState Class = "solid state"
+)
+
+// Readers returns the number of files read at once from a device of class c,
+// or 0 if they aren't limited. Spinning disks and removable media such as SD
+// cards are read one file at a time.
+func (c Class) Readers() int {
+	switch c {
+	case ClassRotational, ClassRemovable:
+		return 1
+	}
+	return 0
+}
+
+// sysfs is the root of the sysfs filesystem, replaced by tests.
+var sysfs = "/sys"
+
+// deviceOf returns the major and minor number of the device the file at p is
+// on. It is replaced by tests.
+var deviceOf = statDevice
+
+// Limiter limits the number of files read at once from each device. A
+// Limiter is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	devices map[[2]uint32]*device
+}
+
+type device struct {
+	class Class
+	sem   chan struct{}
+}
+
+// New returns a Limiter.
+func New() *Limiter {
+	return &Limiter{devices: map[[2]uint32]*device{}}
+}
+
+// Acquire blocks until the file at p may be read, returning a function to
+// call once it has been read. A nil Limiter doesn't limit reads.
+func (l *Limiter) Acquire(ctx context.Context, p string) (func(), error) {
+	release := func() {}
+	if l == nil {
+		return release, nil
+	}
+	d := l.device(p)
+	if d == nil || d.sem == nil {
+		return release, nil
+	}
+	select {
+	case d.sem <- struct{}{}:
+		return func() { <-d.sem }, nil
+	case <-ctx.Done():
+		return release, ctx.Err()
+	}
+}
+
+// Class returns the class of the device the file at p is on.
+func (l *Limiter) Class(p string) Class {
+	if d := l.device(p); d != nil {
+		return d.class
+	}
+	return ClassUnknown
+}
+
+// device returns the device the file at p is on, or nil if it isn't known.
+func (l *Limiter) device(p string) *device {
+	major, minor, ok := deviceOf(p)
+	if !ok {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := [2]uint32{major, minor}
+	d, ok := l.devices[key]
+	if !ok {
+		d = &device{class: classify(sysfs, major, minor)}
+		if n := d.class.Readers(); n > 0 {
+			d.sem = make(chan struct{}, n)
+		}
+		l.devices[key] = d
+	}
+	return d
+}
+
+// classify returns the class of the block device major:minor from the sysfs
+// filesystem at root. Filesystems without a block device, such as network
+// shares, are ClassUnknown.
+func classify(root string, major, minor uint32) Class {
+	dir, err := filepath.EvalSymlinks(filepath.Join(root, "dev", "block", fmt.Sprintf("%d:%d", major, minor)))
+	if err != nil {
+		return ClassUnknown
+	}
+
+	// Partitions are below the directory of their disk, which holds the
+	// settings of its queue.
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err == nil {
+		dir = filepath.Dir(dir)
+	}
+
+	rotational, err := os.ReadFile(filepath.Join(dir, "queue", "rotational"))
+	if err != nil {
+		return ClassUnknown
+	}
+	removable, _ := os.ReadFile(filepath.Join(dir, "removable"))
+
+	switch {
+	case strings.TrimSpace(string(removable)) == "1", strings.HasPrefix(filepath.Base(dir), "mmcblk"):
+		return ClassRemovable
+	case strings.TrimSpace(string(rotational)) == "1":
+		return ClassRotational
+	}
+	return ClassSolidState
+}
@@ -0,0 +1,16 @@
+package iolimit
+
+import "syscall"
+
+// statDevice returns the major and minor number of the device the file at p
+// is on.
+func statDevice(p string) (uint32, uint32, bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(p, &st); err != nil {
+		return 0, 0, false
+	}
+	dev := uint64(st.Dev)
+	major := uint32((dev&0x00000000000fff00)>>8) | uint32((dev&0xfffff00000000000)>>32)
+	minor := uint32(dev&0x00000000000000ff) | uint32((dev&0x00000ffffff00000)>>12)
+	return major, minor, true
+}
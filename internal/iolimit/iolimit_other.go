@@ -0,0 +1,8 @@
+//go:build !linux
+
+package iolimit
+
+// statDevice returns false, the device of a file is only known on Linux.
+func statDevice(p string) (uint32, uint32, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,115 @@
+package iolimit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSysfs writes a sysfs tree with the block devices of tests.
+func fakeSysfs(t *testing.T) string {
+	root := t.TempDir()
+	write := func(p, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, p)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, p), []byte(content), 0644))
+	}
+	link := func(dev, p string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "dev", "block"), 0755))
+		require.NoError(t, os.Symlink(filepath.Join(root, p), filepath.Join(root, "dev", "block", dev)))
+	}
+
+	// A spinning disk with a partition.
+	write("block/sda/queue/rotational", "1\n")
+	write("block/sda/removable", "0\n")
+	write("block/sda/sda1/partition", "1\n")
+	link("8:1", "block/sda/sda1")
+
+	// A card reader.
+	write("block/sdb/queue/rotational", "1\n")
+	write("block/sdb/removable", "1\n")
+	link("8:16", "block/sdb")
+
+	// An SD card in a built in reader.
+	write("block/mmcblk0/queue/rotational", "0\n")
+	write("block/mmcblk0/removable", "0\n")
+	write("block/mmcblk0/mmcblk0p1/partition", "1\n")
+	link("179:1", "block/mmcblk0/mmcblk0p1")
+
+	// An NVMe drive.
+	write("block/nvme0n1/queue/rotational", "0\n")
+	write("block/nvme0n1/removable", "0\n")
+	write("block/nvme0n1/nvme0n1p2/partition", "2\n")
+	link("259:2", "block/nvme0n1/nvme0n1p2")
+
+	return root
+}
+
+func TestClassify(t *testing.T) {
+	root := fakeSysfs(t)
+	tests := []struct {
+		major, minor uint32
+		want         Class
+	}{
+		{8, 1, ClassRotational},
+		{8, 16, ClassRemovable},
+		{179, 1, ClassRemovable},
+		{259, 2, ClassSolidState},
+		{0, 45, ClassUnknown},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, classify(root, tt.major, tt.minor), "%d:%d", tt.major, tt.minor)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	root, of := sysfs, deviceOf
+	t.Cleanup(func() { sysfs, deviceOf = root, of })
+	sysfs = fakeSysfs(t)
+	devices := map[string][2]uint32{
+		"/hdd/a.jpg":  {8, 1},
+		"/hdd/b.jpg":  {8, 1},
+		"/card/c.jpg": {179, 1},
+		"/nvme/d.jpg": {259, 2},
+		"/nvme/e.jpg": {259, 2},
+	}
+	deviceOf = func(p string) (uint32, uint32, bool) {
+		d, ok := devices[p]
+		return d[0], d[1], ok
+	}
+
+	l := New()
+	assert.Equal(t, ClassRotational, l.Class("/hdd/a.jpg"))
+	assert.Equal(t, ClassUnknown, l.Class("/nfs/f.jpg"))
+
+	ctx := context.Background()
+	releaseA, err := l.Acquire(ctx, "/hdd/a.jpg")
+	require.NoError(t, err)
+
+	// Other devices are read while a file on the spinning disk is.
+	for _, p := range []string{"/card/c.jpg", "/nvme/d.jpg", "/nvme/e.jpg", "/nfs/f.jpg"} {
+		_, err := l.Acquire(ctx, p)
+		assert.NoError(t, err, p)
+	}
+
+	// A second file on the spinning disk waits for the first.
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(timeout, "/hdd/b.jpg")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	releaseA()
+	releaseB, err := l.Acquire(ctx, "/hdd/b.jpg")
+	require.NoError(t, err)
+	releaseB()
+
+	// A nil Limiter doesn't limit reads.
+	var none *Limiter
+	release, err := none.Acquire(ctx, "/hdd/a.jpg")
+	require.NoError(t, err)
+	release()
+}
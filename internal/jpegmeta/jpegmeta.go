@@ -0,0 +1,149 @@
+// Package jpegmeta reads and replaces the exif and XMP APP1 segments of JPEG
+// files.
+package jpegmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerAPP0 = 0xe0
+	markerAPP1 = 0xe1
+
+	// maxSegmentSize is the largest payload of a segment, its 2 byte length
+	// included.
+	maxSegmentSize = 0xffff
+)
+
+var (
+	// ErrNotJPEG is returned when data does not start with a JPEG SOI marker.
+	ErrNotJPEG = errors.New("not a JPEG")
+
+	// ErrSegmentTooLarge is returned when exif or XMP data does not fit in a
+	// single APP1 segment.
+	ErrSegmentTooLarge = errors.New("segment too large")
+
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// segment is a marker segment before the start of scan.
+type segment struct {
+	marker  byte
+	payload []byte
+}
+
+// parse splits data into the segments before the start of scan and the
+// remaining data, which starts with the SOS marker.
+func parse(data []byte) ([]segment, []byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, nil, ErrNotJPEG
+	}
+
+	segments := []segment{}
+	offset := 2
+	for {
+		if offset+4 > len(data) || data[offset] != 0xff {
+			return nil, nil, fmt.Errorf("invalid JPEG marker at offset %d", offset)
+		}
+		marker := data[offset+1]
+		if marker == 0xff {
+			// Fill byte.
+			offset++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, data[offset:], nil
+		}
+
+		size := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		if size < 2 || offset+2+size > len(data) {
+			return nil, nil, fmt.Errorf("invalid JPEG segment size at offset %d", offset)
+		}
+		segments = append(segments, segment{marker, data[offset+4 : offset+2+size]})
+		offset += 2 + size
+	}
+}
+
+// find returns the payload of the first APP1 segment starting with header,
+// without the header.
+func find(data, header []byte) ([]byte, error) {
+	segments, _, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range segments {
+		if s.marker == markerAPP1 && bytes.HasPrefix(s.payload, header) {
+			return s.payload[len(header):], nil
+		}
+	}
+	return nil, nil
+}
+
+// replace returns data with the first APP1 segment starting with header
+// replaced by one holding value. If there is no such segment, the new segment
+// is inserted after any APP0 and exif segments.
+func replace(data, header, value []byte) ([]byte, error) {
+	segments, rest, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := append(append([]byte{}, header...), value...)
+	if len(payload)+2 > maxSegmentSize {
+		return nil, ErrSegmentTooLarge
+	}
+
+	replaced := false
+	insertAt := 0
+	for i, s := range segments {
+		if s.marker == markerAPP1 && bytes.HasPrefix(s.payload, header) {
+			segments[i].payload = payload
+			replaced = true
+			break
+		}
+		if s.marker == markerAPP0 || (s.marker == markerAPP1 && bytes.HasPrefix(s.payload, exifHeader)) {
+			insertAt = i + 1
+		}
+	}
+	if !replaced {
+		segments = append(segments[:insertAt], append([]segment{{markerAPP1, payload}}, segments[insertAt:]...)...)
+	}
+
+	var b bytes.Buffer
+	b.Write([]byte{0xff, markerSOI})
+	for _, s := range segments {
+		b.Write([]byte{0xff, s.marker})
+		_ = binary.Write(&b, binary.BigEndian, uint16(len(s.payload)+2))
+		b.Write(s.payload)
+	}
+	b.Write(rest)
+	return b.Bytes(), nil
+}
+
+// Exif returns the exif data (starting with the TIFF header) of the JPEG
+// data. nil is returned if there is no exif segment.
+func Exif(data []byte) ([]byte, error) {
+	return find(data, exifHeader)
+}
+
+// SetExif returns the JPEG data with its exif segment replaced by exifData.
+func SetExif(data, exifData []byte) ([]byte, error) {
+	return replace(data, exifHeader, exifData)
+}
+
+// XMP returns the XMP packet of the JPEG data. nil is returned if there is
+// no XMP segment.
+func XMP(data []byte) ([]byte, error) {
+	return find(data, xmpHeader)
+}
+
+// SetXMP returns the JPEG data with its XMP segment replaced by packet.
+func SetXMP(data, packet []byte) ([]byte, error) {
+	return replace(data, xmpHeader, packet)
+}
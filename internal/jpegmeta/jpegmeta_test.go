@@ -0,0 +1,46 @@
+package jpegmeta
+
+import (
+	"pt/internal/jpegmeta/jpegmetatest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var jfif = jpegmetatest.JFIF()
+
+func TestSetExifXMP(t *testing.T) {
+	data, err := SetXMP(jfif, []byte("<x:xmpmeta/>"))
+	require.NoError(t, err)
+	data, err = SetExif(data, []byte("II*\x00"))
+	require.NoError(t, err)
+
+	exifData, err := Exif(data)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("II*\x00"), exifData)
+
+	packet, err := XMP(data)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("<x:xmpmeta/>"), packet)
+
+	// Exif is inserted after APP0 and before XMP, the scan is kept as is.
+	segments, rest, err := parse(data)
+	require.NoError(t, err)
+	assert.Len(t, segments, 3)
+	assert.Equal(t, byte(markerAPP0), segments[0].marker)
+	assert.Equal(t, exifHeader, segments[1].payload[:len(exifHeader)])
+	assert.Equal(t, jfif[8:], rest)
+
+	// Replacing keeps a single exif segment.
+	data, err = SetExif(data, []byte("MM\x00*"))
+	require.NoError(t, err)
+	segments, _, err = parse(data)
+	require.NoError(t, err)
+	assert.Len(t, segments, 3)
+}
+
+func TestNotJPEG(t *testing.T) {
+	_, err := Exif([]byte("II*\x00\x08\x00\x00\x00"))
+	assert.Equal(t, ErrNotJPEG, err)
+}
@@ -0,0 +1,13 @@
+// Package jpegmetatest provides JPEGs for the tests of packages reading and
+// writing JPEG metadata.
+package jpegmetatest
+
+// JFIF returns a JPEG with an APP0 segment and an empty scan.
+func JFIF() []byte {
+	return []byte{
+		0xff, 0xd8,
+		0xff, 0xe0, 0x00, 0x04, 'J', 'F',
+		0xff, 0xda, 0x00, 0x02, 0x01, 0x02,
+		0xff, 0xd9,
+	}
+}
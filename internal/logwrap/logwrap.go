@@ -1,16 +1,18 @@
-// Package logwrap is a wrapper around stdlib log that provides log levels.
-//
-// Copied from https://codereview.stackexchange.com/questions/272990/go-logging-wrapper-that-adds-log-levels
-//
+// Package logwrap provides named, leveled loggers built on log/slog. Messages
+// take key/value fields (eg; path, hash, destination) and are written as
+// text or JSON to one or more outputs, such as the console and a rotating log
+// file, each with its own level.
 package logwrap
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
-	"path/filepath"
+	"log/slog"
 	"runtime"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -26,90 +28,141 @@ const (
 	NONE = 100
 )
 
+// ParseLevel parses s as one of none, debug, info, warning or error.
+func ParseLevel(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return NONE, nil
+	case "debug":
+		return DEBUG, nil
+	case "info":
+		return INFO, nil
+	case "warning", "warn":
+		return WARNING, nil
+	case "error":
+		return ERROR, nil
+	}
+	return NONE, fmt.Errorf("invalid log level %q, expected none, debug, info, warning or error", s)
+}
+
+// slogLevel returns level as a slog.Level. NONE is above every level logged.
+func slogLevel(level int) slog.Level {
+	switch {
+	case level <= DEBUG:
+		return slog.LevelDebug
+	case level <= INFO:
+		return slog.LevelInfo
+	case level <= WARNING:
+		return slog.LevelWarn
+	case level <= ERROR:
+		return slog.LevelError
+	}
+	return slog.LevelError + 100
+}
+
+// Formats of an Output.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Output is a destination of log messages.
+type Output struct {
+	W io.Writer
+	// Level is the lowest level written (eg; INFO).
+	Level int
+	// Format is FormatText (the default) or FormatJSON.
+	Format string
+	// Source adds the file and line logged from to each message.
+	Source bool
+}
+
 var (
 	pkgLock sync.Mutex
 	allLogs = make(map[string]*LogWrap)
 )
 
-// LogWrap is a struct.
+// LogWrap is a named logger writing to its outputs. The methods of a nil
+// LogWrap log nothing, so the result of Get can be used before a logger is
+// created.
 type LogWrap struct {
-	name         string
-	level        int
-	debugLogWrap *log.Logger
-	infoLogWrap  *log.Logger
-	logfileinfo  bool
-	lock         sync.Mutex
+	name    string
+	levels  []*slog.LevelVar
+	handler slog.Handler
 }
 
-// New is the constructor for logger.
-func New(name string, dest io.Writer, logfileinfo bool, flags ...int) *LogWrap {
+// New returns a logger writing to outputs, registered as name for Get. A
+// logger already registered as name is replaced.
+func New(name string, outputs ...Output) *LogWrap {
+	logger := &LogWrap{name: name}
+	handlers := fanout{}
+	for _, o := range outputs {
+		level := &slog.LevelVar{}
+		level.Set(slogLevel(o.Level))
+		opts := &slog.HandlerOptions{Level: level, AddSource: o.Source}
+		if o.Format == FormatJSON {
+			handlers = append(handlers, slog.NewJSONHandler(o.W, opts))
+		} else {
+			handlers = append(handlers, slog.NewTextHandler(o.W, opts))
+		}
+		logger.levels = append(logger.levels, level)
+	}
+	logger.handler = handlers
+
 	pkgLock.Lock()
 	defer pkgLock.Unlock()
-	if _, exists := allLogs[name]; exists {
-		panic(fmt.Sprintf("Unable to create logger with %s: name already in use", name))
-	}
+	allLogs[name] = logger
+	return logger
+}
 
-	var logFlags int
-	if flags == nil {
-		logFlags = log.Ldate | log.Ltime | log.Lmsgprefix
-	} else {
-		logFlags = flags[0]
+// SetLevel sets the level of every output of the logger. Each level is an int
+// that can be set via pkg consts (eg; logwrap.DEBUG) or via literal ints.
+// Messages with a level lower than the level of an output are not written to
+// it.
+func (l *LogWrap) SetLevel(level int) {
+	if l == nil {
+		return
 	}
-
-	logger := &LogWrap{
-		name:         name,
-		debugLogWrap: log.New(dest, "DEBUG: ", logFlags),
-		infoLogWrap:  log.New(dest, "INFO: ", logFlags),
+	for _, v := range l.levels {
+		v.Set(slogLevel(level))
 	}
+}
 
-	allLogs[name] = logger
-	return logger
+// Debug logs a debug message with key/value fields (eg; "path", p).
+func (l *LogWrap) Debug(msg string, args ...any) {
+	l.log(slog.LevelDebug, msg, args)
 }
 
-// getfileinfo gets the calling filename and line number.
-func getfileinfo() string {
-	_, filename, line, ok := runtime.Caller(2)
-	if !ok {
-		filename = "Unknown"
-		line = 0
-	}
-	return fmt.Sprintf("%s:%d: ", filepath.Base(filename), line)
+// Info logs an info message with key/value fields.
+func (l *LogWrap) Info(msg string, args ...any) {
+	l.log(slog.LevelInfo, msg, args)
 }
 
-// SetLevel sets the log level for the respective LogWrap. Each level is an int
-// that can be set via pkg consts (eg; logger.DEBUG) or via literal ints.
-// Messages sent to LogWraps with a log level lower than the current level are
-// not written.
-func (l *LogWrap) SetLevel(level int) {
-	l.lock.Lock()
-	defer l.lock.Unlock()
-	l.level = level
+// Warning logs a warning message with key/value fields.
+func (l *LogWrap) Warning(msg string, args ...any) {
+	l.log(slog.LevelWarn, msg, args)
 }
 
-// Info prints info messages.
-func (l *LogWrap) Info(msg string) {
-	l.lock.Lock()
-	defer l.lock.Unlock()
-	if l.level > INFO {
-		return
-	}
-	if l.logfileinfo {
-		msg = getfileinfo() + msg
-	}
-	l.infoLogWrap.Println(msg)
+// Error logs an error message with key/value fields.
+func (l *LogWrap) Error(msg string, args ...any) {
+	l.log(slog.LevelError, msg, args)
 }
 
-// Debug prints debug messages.
-func (l *LogWrap) Debug(msg string) {
-	l.lock.Lock()
-	defer l.lock.Unlock()
-	if l.level > DEBUG {
+// log writes msg to the outputs enabled for level, with the caller of the
+// exported method as its source.
+func (l *LogWrap) log(level slog.Level, msg string, args []any) {
+	if l == nil {
 		return
 	}
-	if l.logfileinfo {
-		msg = getfileinfo() + msg
+	ctx := context.Background()
+	if !l.handler.Enabled(ctx, level) {
+		return
 	}
-	l.infoLogWrap.Println(msg)
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.Add(args...)
+	_ = l.handler.Handle(ctx, r)
 }
 
 // Get returns a reference to an existing LogWrap if one exists, othewise nil.
@@ -121,3 +174,45 @@ func Get(name string) *LogWrap {
 	}
 	return nil
 }
+
+// fanout is a slog.Handler passing records to each handler enabled for their
+// level.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if handleErr := h.Handle(ctx, r.Clone()); err == nil {
+			err = handleErr
+		}
+	}
+	return err
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := fanout{}
+	for _, h := range f {
+		handlers = append(handlers, h.WithAttrs(attrs))
+	}
+	return handlers
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	handlers := fanout{}
+	for _, h := range f {
+		handlers = append(handlers, h.WithGroup(name))
+	}
+	return handlers
+}
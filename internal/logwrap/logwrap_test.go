@@ -0,0 +1,98 @@
+package logwrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogWrap(t *testing.T) {
+	console, file := &bytes.Buffer{}, &bytes.Buffer{}
+	first := New("test", Output{W: &bytes.Buffer{}})
+	logger := New("test", Output{W: console, Level: WARNING}, Output{W: file, Level: DEBUG, Format: FormatJSON})
+	assert.NotSame(t, first, Get("test"))
+	assert.Same(t, logger, Get("test"))
+
+	logger.Debug("copy finished", "path", "/media/card/a.jpg", "destination", "/photos/a.jpg")
+	logger.Info("copied file", "hash", "abc")
+	logger.Warning("retrying read", "retry", 1)
+	logger.Error("file failed", "path", "/media/card/b.jpg")
+
+	lines := strings.Split(strings.TrimSpace(console.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `level=WARN msg="retrying read" retry=1`)
+	assert.Contains(t, lines[1], `level=ERROR msg="file failed" path=/media/card/b.jpg`)
+
+	lines = strings.Split(strings.TrimSpace(file.String()), "\n")
+	require.Len(t, lines, 4)
+	m := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &m))
+	assert.Equal(t, "DEBUG", m["level"])
+	assert.Equal(t, "/media/card/a.jpg", m["path"])
+	assert.Equal(t, "/photos/a.jpg", m["destination"])
+
+	logger.SetLevel(NONE)
+	logger.Error("not logged")
+	assert.Len(t, strings.Split(strings.TrimSpace(file.String()), "\n"), 4)
+
+	var nilLogger *LogWrap
+	nilLogger.Info("not logged")
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		wantErr bool
+	}{
+		{s: "none", want: NONE},
+		{s: "debug", want: DEBUG},
+		{s: "INFO", want: INFO},
+		{s: "warning", want: WARNING},
+		{s: "error", want: ERROR},
+		{s: "verbose", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.s)
+		if tt.wantErr {
+			assert.Error(t, err, tt.s)
+			continue
+		}
+		assert.NoError(t, err, tt.s)
+		assert.Equal(t, tt.want, got, tt.s)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "logs", "pt.log")
+	f, err := OpenRotatingFile(p, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	for p, want := range map[string]string{p: "dddddddd\n", p + ".1": "cccccccc\n", p + ".2": "bbbbbbbb\n"} {
+		b, err := os.ReadFile(p)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(b), p)
+	}
+	assert.NoFileExists(t, p+".3")
+
+	// An existing file is appended to.
+	f, err = OpenRotatingFile(p, 100, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("eeeeeeee\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "dddddddd\neeeeeeee\n", string(b))
+}
@@ -0,0 +1,97 @@
+package logwrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file which is rotated once it reaches a maximum size,
+// keeping a number of the files before it as <path>.1 (the newest) to
+// <path>.<backups>. A RotatingFile is safe for concurrent use.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	fh   *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file at p for appending, creating it and its
+// directory if needed. The file is rotated before a write would take it past
+// maxSize bytes, keeping backups files before it. A maxSize of 0 never
+// rotates.
+func OpenRotatingFile(p string, maxSize int64, backups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	f := &RotatingFile{path: p, maxSize: maxSize, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	fh, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return err
+	}
+	f.fh, f.size = fh, info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would take it past its
+// maximum size.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.fh.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves each backup along one, dropping the oldest, and starts a new
+// file. f.mu must be held.
+func (f *RotatingFile) rotate() error {
+	if err := f.fh.Close(); err != nil {
+		return err
+	}
+	for i := f.backups - 1; i > 0; i-- {
+		if err := os.Rename(backupPath(f.path, i), backupPath(f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if f.backups > 0 {
+		if err := os.Rename(f.path, backupPath(f.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func backupPath(p string, i int) string {
+	return fmt.Sprintf("%s.%d", p, i)
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fh.Close()
+}
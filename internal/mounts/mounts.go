@@ -0,0 +1,245 @@
+// Package mounts finds the filesystems mounted on Linux from
+// /proc/self/mountinfo, so volumes such as camera cards can be imported when
+// they are plugged in.
+package mounts
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MountInfo is the file listing the mounts of the process.
+	MountInfo = "/proc/self/mountinfo"
+	// DiskDir holds the by-label and by-uuid symlinks to block devices
+	// made by udev.
+	DiskDir = "/dev/disk"
+)
+
+// Mount is a mounted filesystem.
+type Mount struct {
+	// ID is unique to the mount, a volume mounted again gets a new ID.
+	ID         int
+	MountPoint string
+	FSType     string
+	// Source is the device mounted (eg; /dev/sdb1).
+	Source string
+
+	// Label and UUID are those of the volume, set by Identify.
+	Label string
+	UUID  string
+}
+
+// String returns the mount point of m with its label and UUID.
+func (m Mount) String() string {
+	ids := []string{}
+	if m.Label != "" {
+		ids = append(ids, "label "+m.Label)
+	}
+	if m.UUID != "" {
+		ids = append(ids, "uuid "+m.UUID)
+	}
+	if len(ids) == 0 {
+		return m.MountPoint
+	}
+	return fmt.Sprintf("%s (%s)", m.MountPoint, strings.Join(ids, ", "))
+}
+
+// HasDCIM returns true if the root of m holds a DCIM directory, as the
+// cards of cameras and phones do.
+func (m Mount) HasDCIM() bool {
+	info, err := os.Stat(filepath.Join(m.MountPoint, "DCIM"))
+	return err == nil && info.IsDir()
+}
+
+// Identify sets the label and UUID of m from the symlinks to its device in
+// the by-label and by-uuid directories of dir (eg; DiskDir).
+func (m *Mount) Identify(dir string) {
+	device, err := filepath.EvalSymlinks(m.Source)
+	if err != nil {
+		return
+	}
+	m.Label = linkTo(filepath.Join(dir, "by-label"), device)
+	m.UUID = linkTo(filepath.Join(dir, "by-uuid"), device)
+}
+
+// linkTo returns the name of the symlink in dir to device, unescaped, or ""
+// if there is none.
+func linkTo(dir, device string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, e.Name()))
+		if err == nil && target == device {
+			return unescapeUdev(e.Name())
+		}
+	}
+	return ""
+}
+
+// unescapeUdev returns s with the \xHH escapes udev uses for characters such
+// as spaces and slashes in labels replaced.
+func unescapeUdev(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Parse returns the mounts listed in r in the format of
+// /proc/self/mountinfo.
+func Parse(r io.Reader) ([]Mount, error) {
+	mounts := []Mount{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		m, err := parseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// parseLine parses a line of mountinfo:
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// The optional fields before - are of any number.
+func parseLine(s string) (Mount, error) {
+	fields := strings.Fields(s)
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || sep+2 >= len(fields) {
+		return Mount{}, fmt.Errorf("invalid mountinfo %q", s)
+	}
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Mount{}, fmt.Errorf("invalid mount ID %q", fields[0])
+	}
+	return Mount{
+		ID:         id,
+		MountPoint: unescapeOctal(fields[4]),
+		FSType:     fields[sep+1],
+		Source:     unescapeOctal(fields[sep+2]),
+	}, nil
+}
+
+// unescapeOctal returns s with the \NNN escapes the kernel uses for spaces,
+// tabs, newlines and backslashes replaced.
+func unescapeOctal(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Read returns the mounts listed in the mountinfo file at p.
+func Read(p string) ([]Mount, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	mounts, err := Parse(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return mounts, nil
+}
+
+// Watcher reports filesystems as they are mounted.
+type Watcher struct {
+	// Path is the mountinfo file read (default: MountInfo).
+	Path string
+	// Interval is how often Path is read (default: 2s).
+	Interval time.Duration
+}
+
+// Watch calls mounted with each filesystem mounted after Watch starts, until
+// ctx is done or mounted returns an error. A filesystem mounted while
+// mounted runs is reported once it returns.
+func (w Watcher) Watch(ctx context.Context, mounted func(Mount) error) error {
+	p := w.Path
+	if p == "" {
+		p = MountInfo
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	current, err := Read(p)
+	if err != nil {
+		return err
+	}
+	seen := ids(current)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		current, err := Read(p)
+		if err != nil {
+			return err
+		}
+		for _, m := range current {
+			if seen[m.ID] {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := mounted(m); err != nil {
+				return err
+			}
+		}
+		seen = ids(current)
+	}
+}
+
+// ids returns the IDs of mounts.
+func ids(mounts []Mount) map[int]bool {
+	seen := map[int]bool{}
+	for _, m := range mounts {
+		seen[m.ID] = true
+	}
+	return seen
+}
@@ -0,0 +1,153 @@
+package mounts
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mountinfo = `22 1 259:2 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p2 rw
+25 22 0:5 / /dev rw,nosuid shared:2 - devtmpfs udev rw,size=8000000k
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		want   []Mount
+		hasErr bool
+	}{
+		{
+			name: "mounts",
+			in:   mountinfo,
+			want: []Mount{
+				{ID: 22, MountPoint: "/", FSType: "ext4", Source: "/dev/nvme0n1p2"},
+				{ID: 25, MountPoint: "/dev", FSType: "devtmpfs", Source: "udev"},
+			},
+		},
+		{
+			name: "escaped mount point without optional fields",
+			in:   `97 22 8:17 / /media/rene/EOS\040DIGITAL rw,nosuid,nodev - vfat /dev/sdb1 rw,uid=1000` + "\n",
+			want: []Mount{
+				{ID: 97, MountPoint: "/media/rene/EOS DIGITAL", FSType: "vfat", Source: "/dev/sdb1"},
+			},
+		},
+		{
+			name: "several optional fields",
+			in:   "36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 shared:3 - ext3 /dev/root rw,errors=continue\n",
+			want: []Mount{
+				{ID: 36, MountPoint: "/mnt2", FSType: "ext3", Source: "/dev/root"},
+			},
+		},
+		{
+			name:   "no separator",
+			in:     "36 35 98:0 /mnt1 /mnt2 rw,noatime ext3 /dev/root rw\n",
+			hasErr: true,
+		},
+		{
+			name:   "invalid ID",
+			in:     "x 35 98:0 /mnt1 /mnt2 rw,noatime - ext3 /dev/root rw\n",
+			hasErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.in))
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "sdb1")
+	require.NoError(t, os.WriteFile(device, nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sdc1"), nil, 0644))
+	for link, target := range map[string]string{
+		"by-label/EOS\\x20DIGITAL": device,
+		"by-label/BACKUP":          filepath.Join(dir, "sdc1"),
+		"by-uuid/3A1B-2C4D":        device,
+	} {
+		p := filepath.Join(dir, "disk", link)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.Symlink(target, p))
+	}
+
+	m := Mount{MountPoint: "/media/card", Source: device}
+	m.Identify(filepath.Join(dir, "disk"))
+	assert.Equal(t, "EOS DIGITAL", m.Label)
+	assert.Equal(t, "3A1B-2C4D", m.UUID)
+	assert.Equal(t, "/media/card (label EOS DIGITAL, uuid 3A1B-2C4D)", m.String())
+
+	m = Mount{MountPoint: "/", Source: filepath.Join(dir, "missing")}
+	m.Identify(filepath.Join(dir, "disk"))
+	assert.Equal(t, "", m.Label)
+	assert.Equal(t, "/", m.String())
+}
+
+// writeMountInfo replaces the file at p with content at once, as the
+// watcher may read it at any time.
+func writeMountInfo(t *testing.T, p, content string) {
+	require.NoError(t, os.WriteFile(p+".tmp", []byte(content), 0644))
+	require.NoError(t, os.Rename(p+".tmp", p))
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "mountinfo")
+	require.NoError(t, os.WriteFile(p, []byte(mountinfo), 0644))
+
+	card := filepath.Join(dir, "card")
+	require.NoError(t, os.MkdirAll(filepath.Join(card, "DCIM"), 0755))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mounted := make(chan Mount)
+	done := make(chan error)
+	go func() {
+		done <- Watcher{Path: p, Interval: 10 * time.Millisecond}.Watch(ctx, func(m Mount) error {
+			mounted <- m
+			return nil
+		})
+	}()
+
+	// Mounts listed when the watch starts aren't reported.
+	time.Sleep(50 * time.Millisecond)
+	line := "97 22 8:17 / " + card + " rw - vfat /dev/sdb1 rw\n"
+	writeMountInfo(t, p, mountinfo+line)
+	select {
+	case m := <-mounted:
+		assert.Equal(t, 97, m.ID)
+		assert.Equal(t, card, m.MountPoint)
+		assert.True(t, m.HasDCIM())
+	case <-time.After(5 * time.Second):
+		t.Fatal("mount not reported")
+	}
+
+	// Unmounted and mounted again with a new ID.
+	writeMountInfo(t, p, mountinfo)
+	time.Sleep(50 * time.Millisecond)
+	line = "98 22 8:17 / " + card + " rw - vfat /dev/sdb1 rw\n"
+	writeMountInfo(t, p, mountinfo+line)
+	select {
+	case m := <-mounted:
+		assert.Equal(t, 98, m.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("mount not reported")
+	}
+
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+}
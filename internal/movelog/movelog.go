@@ -0,0 +1,125 @@
+// Package movelog records file moves so they can be undone.
+//
+// A move log is a file of JSON lines, one per move, written as each move is
+// made so that a log of an interrupted run is still complete.
+package movelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/fileutil"
+	"time"
+)
+
+// Move is a file moved from Src to Dst.
+type Move struct {
+	Time time.Time `json:"time"`
+	Src  string    `json:"src"`
+	Dst  string    `json:"dst"`
+
+	// Meta holds the meta values of the file before it was moved, to be
+	// restored when the move is undone. A nil value is a meta value the
+	// file didn't have.
+	Meta map[string]*string `json:"meta,omitempty"`
+}
+
+// Log is a move log being written.
+type Log struct {
+	path string
+	fh   *os.File
+}
+
+// Create creates a new move log in dir named after name and the current
+// time (eg; reorganize-20220102T030405.jsonl).
+func Create(dir, name string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	base := fmt.Sprintf("%s-%s", name, time.Now().Format("20060102T150405"))
+	p := filepath.Join(dir, base+".jsonl")
+	for i := 1; ; i++ {
+		fh, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return &Log{path: p, fh: fh}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		p = filepath.Join(dir, fmt.Sprintf("%s-%d.jsonl", base, i))
+	}
+}
+
+// Path returns the path of the log file.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Record appends m to the log.
+func (l *Log) Record(m Move) error {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := l.fh.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	return l.fh.Sync()
+}
+
+// Move moves src to dst and records the move along with the meta values of
+// the file before the move. dst must not exist.
+func (l *Log) Move(src, dst string, meta map[string]*string) error {
+	if err := fileutil.Move(src, dst); err != nil {
+		return err
+	}
+	return l.Record(Move{Src: src, Dst: dst, Meta: meta})
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	return l.fh.Close()
+}
+
+// Read returns the moves recorded in the log file p in the order they were
+// made.
+func Read(p string) ([]Move, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	moves := []Move{}
+	scanner := bufio.NewScanner(fh)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		m := Move{}
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", p, line, err)
+		}
+		moves = append(moves, m)
+	}
+	return moves, scanner.Err()
+}
+
+// Reverse returns the moves that undo moves, in the order they have to be
+// made. The meta values of each move are the ones to restore.
+func Reverse(moves []Move) []Move {
+	undo := make([]Move, 0, len(moves))
+	for i := len(moves) - 1; i >= 0; i-- {
+		undo = append(undo, Move{Src: moves[i].Dst, Dst: moves[i].Src, Meta: moves[i].Meta})
+	}
+	return undo
+}
@@ -0,0 +1,50 @@
+package movelog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveAndUndo(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.jpg")
+	b := filepath.Join(dir, "2022", "b.jpg")
+	c := filepath.Join(dir, "2022", "01", "c.jpg")
+	require.NoError(t, ioutil.WriteFile(a, []byte("a"), 0644))
+	album := "Recents"
+	meta := map[string]*string{"album": &album, "device": nil}
+
+	l, err := Create(filepath.Join(dir, "logs"), "reorganize")
+	require.NoError(t, err)
+	require.NoError(t, l.Move(a, b, meta))
+	require.NoError(t, l.Move(b, c, nil))
+	assert.Error(t, l.Move(a, c, nil))
+	require.NoError(t, l.Close())
+
+	// A second log created at the same time gets a different name.
+	l2, err := Create(filepath.Join(dir, "logs"), "reorganize")
+	require.NoError(t, err)
+	require.NoError(t, l2.Close())
+	assert.NotEqual(t, l.Path(), l2.Path())
+
+	moves, err := Read(l.Path())
+	require.NoError(t, err)
+	require.Len(t, moves, 2)
+	assert.Equal(t, a, moves[0].Src)
+	assert.Equal(t, c, moves[1].Dst)
+
+	assert.Equal(t, meta, moves[0].Meta)
+
+	undo := Reverse(moves)
+	assert.Equal(t, []Move{{Src: c, Dst: b}, {Src: b, Dst: a, Meta: meta}}, undo)
+	for _, m := range undo {
+		require.NoError(t, os.Rename(m.Src, m.Dst))
+	}
+	_, err = os.Stat(a)
+	assert.NoError(t, err)
+}
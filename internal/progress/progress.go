@@ -0,0 +1,281 @@
+// Package progress reports the progress of commands processing many files,
+// as a line redrawn in place on a terminal or as a line written every so
+// often when the output is piped or logged.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode is how progress is reported.
+type Mode string
+
+const (
+	// ModeAuto redraws a line on a terminal and writes plain lines
+	// otherwise.
+	ModeAuto Mode = "auto"
+	// ModePlain writes a line every interval.
+	ModePlain Mode = "plain"
+	// ModeNone reports nothing.
+	ModeNone Mode = "none"
+)
+
+// ParseMode parses s as one of auto, plain or none.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(s)); m {
+	case ModeAuto, ModePlain, ModeNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid progress %q, expected auto, plain or none", s)
+}
+
+// Outcomes counted by Done besides the outcomes of commands.
+const (
+	OutcomeSkipped = "skipped"
+	OutcomeFailed  = "failed"
+)
+
+// liveInterval is how often the line on a terminal is redrawn.
+const liveInterval = 250 * time.Millisecond
+
+// Reporter tracks the files found and processed by a command and reports its
+// progress. The methods of a nil Reporter do nothing, and a Reporter is safe
+// for concurrent use.
+type Reporter struct {
+	w        io.Writer
+	name     string
+	live     bool
+	interval time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	snapshot Snapshot
+	start    time.Time
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// New returns a Reporter of the command name writing to w, or nil for
+// ModeNone. Plain lines are written every interval.
+func New(w io.Writer, name string, mode Mode, interval time.Duration) *Reporter {
+	if mode == ModeNone {
+		return nil
+	}
+	return &Reporter{
+		w:        w,
+		name:     name,
+		live:     mode == ModeAuto && isTerminal(w),
+		interval: interval,
+		now:      time.Now,
+		snapshot: Snapshot{Discovering: true, Counts: map[string]int{}},
+	}
+}
+
+// isTerminal returns true if w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start starts reporting until Stop is called.
+func (r *Reporter) Start() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.start = r.now()
+	r.stop = make(chan struct{})
+	r.stopped = make(chan struct{})
+	r.mu.Unlock()
+
+	interval := r.interval
+	if r.live {
+		interval = liveInterval
+	}
+	go func() {
+		defer close(r.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting and writes the final progress.
+func (r *Reporter) Stop() {
+	if r == nil || r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.stopped
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot.Current = ""
+	r.write(true)
+}
+
+// Discovered counts a file of size bytes found to be processed.
+func (r *Reporter) Discovered(size int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot.TotalFiles++
+	r.snapshot.TotalBytes += size
+}
+
+// DiscoveryDone marks every file as found, so the time remaining can be
+// estimated.
+func (r *Reporter) DiscoveryDone() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot.Discovering = false
+}
+
+// Begin sets the file at p as the file being processed.
+func (r *Reporter) Begin(p string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot.Current = p
+}
+
+// Done counts a processed file of size bytes with outcome (eg; copied).
+func (r *Reporter) Done(outcome string, size int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot.Files++
+	r.snapshot.Bytes += size
+	if _, ok := r.snapshot.Counts[outcome]; !ok {
+		r.snapshot.Outcomes = append(r.snapshot.Outcomes, outcome)
+	}
+	r.snapshot.Counts[outcome]++
+}
+
+func (r *Reporter) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.write(false)
+}
+
+// write writes the progress, ending the line on a terminal if final is set.
+// r.mu must be held.
+func (r *Reporter) write(final bool) {
+	r.snapshot.Elapsed = r.now().Sub(r.start)
+	line := r.name + ": " + r.snapshot.String()
+	switch {
+	case !r.live:
+		fmt.Fprintln(r.w, line)
+	case final:
+		fmt.Fprintf(r.w, "\r\033[K%s\n", line)
+	default:
+		fmt.Fprintf(r.w, "\r\033[K%s", line)
+	}
+}
+
+// Snapshot is the progress of a command at a point in time.
+type Snapshot struct {
+	// Files and Bytes are the number of files and bytes processed out of
+	// TotalFiles and TotalBytes found. Discovering is set until every
+	// file has been found.
+	Files, TotalFiles int
+	Bytes, TotalBytes int64
+	Discovering       bool
+
+	Elapsed time.Duration
+
+	// Counts is the number of files of each outcome, in the order of
+	// Outcomes.
+	Outcomes []string
+	Counts   map[string]int
+
+	// Current is the file being processed.
+	Current string
+}
+
+// Throughput returns the bytes processed per second.
+func (s Snapshot) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time until every file is processed, or false if
+// it can't be estimated yet.
+func (s Snapshot) ETA() (time.Duration, bool) {
+	throughput := s.Throughput()
+	if s.Discovering || throughput == 0 {
+		return 0, false
+	}
+	remaining := float64(s.TotalBytes-s.Bytes) / throughput
+	return time.Duration(remaining * float64(time.Second)).Round(time.Second), true
+}
+
+// String returns the progress as a single line, eg;
+//
+//	120/450 files, 1.2G/3.4G, 25.3M/s, ETA 1m30s, copied 100, skipped 20, current IMG_0001.JPG
+func (s Snapshot) String() string {
+	total := ""
+	if s.Discovering {
+		total = "+"
+	}
+	parts := []string{
+		fmt.Sprintf("%d/%d%s files", s.Files, s.TotalFiles, total),
+		fmt.Sprintf("%s/%s%s", FormatBytes(s.Bytes), FormatBytes(s.TotalBytes), total),
+		fmt.Sprintf("%s/s", FormatBytes(int64(s.Throughput()))),
+	}
+	if eta, ok := s.ETA(); ok {
+		parts = append(parts, fmt.Sprintf("ETA %s", eta))
+	}
+	for _, outcome := range s.Outcomes {
+		parts = append(parts, fmt.Sprintf("%s %d", outcome, s.Counts[outcome]))
+	}
+	if s.Current != "" {
+		parts = append(parts, "current "+filepath.Base(s.Current))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatBytes formats n with the suffixes parsed by filter.ParseSize (eg;
+// 1.5G).
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n)
+	for _, suffix := range []string{"K", "M", "G", "T"} {
+		v /= unit
+		if v < unit || suffix == "T" {
+			return fmt.Sprintf("%.1f%s", v, suffix)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package progress
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n      int64
+		expect string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1536, "1.5K"},
+		{25 << 20, "25.0M"},
+		{3 << 40, "3.0T"},
+		{5000 << 40, "5000.0T"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expect, FormatBytes(test.n))
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := Snapshot{
+		Files:       2,
+		TotalFiles:  5,
+		Bytes:       20 << 20,
+		TotalBytes:  50 << 20,
+		Discovering: true,
+		Elapsed:     10 * time.Second,
+		Outcomes:    []string{"copied", OutcomeSkipped},
+		Counts:      map[string]int{"copied": 1, OutcomeSkipped: 1},
+		Current:     "/media/card/DCIM/IMG_0003.JPG",
+	}
+	_, ok := s.ETA()
+	assert.False(t, ok)
+	assert.Equal(t, "2/5+ files, 20.0M/50.0M+, 2.0M/s, copied 1, skipped 1, current IMG_0003.JPG", s.String())
+
+	s.Discovering = false
+	eta, ok := s.ETA()
+	assert.True(t, ok)
+	assert.Equal(t, 15*time.Second, eta)
+	assert.Equal(t, "2/5 files, 20.0M/50.0M, 2.0M/s, ETA 15s, copied 1, skipped 1, current IMG_0003.JPG", s.String())
+}
+
+func TestReporter(t *testing.T) {
+	assert.Nil(t, New(&bytes.Buffer{}, "copy", ModeNone, time.Second))
+
+	// The methods of a nil Reporter do nothing.
+	var none *Reporter
+	none.Start()
+	none.Discovered(1)
+	none.Done("copied", 1)
+	none.Stop()
+
+	buf := &bytes.Buffer{}
+	r := New(buf, "copy", ModeAuto, time.Hour)
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.Start()
+	r.Discovered(4 << 20)
+	r.Discovered(6 << 20)
+	r.DiscoveryDone()
+	r.Begin("/media/card/DCIM/IMG_0001.JPG")
+	r.Done("copied", 4<<20)
+	now = now.Add(2 * time.Second)
+	r.Stop()
+
+	// A buffer isn't a terminal so plain lines are written.
+	assert.Equal(t, "copy: 1/2 files, 4.0M/10.0M, 2.0M/s, ETA 3s, copied 1\n", buf.String())
+}
@@ -0,0 +1,214 @@
+// Package raw extracts metadata from camera raw files.
+//
+// TIFF based raws (CR2, NEF, ARW and DNG) are parsed as TIFF containers where
+// IFD0 starts at the offset given in the file header. Canon CR3 files are ISO
+// base media files which store IFD0, the Exif IFD and the GPS IFD as separate
+// TIFF structures within the CMT1, CMT2 and CMT4 boxes.
+package raw
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"pt/internal/bmff"
+	"strings"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// Format is a raw file format.
+type Format string
+
+const (
+	// CR2 is a Canon raw file.
+	CR2 Format = "cr2"
+	// CR3 is a Canon raw file stored in an ISO base media container.
+	CR3 Format = "cr3"
+	// NEF is a Nikon raw file.
+	NEF Format = "nef"
+	// ARW is a Sony raw file.
+	ARW Format = "arw"
+	// DNG is an Adobe digital negative.
+	DNG Format = "dng"
+)
+
+var (
+	// ErrNotRaw is returned when a file is not a supported raw file.
+	ErrNotRaw = errors.New("not a raw file")
+
+	// canonUUID is the user type of the CR3 uuid box holding the CMT boxes.
+	canonUUID = [16]byte{
+		0x85, 0xc0, 0xb6, 0x87, 0x82, 0x0f, 0x11, 0xe0,
+		0x81, 0x11, 0xf4, 0xce, 0x46, 0x2b, 0x6a, 0x48,
+	}
+
+	// tiffExtensions maps TIFF based raw file extensions to their format.
+	// CR2 files are detected from their header.
+	tiffExtensions = map[string]Format{
+		".nef": NEF,
+		".nrw": NEF,
+		".arw": ARW,
+		".srf": ARW,
+		".sr2": ARW,
+		".dng": DNG,
+	}
+)
+
+// Detect returns the raw format of a file from the first bytes of the file
+// and its extension.
+func Detect(head []byte, ext string) (Format, bool) {
+	if bmff.Brand(head) == "crx " {
+		return CR3, true
+	}
+
+	if !isTiff(head) {
+		return "", false
+	}
+
+	if len(head) > 10 && head[8] == 'C' && head[9] == 'R' {
+		return CR2, true
+	}
+
+	format, ok := tiffExtensions[strings.ToLower(ext)]
+	return format, ok
+}
+
+// isTiff checks for a little or big endian TIFF header.
+func isTiff(head []byte) bool {
+	if len(head) < 8 {
+		return false
+	}
+	switch string(head[0:4]) {
+	case "II*\x00", "MM\x00*":
+		return true
+	}
+	return false
+}
+
+// ReadExifTags returns the exif tags found in the raw file p. ErrNotRaw is
+// returned if p is not a supported raw file.
+func ReadExifTags(p string) ([]exif.ExifTag, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(fh, head); err != nil {
+		return nil, ErrNotRaw
+	}
+
+	format, ok := Detect(head, filepath.Ext(p))
+	if !ok {
+		return nil, ErrNotRaw
+	}
+
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return ExifTags(fh, info.Size(), format)
+}
+
+// ExifTags returns the exif tags of a raw file of the given format read from
+// r, which is size bytes long.
+func ExifTags(r io.ReaderAt, size int64, format Format) ([]exif.ExifTag, error) {
+	if format != CR3 {
+		return scanTiff(io.NewSectionReader(r, 0, size), exifcommon.IfdStandardIfdIdentity)
+	}
+
+	moov, err := bmff.Find(r, 0, size, "moov")
+	if err != nil {
+		return nil, err
+	}
+
+	uuid, err := bmff.FindUUID(r, moov.DataOffset, moov.End(), canonUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	boxes, err := bmff.ReadBoxes(r, uuid.DataOffset, uuid.End())
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+
+	// Each CMT box holds a TIFF structure whose first IFD is the IFD listed
+	// here.
+	cmtIfds := map[string]*exifcommon.IfdIdentity{
+		"CMT1": exifcommon.IfdStandardIfdIdentity,
+		"CMT2": exifcommon.IfdExifStandardIfdIdentity,
+		"CMT4": exifcommon.IfdGpsInfoStandardIfdIdentity,
+	}
+
+	tags := []exif.ExifTag{}
+	for _, b := range boxes {
+		ii, ok := cmtIfds[b.Type]
+		if !ok {
+			continue
+		}
+		t, err := scanTiff(io.NewSectionReader(r, b.DataOffset, b.DataSize()), ii)
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, t...)
+	}
+
+	return tags, nil
+}
+
+// scanTiff returns the tags of the TIFF structure found at the start of rs.
+// The first IFD is treated as the IFD identified by root.
+func scanTiff(rs io.ReadSeeker, root *exifcommon.IfdIdentity) (tags []exif.ExifTag, err error) {
+	header := make([]byte, exif.ExifSignatureLength)
+	if _, err := io.ReadFull(rs, header); err != nil {
+		return nil, err
+	}
+
+	eh, err := exif.ParseExifHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return nil, err
+	}
+
+	ie := exif.NewIfdEnumerate(im, exif.NewTagIndex(), exif.NewExifReadSeeker(rs), eh.ByteOrder)
+
+	visitor := func(ite *exif.IfdTagEntry) error {
+		// Skip makernote and other vendor values that can't be parsed.
+		value, err := ite.Value()
+		if err != nil {
+			return nil
+		}
+
+		formatted, err := ite.Format()
+		if err != nil {
+			return nil
+		}
+
+		tags = append(tags, exif.ExifTag{
+			IfdPath:      ite.IfdPath(),
+			TagId:        ite.TagId(),
+			TagName:      ite.TagName(),
+			UnitCount:    ite.UnitCount(),
+			TagTypeId:    ite.TagType(),
+			TagTypeName:  ite.TagType().String(),
+			Value:        value,
+			Formatted:    formatted,
+			ChildIfdPath: ite.ChildIfdPath(),
+		})
+		return nil
+	}
+
+	if _, err := ie.Scan(root, eh.FirstIfdOffset, visitor, nil); err != nil {
+		return tags, err
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,106 @@
+package raw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeTiff returns a TIFF structure whose first IFD is ii holding tags.
+func encodeTiff(t *testing.T, ii *exifcommon.IfdIdentity, tags map[string]interface{}) []byte {
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	require.NoError(t, err)
+	ib := exif.NewIfdBuilder(im, exif.NewTagIndex(), ii, exifcommon.EncodeDefaultByteOrder)
+	for k, v := range tags {
+		require.NoError(t, ib.AddStandardWithName(k, v))
+	}
+	buf, err := exif.NewIfdByteEncoder().EncodeToExif(ib)
+	require.NoError(t, err)
+	return buf
+}
+
+// box returns an ISO base media box of type t holding data.
+func box(t string, data ...[]byte) []byte {
+	payload := bytes.Join(data, nil)
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(len(payload)+8))
+	copy(b[4:], t)
+	return append(b, payload...)
+}
+
+func tagValues(tags []exif.ExifTag) map[string]string {
+	m := map[string]string{}
+	for _, i := range tags {
+		m[i.TagName] = i.Formatted
+	}
+	return m
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		head   []byte
+		ext    string
+		expect Format
+		ok     bool
+	}{
+		{[]byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), ".CR2", CR2, true},
+		{[]byte("MM\x00*\x00\x00\x00\x08\x00\x00\x00\x00"), ".NEF", NEF, true},
+		{[]byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00"), ".arw", ARW, true},
+		{[]byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00"), ".tif", "", false},
+		{[]byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01"), ".CR3", CR3, true},
+		{[]byte("\xff\xd8\xff\xe1\x00\x00Exif\x00\x00\x00\x00"), ".jpg", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.ext, func(t *testing.T) {
+			format, ok := Detect(test.head, test.ext)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.expect, format)
+		})
+	}
+}
+
+func TestExifTagsTiff(t *testing.T) {
+	buf := encodeTiff(t, exifcommon.IfdStandardIfdIdentity, map[string]interface{}{
+		"Make":  "NIKON CORPORATION",
+		"Model": "NIKON D750",
+	})
+
+	tags, err := ExifTags(bytes.NewReader(buf), int64(len(buf)), NEF)
+	require.NoError(t, err)
+
+	m := tagValues(tags)
+	assert.Equal(t, "NIKON CORPORATION", m["Make"])
+	assert.Equal(t, "NIKON D750", m["Model"])
+}
+
+func TestExifTagsCR3(t *testing.T) {
+	cmt1 := encodeTiff(t, exifcommon.IfdStandardIfdIdentity, map[string]interface{}{
+		"Make": "Canon",
+	})
+	cmt2 := encodeTiff(t, exifcommon.IfdExifStandardIfdIdentity, map[string]interface{}{
+		"DateTimeOriginal":   "2022:01:30 16:00:01",
+		"SubSecTimeOriginal": "25",
+		"BodySerialNumber":   "012345678901",
+		"LensModel":          "RF24-105mm F4 L IS USM",
+	})
+
+	buf := bytes.Join([][]byte{
+		box("ftyp", []byte("crx \x00\x00\x00\x01crx isom")),
+		box("moov", box("uuid", canonUUID[:], box("CNCV", []byte("CanonCR3_001/01.09.00/00.00.00")), box("CMT1", cmt1), box("CMT2", cmt2))),
+	}, nil)
+
+	tags, err := ExifTags(bytes.NewReader(buf), int64(len(buf)), CR3)
+	require.NoError(t, err)
+
+	m := tagValues(tags)
+	assert.Equal(t, "Canon", m["Make"])
+	assert.Equal(t, "2022:01:30 16:00:01", m["DateTimeOriginal"])
+	assert.Equal(t, "25", m["SubSecTimeOriginal"])
+	assert.Equal(t, "012345678901", m["BodySerialNumber"])
+	assert.Equal(t, "RF24-105mm F4 L IS USM", m["LensModel"])
+}
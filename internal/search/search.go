@@ -0,0 +1,86 @@
+// Package search matches archived files against a query made up of terms such
+// as place:Melbourne.
+package search
+
+import (
+	"fmt"
+	"pt/internal/store"
+	"strings"
+)
+
+// Fields that can prefix a search term. Terms without a field match the file
+// path or description.
+const (
+	FieldPlace   = "place"
+	FieldDevice  = "device"
+	FieldAlbum   = "album"
+	FieldKeyword = "keyword"
+)
+
+// Query is a list of terms which all have to match a file.
+type Query struct {
+	terms []term
+}
+
+type term struct {
+	field string
+	value string
+}
+
+// Parse parses each of args as a term. A term is either field:value or text.
+func Parse(args []string) (Query, error) {
+	q := Query{}
+	for _, arg := range args {
+		t := term{value: arg}
+		if i := strings.Index(arg, ":"); i > 0 {
+			switch field := strings.ToLower(arg[:i]); field {
+			case FieldPlace, FieldDevice, FieldAlbum, FieldKeyword:
+				t = term{field: field, value: arg[i+1:]}
+			default:
+				return Query{}, fmt.Errorf("unknown search field %q", arg[:i])
+			}
+		}
+		if t.value == "" {
+			return Query{}, fmt.Errorf("empty search term %q", arg)
+		}
+		q.terms = append(q.terms, t)
+	}
+	return q, nil
+}
+
+// Match returns true if every term of q matches the archived file at
+// filepath with the meta values meta. Values are compared ignoring case.
+func (q Query) Match(filepath string, meta map[string]string) bool {
+	for _, t := range q.terms {
+		if !t.match(filepath, meta) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t term) match(filepath string, meta map[string]string) bool {
+	switch t.field {
+	case FieldPlace:
+		return anyEqual(t.value, meta[store.MetaCity], meta[store.MetaRegion], meta[store.MetaCountry])
+	case FieldDevice:
+		return anyEqual(t.value, meta[store.MetaDevice])
+	case FieldAlbum:
+		return anyEqual(t.value, meta[store.MetaAlbum])
+	case FieldKeyword:
+		return anyEqual(t.value, strings.Split(meta[store.MetaKeywords], ",")...)
+	}
+
+	value := strings.ToLower(t.value)
+	return strings.Contains(strings.ToLower(filepath), value) ||
+		strings.Contains(strings.ToLower(meta[store.MetaDescription]), value)
+}
+
+func anyEqual(s string, values ...string) bool {
+	for _, v := range values {
+		if v != "" && strings.EqualFold(s, strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
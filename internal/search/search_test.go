@@ -0,0 +1,56 @@
+package search
+
+import (
+	"pt/internal/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	meta := map[string]string{
+		store.MetaCity:        "Melbourne",
+		store.MetaRegion:      "Victoria",
+		store.MetaCountry:     "AU",
+		store.MetaDevice:      "phone",
+		store.MetaAlbum:       "Recents",
+		store.MetaKeywords:    "family,scanned",
+		store.MetaDescription: "Birthday party",
+	}
+	filepath := "2022/01/phone/Recents/20220102-030405006.JPG"
+
+	tests := []struct {
+		query  []string
+		expect bool
+	}{
+		{[]string{"place:melbourne"}, true},
+		{[]string{"place:Victoria"}, true},
+		{[]string{"place:au"}, true},
+		{[]string{"place:Sydney"}, false},
+		{[]string{"place:New York"}, false},
+		{[]string{"device:phone", "place:Melbourne"}, true},
+		{[]string{"device:camera", "place:Melbourne"}, false},
+		{[]string{"album:recents"}, true},
+		{[]string{"keyword:scanned"}, true},
+		{[]string{"keyword:scan"}, false},
+		{[]string{"birthday"}, true},
+		{[]string{"2022/01"}, true},
+		{[]string{"wedding"}, false},
+		{nil, true},
+	}
+	for _, test := range tests {
+		q, err := Parse(test.query)
+		require.NoError(t, err)
+		assert.Equal(t, test.expect, q.Match(filepath, meta), test.query)
+	}
+
+	assert.False(t, Query{terms: []term{{field: FieldPlace, value: "Melbourne"}}}.Match(filepath, nil))
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse([]string{"nope:value"})
+	assert.Error(t, err)
+	_, err = Parse([]string{"place:"})
+	assert.Error(t, err)
+}
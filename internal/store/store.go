@@ -0,0 +1,239 @@
+// Package store records archived files and their metadata in the pt
+// database.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"pt/internal/geo"
+	"pt/internal/model"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// Meta keys recorded for archived files.
+const (
+	// MetaTimestamp is the capture time of the file formatted with
+	// TimeFormat.
+	MetaTimestamp = "timestamp"
+	// MetaTimestampSource is where the timezone of MetaTimestamp came from.
+	MetaTimestampSource = "timestamp_source"
+	// MetaClockOffset is the total correction applied to MetaTimestamp for
+	// a wrong camera clock, formatted as a time.Duration.
+	MetaClockOffset = "clock_offset"
+	// MetaLatitude and MetaLongitude are the location of the file in
+	// decimal degrees.
+	MetaLatitude  = "latitude"
+	MetaLongitude = "longitude"
+	// MetaDescription is the description written to the file.
+	MetaDescription = "description"
+	// MetaKeywords is a comma separated list of keywords written to the
+	// file.
+	MetaKeywords = "keywords"
+	// MetaCountry, MetaRegion and MetaCity are the place the file was
+	// captured at, resolved from its location.
+	MetaCountry = "country"
+	MetaRegion  = "region"
+	MetaCity    = "city"
+	// MetaDevice and MetaAlbum are the device name and album the file was
+	// copied with.
+	MetaDevice = "device"
+	MetaAlbum  = "album"
+	// MetaSourcePath is the absolute path the file was copied from.
+	MetaSourcePath = "source_path"
+	// MetaEventID is the event the file was grouped into and MetaEventName
+	// the name given to that event.
+	MetaEventID   = "event_id"
+	MetaEventName = "event_name"
+)
+
+// TimeFormat is the format of timestamps stored in the meta table.
+const TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// RelPath returns p relative to destinationDir as stored in the hash table.
+func RelPath(destinationDir, p string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(p, destinationDir), string(os.PathSeparator))
+}
+
+// InsertHash inserts a row into the hash table for filepath. If the row
+// already exists, the existing row is returned.
+func InsertHash(ctx context.Context, exec boil.ContextExecutor, filepath, hash string) (*model.Hash, error) {
+	find := func() (*model.Hash, error) {
+		return model.Hashes(
+			model.HashWhere.Hash.EQ(hash),
+			model.HashWhere.Filepath.EQ(filepath),
+		).One(ctx, exec)
+	}
+
+	h, err := find()
+	if err == nil {
+		return h, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	h = &model.Hash{Hash: hash, Filepath: filepath}
+	if err := h.Insert(ctx, exec, boil.Infer()); err != nil {
+		// Another worker may have inserted the same row.
+		if isConstraintErr(err) {
+			return find()
+		}
+		return nil, err
+	}
+	return h, nil
+}
+
+// FindHashByFilepath returns the hash row for filepath. sql.ErrNoRows is
+// returned if there is no row.
+func FindHashByFilepath(ctx context.Context, exec boil.ContextExecutor, filepath string) (*model.Hash, error) {
+	return model.Hashes(model.HashWhere.Filepath.EQ(filepath)).One(ctx, exec)
+}
+
+// UpdateFilepath changes the filepath of the hash rows for oldFilepath to
+// newFilepath.
+func UpdateFilepath(ctx context.Context, exec boil.ContextExecutor, oldFilepath, newFilepath string) error {
+	_, err := model.Hashes(model.HashWhere.Filepath.EQ(oldFilepath)).UpdateAll(ctx, exec, model.M{
+		model.HashColumns.Filepath: newFilepath,
+	})
+	return err
+}
+
+// UpdateHash changes the hash of the hash rows for filepath to hash, used
+// after the file has been modified.
+func UpdateHash(ctx context.Context, exec boil.ContextExecutor, filepath, hash string) error {
+	_, err := model.Hashes(model.HashWhere.Filepath.EQ(filepath)).UpdateAll(ctx, exec, model.M{
+		model.HashColumns.Hash: hash,
+	})
+	return err
+}
+
+// SetMeta sets the meta value of key for the hash row hashID.
+func SetMeta(ctx context.Context, exec boil.ContextExecutor, hashID int64, key, value string) error {
+	metaKey, err := getOrInsertMetaKey(ctx, exec, key)
+	if err != nil {
+		return err
+	}
+
+	m := &model.Metum{HashID: hashID, MetaKeyID: metaKey.ID, Value: value}
+	return m.Upsert(ctx, exec, true,
+		[]string{model.MetumColumns.HashID, model.MetumColumns.MetaKeyID},
+		boil.Whitelist(model.MetumColumns.Value),
+		boil.Infer())
+}
+
+// DeleteMeta removes the meta value of key for the hash row hashID.
+func DeleteMeta(ctx context.Context, exec boil.ContextExecutor, hashID int64, key string) error {
+	metaKey, err := model.MetaKeys(model.MetaKeyWhere.KeyName.EQ(key)).One(ctx, exec)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = model.Meta(
+		model.MetumWhere.HashID.EQ(hashID),
+		model.MetumWhere.MetaKeyID.EQ(metaKey.ID),
+	).DeleteAll(ctx, exec)
+	return err
+}
+
+// SetMetaMap sets each key in m to its value for the hash row hashID.
+func SetMetaMap(ctx context.Context, exec boil.ContextExecutor, hashID int64, m map[string]string) error {
+	for k, v := range m {
+		if err := SetMeta(ctx, exec, hashID, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Meta returns the meta values of the hash row hashID keyed by meta key
+// name.
+func Meta(ctx context.Context, exec boil.ContextExecutor, hashID int64) (map[string]string, error) {
+	rows, err := model.Meta(
+		model.MetumWhere.HashID.EQ(hashID),
+		qm.Load(model.MetumRels.MetaKey),
+	).All(ctx, exec)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]string{}
+	for _, i := range rows {
+		if i.R != nil && i.R.MetaKey != nil {
+			m[i.R.MetaKey.KeyName] = i.Value
+		}
+	}
+	return m, nil
+}
+
+// AllMeta returns the meta values of every hash row keyed by hash row id and
+// then meta key name.
+func AllMeta(ctx context.Context, exec boil.ContextExecutor) (map[int64]map[string]string, error) {
+	rows, err := model.Meta(qm.Load(model.MetumRels.MetaKey)).All(ctx, exec)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[int64]map[string]string{}
+	for _, i := range rows {
+		if i.R == nil || i.R.MetaKey == nil {
+			continue
+		}
+		if m[i.HashID] == nil {
+			m[i.HashID] = map[string]string{}
+		}
+		m[i.HashID][i.R.MetaKey.KeyName] = i.Value
+	}
+	return m, nil
+}
+
+// LocationMeta returns the meta values for a file captured at p: its
+// coordinates and place. The place is empty if p is not near a city known to
+// g.
+func LocationMeta(g *geo.Gazetteer, p geo.Point) map[string]string {
+	c, _ := g.Place(p)
+	return map[string]string{
+		MetaLatitude:  fmt.Sprintf("%.6f", p.Latitude),
+		MetaLongitude: fmt.Sprintf("%.6f", p.Longitude),
+		MetaCountry:   c.Country,
+		MetaRegion:    c.Region,
+		MetaCity:      c.Name,
+	}
+}
+
+func getOrInsertMetaKey(ctx context.Context, exec boil.ContextExecutor, key string) (*model.MetaKey, error) {
+	find := func() (*model.MetaKey, error) {
+		return model.MetaKeys(model.MetaKeyWhere.KeyName.EQ(key)).One(ctx, exec)
+	}
+
+	metaKey, err := find()
+	if err == nil {
+		return metaKey, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	metaKey = &model.MetaKey{KeyName: key}
+	if err := metaKey.Insert(ctx, exec, boil.Infer()); err != nil {
+		if isConstraintErr(err) {
+			return find()
+		}
+		return nil, err
+	}
+	return metaKey, nil
+}
+
+func isConstraintErr(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint
+}
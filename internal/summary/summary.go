@@ -0,0 +1,217 @@
+// Package summary records the outcome of each file processed by a command so
+// a run can carry on past files that fail and report them at the end.
+package summary
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Class is the kind of failure processing a file.
+type Class string
+
+const (
+	ClassRead        Class = "read error"
+	ClassUnsupported Class = "unsupported"
+	ClassMetadata    Class = "metadata error"
+	ClassDestination Class = "destination error"
+)
+
+// Error is the failure of a single file. Errors that aren't an Error, such as
+// a database error, apply to the whole run.
+type Error struct {
+	Path  string
+	Class Class
+	Err   error
+}
+
+// Wrap returns err as an Error of class for the file at p, or nil if err is
+// nil.
+func Wrap(class Class, p string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Path: p, Class: class, Err: err}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Class, e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Summary counts the outcomes of files and records the files that failed. A
+// Summary is safe for concurrent use.
+type Summary struct {
+	mu       sync.Mutex
+	outcomes []string
+	counts   map[string]int
+	bytes    map[string]int64
+	failures []*Error
+}
+
+// New returns an empty Summary.
+func New() *Summary {
+	return &Summary{counts: map[string]int{}, bytes: map[string]int64{}}
+}
+
+// Add counts a file of size bytes with outcome (eg; copied).
+func (s *Summary) Add(outcome string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.counts[outcome]; !ok {
+		s.outcomes = append(s.outcomes, outcome)
+	}
+	s.counts[outcome]++
+	s.bytes[outcome] += size
+}
+
+// Fail records err and returns true if it is an Error, otherwise it is left
+// to the caller to handle.
+func (s *Summary) Fail(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, e)
+	return true
+}
+
+// Failures returns the files that failed, in the order they failed.
+func (s *Summary) Failures() []*Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Error{}, s.failures...)
+}
+
+// Print writes the failures followed by the number of files of each outcome
+// and class of failure to w.
+func (s *Summary) Print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.failures {
+		fmt.Fprintln(w, e)
+	}
+
+	line := ""
+	for _, outcome := range s.outcomes {
+		line += fmt.Sprintf("%s%s %d", separator(line), outcome, s.counts[outcome])
+	}
+	if len(s.failures) > 0 {
+		classes := map[Class]int{}
+		for _, e := range s.failures {
+			classes[e.Class]++
+		}
+		names := []string{}
+		for class := range classes {
+			names = append(names, string(class))
+		}
+		sort.Strings(names)
+
+		line += fmt.Sprintf("%sfailed %d (", separator(line), len(s.failures))
+		for i, name := range names {
+			if i > 0 {
+				line += ", "
+			}
+			line += fmt.Sprintf("%s %d", name, classes[Class(name)])
+		}
+		line += ")"
+	}
+	if line != "" {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func separator(line string) string {
+	if line == "" {
+		return ""
+	}
+	return ", "
+}
+
+// WriteFailedPaths writes the paths of the files that failed to p, one per
+// line, creating the directory of p if needed.
+func (s *Summary) WriteFailedPaths(p string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	fh, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	for _, e := range s.Failures() {
+		if _, err := fmt.Fprintln(fh, e.Path); err != nil {
+			fh.Close()
+			return err
+		}
+	}
+	return fh.Close()
+}
+
+// Err returns an error if any file failed.
+func (s *Summary) Err() error {
+	if n := len(s.Failures()); n > 0 {
+		return fmt.Errorf("%d files failed", n)
+	}
+	return nil
+}
+
+// Report is the summary of a run written as JSON for scripts to read.
+type Report struct {
+	Command         string    `json:"command"`
+	Started         time.Time `json:"started"`
+	Finished        time.Time `json:"finished"`
+	DurationSeconds float64   `json:"duration_seconds"`
+
+	// Counts and Bytes are the number of files and bytes of each outcome.
+	Counts map[string]int   `json:"counts,omitempty"`
+	Bytes  map[string]int64 `json:"bytes,omitempty"`
+
+	Failures    []Failure `json:"failures,omitempty"`
+	FailedPaths string    `json:"failed_paths,omitempty"`
+
+	// Skipped is the number of entries skipped walking directories for
+	// each reason.
+	Skipped map[string]int `json:"skipped,omitempty"`
+
+	// Interrupted is the signal that stopped the run early, the counts are
+	// of the files finished before it stopped.
+	Interrupted string `json:"interrupted,omitempty"`
+
+	// Error is the error the run failed with.
+	Error string `json:"error,omitempty"`
+}
+
+// Failure is a file that failed in a Report.
+type Failure struct {
+	Path  string `json:"path"`
+	Class Class  `json:"class"`
+	Error string `json:"error"`
+}
+
+// Fill sets the counts, bytes and failures of r from s.
+func (s *Summary) Fill(r *Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Counts = map[string]int{}
+	r.Bytes = map[string]int64{}
+	for outcome, n := range s.counts {
+		r.Counts[outcome] = n
+		r.Bytes[outcome] = s.bytes[outcome]
+	}
+	r.Failures = []Failure{}
+	for _, e := range s.failures {
+		r.Failures = append(r.Failures, Failure{Path: e.Path, Class: e.Class, Error: e.Err.Error()})
+	}
+}
@@ -0,0 +1,50 @@
+package summary
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	s := New()
+	s.Add("copied", 100)
+	s.Add("copied", 50)
+	s.Add("exists", 10)
+	assert.NoError(t, s.Err())
+
+	errIO := errors.New("input/output error")
+	assert.True(t, s.Fail(Wrap(ClassRead, "/media/card/a.jpg", errIO)))
+	assert.True(t, s.Fail(fmt.Errorf("copying: %w", Wrap(ClassDestination, "/media/card/b.jpg", errIO))))
+	assert.True(t, s.Fail(Wrap(ClassRead, "/media/card/c.jpg", errIO)))
+	assert.False(t, s.Fail(errors.New("database is locked")))
+	assert.Nil(t, Wrap(ClassRead, "/media/card/d.jpg", nil))
+	assert.ErrorIs(t, s.Failures()[0], errIO)
+	assert.EqualError(t, s.Err(), "3 files failed")
+
+	buf := &bytes.Buffer{}
+	s.Print(buf)
+	assert.Equal(t, `read error /media/card/a.jpg: input/output error
+destination error /media/card/b.jpg: input/output error
+read error /media/card/c.jpg: input/output error
+copied 2, exists 1, failed 3 (destination error 1, read error 2)
+`, buf.String())
+
+	p := filepath.Join(t.TempDir(), "failed", "copy.txt")
+	require.NoError(t, s.WriteFailedPaths(p))
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "/media/card/a.jpg\n/media/card/b.jpg\n/media/card/c.jpg\n", string(b))
+
+	r := Report{}
+	s.Fill(&r)
+	assert.Equal(t, map[string]int{"copied": 2, "exists": 1}, r.Counts)
+	assert.Equal(t, map[string]int64{"copied": 150, "exists": 10}, r.Bytes)
+	assert.Equal(t, Failure{Path: "/media/card/b.jpg", Class: ClassDestination, Error: "input/output error"}, r.Failures[1])
+}
@@ -0,0 +1,258 @@
+// Package tag writes metadata into media files. JPEGs are modified in place
+// by updating their exif data and embedded XMP packet, other files get their
+// metadata written to an XMP sidecar.
+package tag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"pt/internal/jpegmeta"
+	"pt/internal/xmp"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// Options control how Write modifies files.
+type Options struct {
+	// BackupDir is where the original of a modified file is copied before
+	// it is changed, below a directory tree matching the files absolute
+	// path. No backups are made if BackupDir is empty.
+	BackupDir string
+
+	// Sidecar writes an XMP sidecar for JPEGs instead of modifying them.
+	Sidecar bool
+}
+
+// Write writes props into the file p and returns the path of the file that
+// was modified, which is either p or its XMP sidecar.
+func Write(p string, props xmp.Properties, opts Options) (string, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, 3)
+	_, err = io.ReadFull(fh, head)
+	fh.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Sidecar || head[0] != 0xff || head[1] != 0xd8 || head[2] != 0xff {
+		return writeSidecar(p, props, opts)
+	}
+	return p, writeJPEG(p, props, opts)
+}
+
+// writeSidecar merges props into the XMP sidecar of p.
+func writeSidecar(p string, props xmp.Properties, opts Options) (string, error) {
+	sidecarPath := xmp.SidecarPath(p)
+	current, err := ioutil.ReadFile(sidecarPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	existing, err := xmp.Parse(bytes.NewReader(current))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", sidecarPath, err)
+	}
+
+	packet, err := existing.Merge(props).Update(current)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", sidecarPath, err)
+	}
+
+	if err := backup(sidecarPath, opts.BackupDir); err != nil {
+		return "", err
+	}
+	return sidecarPath, writeFile(sidecarPath, packet)
+}
+
+// writeJPEG writes props into the exif data and XMP packet of the JPEG p.
+func writeJPEG(p string, props xmp.Properties, opts Options) error {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	exifData, err := jpegmeta.Exif(data)
+	if err != nil {
+		return err
+	}
+	if exifData, err = buildExif(exifData, props); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	if data, err = jpegmeta.SetExif(data, exifData); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	// The properties of the existing packet are updated, keeping those
+	// written by other programs. An unreadable packet is replaced.
+	current, err := jpegmeta.XMP(data)
+	if err != nil {
+		current = nil
+	}
+	existing, err := xmp.Parse(bytes.NewReader(current))
+	if err != nil {
+		existing, current = xmp.Properties{}, nil
+	}
+	packet, err := existing.Merge(props).Update(current)
+	if err != nil {
+		if packet, err = existing.Merge(props).Marshal(); err != nil {
+			return err
+		}
+	}
+	if data, err = jpegmeta.SetXMP(data, packet); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	if err := backup(p, opts.BackupDir); err != nil {
+		return err
+	}
+	return writeFile(p, data)
+}
+
+// buildExif returns exifData with props set. New exif data is created if
+// exifData is nil.
+func buildExif(exifData []byte, props xmp.Properties) ([]byte, error) {
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return nil, err
+	}
+	ti := exif.NewTagIndex()
+
+	var rootIb *exif.IfdBuilder
+	if exifData == nil {
+		rootIb = exif.NewIfdBuilder(im, ti, exifcommon.IfdStandardIfdIdentity, exifcommon.EncodeDefaultByteOrder)
+	} else {
+		_, index, err := exif.Collect(im, ti, exifData)
+		if err != nil {
+			return nil, err
+		}
+		rootIb = exif.NewIfdBuilderFromExistingChain(index.RootIfd)
+	}
+
+	if props.Description != "" {
+		if err := rootIb.SetStandardWithName("ImageDescription", props.Description); err != nil {
+			return nil, err
+		}
+	}
+
+	if t := props.DateTimeOriginal; !t.IsZero() {
+		exifIb, err := exif.GetOrCreateIbFromRootIb(rootIb, exifcommon.IfdExifStandardIfdIdentity.String())
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range map[string]string{
+			"DateTimeOriginal":   t.Format("2006:01:02 15:04:05"),
+			"SubSecTimeOriginal": fmt.Sprintf("%03d", t.Nanosecond()/1e6),
+			"OffsetTimeOriginal": t.Format("-07:00"),
+		} {
+			if err := exifIb.SetStandardWithName(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if props.GPS != nil {
+		gpsIb, err := exif.GetOrCreateIbFromRootIb(rootIb, exifcommon.IfdGpsInfoStandardIfdIdentity.String())
+		if err != nil {
+			return nil, err
+		}
+		latRef, lonRef := "N", "E"
+		if props.GPS.Latitude < 0 {
+			latRef = "S"
+		}
+		if props.GPS.Longitude < 0 {
+			lonRef = "W"
+		}
+		for name, value := range map[string]interface{}{
+			"GPSVersionID":    []uint8{2, 2, 0, 0},
+			"GPSLatitudeRef":  latRef,
+			"GPSLatitude":     degreesToRationals(props.GPS.Latitude),
+			"GPSLongitudeRef": lonRef,
+			"GPSLongitude":    degreesToRationals(props.GPS.Longitude),
+		} {
+			if err := gpsIb.SetStandardWithName(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return exif.NewIfdByteEncoder().EncodeToExif(rootIb)
+}
+
+// degreesToRationals converts decimal degrees to exif degrees, minutes and
+// seconds.
+func degreesToRationals(degrees float64) []exifcommon.Rational {
+	degrees = math.Abs(degrees)
+	d := math.Floor(degrees)
+	m := math.Floor((degrees - d) * 60)
+	s := (degrees - d - m/60) * 3600
+	return []exifcommon.Rational{
+		{Numerator: uint32(d), Denominator: 1},
+		{Numerator: uint32(m), Denominator: 1},
+		{Numerator: uint32(math.Round(s * 10000)), Denominator: 10000},
+	}
+}
+
+// backup copies p to its path below backupDir. Nothing is done if backupDir
+// is empty or p does not exist.
+func backup(p, backupDir string) error {
+	if backupDir == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	backupPath := filepath.Join(backupDir, abs)
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(backupPath, data, 0600)
+}
+
+// writeFile replaces p with data by writing to a temporary file in the same
+// directory and renaming it over p.
+func writeFile(p string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(p); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".pt-tag-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
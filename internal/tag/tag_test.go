@@ -0,0 +1,155 @@
+package tag
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pt/internal/geo"
+	"pt/internal/jpegmeta"
+	"pt/internal/jpegmeta/jpegmetatest"
+	"pt/internal/xmp"
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var jfif = jpegmetatest.JFIF()
+
+func testProperties() xmp.Properties {
+	return xmp.Properties{
+		DateTimeOriginal: time.Date(1987, 6, 1, 12, 30, 0, 0, time.FixedZone("", 10*3600)),
+		GPS:              &geo.Point{Latitude: -37.8136, Longitude: 144.9631},
+		Description:      "Birthday",
+		Keywords:         []string{"family"},
+	}
+}
+
+func TestWriteJPEG(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "scan.jpg")
+	require.NoError(t, ioutil.WriteFile(p, jfif, 0644))
+
+	written, err := Write(p, testProperties(), Options{BackupDir: filepath.Join(dir, "backup")})
+	require.NoError(t, err)
+	assert.Equal(t, p, written)
+
+	backup, err := ioutil.ReadFile(filepath.Join(dir, "backup", p))
+	require.NoError(t, err)
+	assert.Equal(t, jfif, backup)
+
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	exifData, err := jpegmeta.Exif(data)
+	require.NoError(t, err)
+	entries, _, err := exif.GetFlatExifData(exifData, nil)
+	require.NoError(t, err)
+
+	tags := map[string]string{}
+	for _, e := range entries {
+		tags[e.TagName] = e.Formatted
+	}
+	assert.Equal(t, "1987:06:01 12:30:00", tags["DateTimeOriginal"])
+	assert.Equal(t, "+10:00", tags["OffsetTimeOriginal"])
+	assert.Equal(t, "Birthday", tags["ImageDescription"])
+	assert.Equal(t, "S", tags["GPSLatitudeRef"])
+	assert.Equal(t, "E", tags["GPSLongitudeRef"])
+
+	packet, err := jpegmeta.XMP(data)
+	require.NoError(t, err)
+	require.NotNil(t, packet)
+
+	// Tagging again keeps what was written before.
+	_, err = Write(p, xmp.Properties{Keywords: []string{"scanned"}}, Options{})
+	require.NoError(t, err)
+	data, err = ioutil.ReadFile(p)
+	require.NoError(t, err)
+	exifData, err = jpegmeta.Exif(data)
+	require.NoError(t, err)
+	entries, _, err = exif.GetFlatExifData(exifData, nil)
+	require.NoError(t, err)
+	found := false
+	for _, e := range entries {
+		if e.TagName == "DateTimeOriginal" {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestWriteSidecar(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "IMG_0001.HEIC")
+	require.NoError(t, ioutil.WriteFile(p, []byte("not a jpeg"), 0644))
+
+	written, err := Write(p, testProperties(), Options{})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "IMG_0001.xmp"), written)
+
+	_, err = Write(p, xmp.Properties{Keywords: []string{"scanned"}}, Options{})
+	require.NoError(t, err)
+
+	props, err := xmp.ReadSidecar(written)
+	require.NoError(t, err)
+	assert.Equal(t, "Birthday", props.Description)
+	assert.ElementsMatch(t, []string{"family", "scanned"}, props.Keywords)
+	assert.True(t, props.DateTimeOriginal.Equal(testProperties().DateTimeOriginal))
+
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "not a jpeg", string(data))
+	_, err = os.Stat(filepath.Join(dir, "backup"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteSidecarKeepsOtherProperties(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "IMG_0001.CR3")
+	require.NoError(t, ioutil.WriteFile(p, []byte("raw"), 0644))
+	sidecar := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:crs="http://ns.adobe.com/camera-raw-settings/1.0/"
+    xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+    xmp:Rating="5" crs:WhiteBalance="As Shot">
+   <Iptc4xmpCore:Location>Fitzroy</Iptc4xmpCore:Location>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "IMG_0001.xmp"), []byte(sidecar), 0644))
+
+	written, err := Write(p, testProperties(), Options{})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "IMG_0001.xmp"), written)
+
+	data, err := ioutil.ReadFile(written)
+	require.NoError(t, err)
+	for _, s := range []string{`xmp:Rating="5"`, `crs:WhiteBalance="As Shot"`, "<Iptc4xmpCore:Location>Fitzroy</Iptc4xmpCore:Location>"} {
+		assert.Contains(t, string(data), s)
+	}
+	props, err := xmp.ReadSidecar(written)
+	require.NoError(t, err)
+	assert.Equal(t, "Birthday", props.Description)
+
+	// The JPEG of a RAW+JPEG pair gets a sidecar of its own.
+	jpeg := filepath.Join(dir, "IMG_0001.JPG")
+	require.NoError(t, ioutil.WriteFile(jpeg, jfif, 0644))
+	written, err = Write(jpeg, xmp.Properties{Description: "Other"}, Options{Sidecar: true})
+	require.NoError(t, err)
+	assert.Equal(t, jpeg+".xmp", written)
+	props, err = xmp.ReadSidecar(filepath.Join(dir, "IMG_0001.xmp"))
+	require.NoError(t, err)
+	assert.Equal(t, "Birthday", props.Description)
+}
+
+func TestDegreesToRationals(t *testing.T) {
+	assert.Equal(t, []exifcommon.Rational{
+		{Numerator: 37, Denominator: 1},
+		{Numerator: 48, Denominator: 1},
+		{Numerator: 489600, Denominator: 10000},
+	}, degreesToRationals(-37.8136))
+}
@@ -0,0 +1,276 @@
+// Package walk walks the directory trees given to commands, applying the
+// same error, symlink, filesystem and filter policies for every command and
+// keeping a record of the entries it skipped.
+package walk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pt/internal/filter"
+	"sort"
+	"strings"
+)
+
+// ErrorPolicy is what a Walker does when a file or directory can't be read.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicySkip skips entries that can't be read, recording them as
+	// skipped with ReasonError.
+	ErrorPolicySkip ErrorPolicy = "skip"
+
+	// ErrorPolicyAbort stops the walk returning the error.
+	ErrorPolicyAbort ErrorPolicy = "abort"
+)
+
+// ParseErrorPolicy parses s as an ErrorPolicy.
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	switch p := ErrorPolicy(strings.ToLower(s)); p {
+	case ErrorPolicySkip, ErrorPolicyAbort:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid error policy %q, expected skip or abort", s)
+}
+
+// Reason is why an entry was skipped.
+type Reason string
+
+// Reasons entries are skipped for. Hidden files and .thumbnails directories
+// are skipped without being recorded.
+const (
+	ReasonError       Reason = "error"
+	ReasonNotRegular  Reason = "not a regular file"
+	ReasonSymlink     Reason = "symlink"
+	ReasonSymlinkLoop Reason = "symlink loop"
+	ReasonOtherFS     Reason = "other filesystem"
+	ReasonIgnored     Reason = "ignored"
+	ReasonExcluded    Reason = "excluded"
+)
+
+// Skipped is an entry skipped by a Walker.
+type Skipped struct {
+	Path   string
+	Reason Reason
+	Err    error
+}
+
+// Options are the policies of a Walker. The zero Options skip entries that
+// can't be read, don't follow symlinks, cross filesystems and select every
+// file.
+type Options struct {
+	OnError ErrorPolicy
+
+	// FollowSymlinks walks the targets of symlinks. Symlinks to a directory
+	// being walked are skipped with ReasonSymlinkLoop.
+	FollowSymlinks bool
+
+	// OneFileSystem skips directories on a different filesystem to the
+	// root being walked.
+	OneFileSystem bool
+
+	// Filter selects the files passed to the walk function. Capture times
+	// and media types are not checked as they are read from the file,
+	// callers check them with Filter.MatchTime and Filter.MatchMediaType
+	// when they read it.
+	Filter filter.Filter
+}
+
+// Walker walks directory trees. A Walker is not safe for concurrent use.
+type Walker struct {
+	opts    Options
+	skipped []Skipped
+}
+
+// New returns a Walker with opts.
+func New(opts Options) *Walker {
+	if opts.OnError == "" {
+		opts.OnError = ErrorPolicySkip
+	}
+	return &Walker{opts: opts}
+}
+
+// Skipped returns the entries skipped by every walk of w.
+func (w *Walker) Skipped() []Skipped {
+	return w.skipped
+}
+
+// Counts returns the number of skipped entries by reason.
+func (w *Walker) Counts() map[Reason]int {
+	counts := map[Reason]int{}
+	for _, s := range w.skipped {
+		counts[s.Reason]++
+	}
+	return counts
+}
+
+// walk is the state of a single walk.
+type walk struct {
+	root    string
+	device  uint64
+	ignores map[string]filter.Ignore
+	fn      func(p string, info os.FileInfo) error
+}
+
+// Walk walks the tree at root in lexical order, calling fn for each regular
+// file selected by the options of w. Symlinks given as root are always
+// followed. Errors returned by fn stop the walk and are returned, as is an
+// error for an empty root rather than walking the working directory.
+func (w *Walker) Walk(root string, fn func(p string, info os.FileInfo) error) error {
+	if root == "" {
+		return errors.New("no path to walk")
+	}
+	root = filepath.Clean(root)
+	info, err := os.Stat(root)
+	if err != nil {
+		return w.error(root, err)
+	}
+
+	wk := &walk{root: root, ignores: map[string]filter.Ignore{}, fn: fn}
+	if device, ok := deviceID(info); ok {
+		wk.device = device
+	}
+	if !info.IsDir() {
+		return w.file(wk, root, info)
+	}
+	return w.dir(wk, root, info, nil)
+}
+
+// error records err reading p or returns it, depending on the error policy.
+func (w *Walker) error(p string, err error) error {
+	if w.opts.OnError == ErrorPolicyAbort {
+		return err
+	}
+	w.skip(p, ReasonError, err)
+	return nil
+}
+
+func (w *Walker) skip(p string, reason Reason, err error) {
+	w.skipped = append(w.skipped, Skipped{Path: p, Reason: reason, Err: err})
+}
+
+// dir walks the directory p. parents are the ids of the directories above p,
+// used to detect symlink loops.
+func (w *Walker) dir(wk *walk, p string, info os.FileInfo, parents []fileID) error {
+	if id, ok := getFileID(info); ok {
+		for _, parent := range parents {
+			if parent == id {
+				w.skip(p, ReasonSymlinkLoop, nil)
+				return nil
+			}
+		}
+		parents = append(parents, id)
+	}
+
+	ignore, err := filter.ReadIgnore(p)
+	if err != nil {
+		return w.error(p, err)
+	}
+	wk.ignores[p] = ignore
+
+	f, err := os.Open(p)
+	if err != nil {
+		return w.error(p, err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		if err := w.error(p, err); err != nil {
+			return err
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := w.entry(wk, filepath.Join(p, name), parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry walks p, a file or directory found within a directory being walked.
+func (w *Walker) entry(wk *walk, p string, parents []fileID) error {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return w.error(p, err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !w.opts.FollowSymlinks {
+			w.skip(p, ReasonSymlink, nil)
+			return nil
+		}
+		if info, err = os.Stat(p); err != nil {
+			return w.error(p, err)
+		}
+	}
+
+	name := info.Name()
+	if info.IsDir() {
+		if strings.ToLower(name) == ".thumbnails" {
+			return nil
+		}
+		if w.opts.OneFileSystem {
+			if device, ok := deviceID(info); ok && device != wk.device {
+				w.skip(p, ReasonOtherFS, nil)
+				return nil
+			}
+		}
+		if wk.ignored(p, true) {
+			w.skip(p, ReasonIgnored, nil)
+			return nil
+		}
+		if !w.opts.Filter.MatchDir(wk.rel(p)) {
+			w.skip(p, ReasonExcluded, nil)
+			return nil
+		}
+		return w.dir(wk, p, info, parents)
+	}
+
+	if strings.HasPrefix(name, ".") {
+		return nil
+	}
+	if wk.ignored(p, false) {
+		w.skip(p, ReasonIgnored, nil)
+		return nil
+	}
+	return w.file(wk, p, info)
+}
+
+// file calls the walk function for the file p if it is selected.
+func (w *Walker) file(wk *walk, p string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		w.skip(p, ReasonNotRegular, nil)
+		return nil
+	}
+	if !w.opts.Filter.MatchFile(wk.rel(p), info) {
+		w.skip(p, ReasonExcluded, nil)
+		return nil
+	}
+	return wk.fn(p, info)
+}
+
+// rel returns p relative to the root of the walk, or the name of the root
+// when the root is a file.
+func (wk *walk) rel(p string) string {
+	rel, err := filepath.Rel(wk.root, p)
+	if err != nil || rel == "." {
+		return filepath.Base(p)
+	}
+	return rel
+}
+
+// ignored returns true if p is matched by the .ptignore file of one of the
+// directories between the root and p.
+func (wk *walk) ignored(p string, isDir bool) bool {
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		if ignore, ok := wk.ignores[dir]; ok && ignore.Match(p, isDir) {
+			return true
+		}
+		if dir == wk.root || dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
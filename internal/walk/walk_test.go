@@ -0,0 +1,111 @@
+package walk
+
+import (
+	"os"
+	"path/filepath"
+	"pt/internal/filter"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for p, content := range files {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func walkRel(t *testing.T, w *Walker, root string) ([]string, error) {
+	found := []string{}
+	err := w.Walk(root, func(p string, info os.FileInfo) error {
+		rel, err := filepath.Rel(root, p)
+		found = append(found, filepath.ToSlash(rel))
+		return err
+	})
+	return found, err
+}
+
+func TestWalkFilter(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"DCIM/100CANON/IMG_0001.JPG":     "1234567890",
+		"DCIM/100CANON/IMG_0002.JPG":     "12",
+		"DCIM/100CANON/.hidden.JPG":      "1234567890",
+		"DCIM/.thumbnails/IMG_0001.JPG":  "1234567890",
+		"DCIM/Screenshots/IMG_0003.PNG":  "1234567890",
+		"DCIM/Trash/IMG_0004.JPG":        "1234567890",
+		"DCIM/100CANON/IMG_0005.tmp.JPG": "1234567890",
+		"Android/data/cache.JPG":         "1234567890",
+		".ptignore":                      "# not photos\nAndroid/\n*.tmp.*\n",
+		"DCIM/.ptignore":                 "Trash\n",
+	})
+
+	exclude, err := filter.ParsePattern("Screenshots")
+	require.NoError(t, err)
+	w := New(Options{Filter: filter.Filter{Exclude: []filter.Pattern{exclude}, MinSize: 5}})
+
+	found, err := walkRel(t, w, root+string(os.PathSeparator))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DCIM/100CANON/IMG_0001.JPG"}, found)
+	assert.Equal(t, map[Reason]int{ReasonIgnored: 3, ReasonExcluded: 2}, w.Counts())
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	writeFiles(t, root, map[string]string{"a/IMG_0001.JPG": "1"})
+	writeFiles(t, other, map[string]string{"IMG_0002.JPG": "2"})
+	require.NoError(t, os.Symlink(root, filepath.Join(root, "a", "loop")))
+	require.NoError(t, os.Symlink(other, filepath.Join(root, "other")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "broken")))
+
+	w := New(Options{})
+	found, err := walkRel(t, w, root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/IMG_0001.JPG"}, found)
+	assert.Equal(t, map[Reason]int{ReasonSymlink: 3}, w.Counts())
+
+	w = New(Options{FollowSymlinks: true})
+	found, err = walkRel(t, w, root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/IMG_0001.JPG", "other/IMG_0002.JPG"}, found)
+	assert.Equal(t, map[Reason]int{ReasonSymlinkLoop: 1, ReasonError: 1}, w.Counts())
+
+	w = New(Options{FollowSymlinks: true, OnError: ErrorPolicyAbort})
+	_, err = walkRel(t, w, root)
+	assert.Error(t, err)
+}
+
+func TestWalkErrors(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not checked for root")
+	}
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a/IMG_0001.JPG": "1", "b/IMG_0002.JPG": "2"})
+	require.NoError(t, os.Chmod(filepath.Join(root, "a"), 0))
+	defer os.Chmod(filepath.Join(root, "a"), 0755)
+
+	w := New(Options{OnError: ErrorPolicySkip})
+	found, err := walkRel(t, w, root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/IMG_0002.JPG"}, found)
+	require.Len(t, w.Skipped(), 1)
+	assert.Equal(t, filepath.Join(root, "a"), w.Skipped()[0].Path)
+
+	w = New(Options{OnError: ErrorPolicyAbort})
+	_, err = walkRel(t, w, root)
+	assert.Error(t, err)
+
+	w = New(Options{})
+	_, err = walkRel(t, w, filepath.Join(root, "missing"))
+	require.NoError(t, err)
+	assert.Equal(t, map[Reason]int{ReasonError: 1}, w.Counts())
+}
+
+func TestWalkEmptyRoot(t *testing.T) {
+	_, err := walkRel(t, New(Options{}), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package walk
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file within the system.
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+func getFileID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+// deviceID returns the id of the filesystem info is on.
+func deviceID(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
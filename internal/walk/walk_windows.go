@@ -0,0 +1,19 @@
+//go:build windows
+
+package walk
+
+import "os"
+
+// fileID identifies a file within the system. File ids are not available
+// from os.FileInfo on Windows so symlink loops are not detected and
+// OneFileSystem has no effect.
+type fileID struct{}
+
+func getFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// deviceID returns the id of the filesystem info is on.
+func deviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
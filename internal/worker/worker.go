@@ -2,81 +2,428 @@ package worker
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"pt/internal/eventstream"
 	"pt/internal/file"
 	"pt/internal/fileutil"
+	"pt/internal/filter"
+	"pt/internal/geo"
+	"pt/internal/iolimit"
 	"pt/internal/logwrap"
+	"pt/internal/progress"
+	"pt/internal/store"
+	"pt/internal/summary"
+	"pt/internal/walk"
 	"strings"
+	"text/template"
+	"time"
 )
 
-// Copier accepts a channel of file.File and copies files sent to the channel
-// to destinationDir. If checkDuplicatesDB is set, the file will be checked to
-// see if it exists in the hash table and if so, the file will be skipped.
-func Copier(ctx context.Context, destinationDir string, deviceNames map[string][]string, c chan file.File, checkDuplicates bool) error {
+// CopierConfig holds the settings shared by Copier workers.
+type CopierConfig struct {
+	// DB is where copied files and their metadata are recorded. Nothing is
+	// recorded if DB is nil.
+	DB *sql.DB
+
+	DestinationDir string
+
+	// DeviceRules resolve the device name of each file.
+	DeviceRules file.DeviceRules
+
+	// DeviceLocations maps device names to the timezone of their clock.
+	DeviceLocations map[string]*time.Location
+
+	// Location is the timezone of the clock of every file, overriding
+	// DeviceLocations, if it is not nil.
+	Location *time.Location
+
+	// TimeShift is added to the timestamp of every file.
+	TimeShift time.Duration
+
+	// ClockOffsets are added to the timestamps of files from devices with
+	// wrong clocks.
+	ClockOffsets file.ClockOffsets
+
+	// PathLayout is the layout of the directories files are copied to. The
+	// default layout is used if it is nil.
+	PathLayout *template.Template
+
+	// Filter skips files captured outside of its time range, after clock
+	// offsets are applied.
+	Filter filter.Filter
+
+	// CheckDuplicates skips files that already exist within the destination
+	// month directory.
+	CheckDuplicates bool
+
+	// RemoveSource removes each file once it is copied, recorded and found
+	// in the destination, moving it there along with its sidecars. Files
+	// that aren't copied are kept.
+	RemoveSource bool
+
+	// Summary counts the outcome of each file if it is not nil.
+	Summary *summary.Summary
+
+	// KeepGoing records files that fail in Summary, which must not be nil,
+	// instead of stopping.
+	KeepGoing bool
+
+	// Limiter limits the number of files read at once from each source
+	// device. Reads aren't limited if it is nil.
+	Limiter *iolimit.Limiter
+
+	// Progress is told of each file processed if it is not nil.
+	Progress *progress.Reporter
+
+	// Events receives an event for each file copied, skipped as a
+	// duplicate or failed if it is not nil.
+	Events *eventstream.Stream
+}
+
+// Job is a file planned to be copied by Prober.
+type Job struct {
+	File                file.File
+	DestinationFilePath string
+
+	// Meta is recorded for the copied file in CopierConfig.DB.
+	Meta map[string]string
+}
+
+// Outcomes of files counted in CopierConfig.Summary.
+const (
+	OutcomeCopied    = "copied"
+	OutcomeMoved     = "moved"
+	OutcomeExists    = "exists"
+	OutcomeDuplicate = "duplicate"
+)
+
+// Prober accepts a channel of file.File, reads the metadata of each file to
+// find where it is copied to and sends the files to copy to jobs. If
+// cfg.CheckDuplicates is set, the file will be checked to see if it exists in
+// the destination and if so, the file will be skipped. If cfg.KeepGoing is
+// set, files that fail are recorded in cfg.Summary and the remaining files
+// are still probed.
+func Prober(ctx context.Context, cfg CopierConfig, c <-chan file.File, jobs chan<- Job) error {
 	logger := logwrap.Get("pt")
 	if logger == nil {
 		return fmt.Errorf("Unable to get pt logger")
 	}
 	for f := range c {
-		supported, err := file.IsSupportedFileType(f.OriginalFilePath)
-		if err != nil && err != fileutil.ErrUnknownFileType {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		if !supported {
+		cfg.Progress.Begin(f.OriginalFilePath)
+		job, outcome, err := probeFile(ctx, cfg, logger, f)
+		if job == nil {
+			if err := cfg.finish(logger, f, outcome, err); err != nil {
+				return err
+			}
 			continue
 		}
+		select {
+		case jobs <- *job:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
 
-		deviceName := file.DeviceName(deviceNames, f.OriginalFilePath)
-		destinationFilePath := f.DestinationFilePath(destinationDir, deviceName, f.Timestamp())
-
-		// Find files within destinationDir/deviceName that match the
-		// destinationFilePath. If there are duplicates, check the size of the
-		// file and if they match, skip copying this file.
-		duplicateFound := false
-		if checkDuplicates {
-
-			// yearMonth is a slice of the year and month from the
-			// destinationFilePath. This will be used by monthDir to build the
-			// directory path where filepath.Walk() will scan for duplicate
-			// files.
-			yearMonth := strings.Split(
-				strings.TrimPrefix(destinationFilePath, destinationDir),
-				string(os.PathSeparator))[1:3]
-			monthDir := filepath.Join(destinationDir, yearMonth[0], yearMonth[1])
-
-			// Walk the monthDir path looking for files that match the
-			// destinationFilePath filename and size of the file to copy. If a
-			// match is found, set duplicateFound and break out of
-			// filepath.Walk().
-			err := filepath.Walk(monthDir, func(p string, info os.FileInfo, err error) error {
-				if !info.Mode().IsRegular() {
+// Copier accepts a channel of Job and copies the files sent to the channel
+// to their destination, recording them in cfg.DB. If cfg.KeepGoing is set,
+// files that fail are recorded in cfg.Summary and the remaining files are
+// still copied. Once ctx is cancelled no more files are started, but a copy
+// in progress is finished and recorded.
+func Copier(ctx context.Context, cfg CopierConfig, c <-chan Job) error {
+	logger := logwrap.Get("pt")
+	if logger == nil {
+		return fmt.Errorf("Unable to get pt logger")
+	}
+	for job := range c {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cfg.Progress.Begin(job.File.OriginalFilePath)
+		outcome, err := copyFile(ctx, cfg, logger, job)
+		if err := cfg.finish(logger, job.File, outcome, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish counts f with outcome in cfg.Summary and cfg.Progress, as skipped
+// if it has no outcome. err is returned unless cfg.KeepGoing is set and err
+// is the failure of f, which is recorded instead.
+func (cfg CopierConfig) finish(logger *logwrap.LogWrap, f file.File, outcome string, err error) error {
+	var size int64
+	if f.FileInfo != nil {
+		size = f.FileInfo.Size()
+	}
+
+	if err != nil {
+		cfg.Progress.Done(progress.OutcomeFailed, size)
+		cfg.Events.Emit(eventstream.ErrorEvent(f.OriginalFilePath, err))
+		if cfg.KeepGoing && cfg.Summary.Fail(err) {
+			logger.Warning("file failed", "path", f.OriginalFilePath, "error", err)
+			return nil
+		}
+		logger.Error("file failed", "path", f.OriginalFilePath, "error", err)
+		return err
+	}
+
+	if outcome == "" {
+		cfg.Progress.Done(progress.OutcomeSkipped, size)
+		return nil
+	}
+	cfg.Progress.Done(outcome, size)
+	if cfg.Summary != nil {
+		cfg.Summary.Add(outcome, size)
+	}
+	return nil
+}
+
+// probeFile returns the job copying f, or the outcome of f if it isn't
+// copied. A nil job and an empty outcome are returned if f was skipped.
+// Errors specific to f are returned as a *summary.Error.
+func probeFile(ctx context.Context, cfg CopierConfig, logger *logwrap.LogWrap, f file.File) (*Job, string, error) {
+	release, err := cfg.Limiter.Acquire(ctx, f.OriginalFilePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release()
+
+	destinationDir := cfg.DestinationDir
+	supported, err := f.IsSupported()
+	if err != nil && err != fileutil.ErrUnknownFileType {
+		return nil, "", summary.Wrap(summary.ClassRead, f.OriginalFilePath, err)
+	}
+	if !supported {
+		// With KeepGoing, files named like photos and videos which aren't
+		// are reported, other files are skipped.
+		if cfg.KeepGoing && file.HasMediaExtension(f.OriginalFilePath) {
+			return nil, "", summary.Wrap(summary.ClassUnsupported, f.OriginalFilePath, fileutil.ErrUnknownFileType)
+		}
+		return nil, "", nil
+	}
+
+	if !cfg.Filter.MatchMediaType(f.MediaType()) {
+		logger.Debug("other media type, not copying", "path", f.OriginalFilePath)
+		return nil, "", nil
+	}
+
+	deviceName := cfg.DeviceRules.DeviceName(f)
+	if cfg.Location != nil {
+		f = f.WithOptions(file.WithLocation(cfg.Location))
+	} else if loc, ok := cfg.DeviceLocations[deviceName]; ok {
+		f = f.WithOptions(file.WithLocation(loc))
+	}
+	timestamp, timestampSource := f.TimestampWithSource()
+	if timestampSource == file.TimestampSourceNautical {
+		logger.Warning("timezone derived from the longitude, the location is far from a known city", "path", f.OriginalFilePath, "timezone", timestamp.Location().String())
+	}
+
+	// A file whose metadata can't be read would be archived by its
+	// modification time, which is rarely its capture time. With KeepGoing
+	// it is reported instead so it can be looked at and retried.
+	if cfg.KeepGoing && timestampSource == file.TimestampSourceModTime {
+		if err := f.MetadataErr(); err != nil {
+			return nil, "", summary.Wrap(summary.ClassMetadata, f.OriginalFilePath, err)
+		}
+	}
+
+	clockOffset := cfg.TimeShift + cfg.ClockOffsets.Offset(deviceName, timestamp)
+	timestamp = timestamp.Add(clockOffset)
+	if !cfg.Filter.MatchTime(timestamp) {
+		logger.Debug("captured outside the time range, not copying", "path", f.OriginalFilePath, "timestamp", timestamp)
+		return nil, "", nil
+	}
+	destinationFilePath := f.DestinationFilePath(destinationDir, deviceName, timestamp, file.WithPathLayout(cfg.PathLayout))
+
+	// Find files within destinationDir/deviceName that match the
+	// destinationFilePath. If there are duplicates, check the size of the
+	// file and if they match, skip copying this file.
+	if cfg.CheckDuplicates {
+
+		// yearMonth is a slice of the year and month from the
+		// destinationFilePath. This will be used by monthDir to build the
+		// directory path where the walker will scan for duplicate
+		// files.
+		yearMonth := strings.Split(
+			store.RelPath(destinationDir, filepath.Dir(destinationFilePath)),
+			string(os.PathSeparator))
+		if len(yearMonth) > 2 {
+			yearMonth = yearMonth[:2]
+		}
+		monthDir := filepath.Join(destinationDir, filepath.Join(yearMonth...))
+
+		// Walk the monthDir path looking for files that match the
+		// destinationFilePath filename and size of the file to copy. The
+		// monthDir doesn't exist until the first file of the month is
+		// copied, read errors are skipped.
+		duplicatePath := ""
+		err := walk.New(walk.Options{}).Walk(monthDir, func(p string, info os.FileInfo) error {
+			if filepath.Base(p) == filepath.Base(destinationFilePath) {
+				if info.Size() == f.FileInfo.Size() {
+					duplicatePath = p
+					logger.Debug("duplicate found, not copying", "path", f.OriginalFilePath, "duplicate", p)
 					return nil
 				}
-				if filepath.Base(p) == filepath.Base(destinationFilePath) {
-					if info.Size() == f.FileInfo.Size() {
-						duplicateFound = true
-						logger.Debug(fmt.Sprintf("duplicate found, not copying: %s, %s", f.OriginalFilePath, p))
-						return nil
-					}
-				}
-				return nil
-			})
-			if err != nil {
-				return err
 			}
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		if duplicatePath != "" {
+			cfg.Events.Emit(eventstream.Event{Type: eventstream.DuplicateSkipped, Path: f.OriginalFilePath, Destination: duplicatePath, Size: f.FileInfo.Size(), Reason: OutcomeDuplicate})
+			return nil, OutcomeDuplicate, nil
+		}
+	}
+
+	meta := map[string]string{
+		store.MetaTimestamp:       timestamp.Format(store.TimeFormat),
+		store.MetaTimestampSource: string(timestampSource),
+		store.MetaDevice:          deviceName,
+		store.MetaAlbum:           f.Album(),
+		store.MetaSourcePath:      f.OriginalFilePath,
+	}
+	if clockOffset != 0 {
+		meta[store.MetaClockOffset] = clockOffset.String()
+	}
+	if p, ok := f.GPS(); ok {
+		for k, v := range store.LocationMeta(geo.Default(), p) {
+			meta[k] = v
+		}
+	}
+	return &Job{File: f, DestinationFilePath: destinationFilePath, Meta: meta}, "", nil
+}
+
+// copyFile copies the file of job, returning its outcome. Errors specific to
+// the file are returned as a *summary.Error.
+func copyFile(ctx context.Context, cfg CopierConfig, logger *logwrap.LogWrap, job Job) (string, error) {
+	f := job.File
+	release, err := cfg.Limiter.Acquire(ctx, f.OriginalFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	// The copy is hashed as it is written rather than read again.
+	hash, err := fileutil.CopyAndHash(f.OriginalFilePath, job.DestinationFilePath, 2048*1024)
+	release()
+	logger.Debug("copy finished", "path", f.OriginalFilePath, "destination", job.DestinationFilePath, "error", err)
+	if err == fileutil.ErrFileExists {
+		cfg.Events.Emit(eventstream.Event{Type: eventstream.DuplicateSkipped, Path: f.OriginalFilePath, Destination: job.DestinationFilePath, Size: f.FileInfo.Size(), Reason: OutcomeExists})
+		return OutcomeExists, nil
+	}
+	if err != nil {
+		// Errors naming the source, or a source given up on after
+		// retries, are read errors, anything else is a problem with the
+		// destination.
+		var pathErr *fs.PathError
+		if errors.Is(err, fileutil.ErrBadSource) || errors.As(err, &pathErr) && pathErr.Path == f.OriginalFilePath {
+			return "", summary.Wrap(summary.ClassRead, f.OriginalFilePath, err)
+		}
+		return "", summary.Wrap(summary.ClassDestination, f.OriginalFilePath, err)
+	}
+
+	// The copy is recorded even if ctx was cancelled while it was written,
+	// so the database matches the destination.
+	if cfg.DB != nil {
+		if err := record(context.Background(), cfg.DB, cfg.DestinationDir, job.DestinationFilePath, hash, job.Meta); err != nil {
+			return "", err
 		}
+	}
+	cfg.Events.Emit(eventstream.Event{Type: eventstream.FileCopied, Path: f.OriginalFilePath, Destination: job.DestinationFilePath, Size: f.FileInfo.Size(), Hash: hash})
+	if !cfg.RemoveSource {
+		return OutcomeCopied, nil
+	}
+	if err := removeSource(logger, job, hash); err != nil {
+		// The file was copied, it is left in the source to be removed
+		// by hand.
+		logger.Error("removing source after copy", "path", f.OriginalFilePath, "destination", job.DestinationFilePath, "error", err)
+		return OutcomeCopied, nil
+	}
+	return OutcomeMoved, nil
+}
+
+// removeSource removes the source of job, copied with hash, along with its
+// sidecars, which are copied next to the destination first. The source is
+// kept if the destination doesn't have its size and hash, or a sidecar can't
+// be copied. A sidecar of other files in the source, such as the other file
+// of a RAW+JPEG pair, is kept until the last of them is removed.
+func removeSource(logger *logwrap.LogWrap, job Job, hash string) error {
+	src, dst := job.File.OriginalFilePath, job.DestinationFilePath
+	info, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if info.Size() != job.File.FileInfo.Size() {
+		return fmt.Errorf("%s is %d bytes, the source is %d", dst, info.Size(), job.File.FileInfo.Size())
+	}
+	if err := checkHash(dst, hash); err != nil {
+		return err
+	}
 
-		// Copy the file only if a duplicate is not found (and check for
-		// duplicates has been set).
-		if !duplicateFound {
-			err = fileutil.Copy(f.OriginalFilePath, destinationFilePath, 2048*1024)
-			logger.Debug(fmt.Sprintf("copied %s to %s: %v", f.OriginalFilePath, destinationFilePath, err))
-			if err != nil && err != fileutil.ErrFileExists {
+	sidecars, err := file.Sidecars(src)
+	if err != nil {
+		return err
+	}
+	for _, sidecar := range sidecars {
+		sidecarDst := file.SidecarDestination(src, sidecar, dst)
+		sidecarHash, err := fileutil.CopyAndHash(sidecar, sidecarDst, 2048*1024)
+		// A sidecar shared with a file copied earlier is already there.
+		if err == fileutil.ErrFileExists {
+			if sidecarHash, err = fileutil.GetFileHash(sidecar); err != nil {
 				return err
 			}
+		} else if err != nil {
+			return err
+		}
+		if err := checkHash(sidecarDst, sidecarHash); err != nil {
+			return err
 		}
 	}
+
+	if err := os.Remove(src); err != nil {
+		return err
+	}
+	for _, sidecar := range sidecars {
+		owners, err := file.SidecarOwners(sidecar)
+		if err == nil && len(owners) == 0 {
+			err = os.Remove(sidecar)
+		}
+		if err != nil {
+			logger.Error("removing sidecar after copy", "path", sidecar, "error", err)
+		}
+	}
+	return nil
+}
+
+// checkHash returns an error if the file at p doesn't have hash.
+func checkHash(p, hash string) error {
+	got, err := fileutil.GetFileHash(p)
+	if err != nil {
+		return err
+	}
+	if got != hash {
+		return fmt.Errorf("%s doesn't match the source, its hash is %s rather than %s", p, got, hash)
+	}
 	return nil
 }
+
+// record adds the copied file at destinationFilePath with hash to the hash
+// table along with its meta values.
+func record(ctx context.Context, db *sql.DB, destinationDir, destinationFilePath, hash string, meta map[string]string) error {
+	h, err := store.InsertHash(ctx, db, store.RelPath(destinationDir, destinationFilePath), hash)
+	if err != nil {
+		return err
+	}
+
+	return store.SetMetaMap(ctx, db, h.ID, meta)
+}
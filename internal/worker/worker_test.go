@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"pt/internal/file"
+	"pt/internal/fileutil"
+	"pt/internal/filter"
+	"pt/internal/jpegmeta/jpegmetatest"
+	"pt/internal/logwrap"
+	"pt/internal/summary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyFiles copies the files at paths with cfg, returning the number of files
+// of each outcome and the destination of each file.
+func copyFiles(t *testing.T, cfg CopierConfig, paths ...string) (map[string]int, map[string]string) {
+	t.Helper()
+	logwrap.New("pt", logwrap.Output{W: io.Discard})
+	cfg.Summary = summary.New()
+
+	files := make(chan file.File, len(paths))
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		files <- file.NewFile(p, info)
+	}
+	close(files)
+
+	jobs := make(chan Job, len(paths))
+	require.NoError(t, Prober(context.Background(), cfg, files, jobs))
+	close(jobs)
+	destinations := map[string]string{}
+	planned := make(chan Job, len(paths))
+	for job := range jobs {
+		destinations[job.File.OriginalFilePath] = job.DestinationFilePath
+		planned <- job
+	}
+	close(planned)
+	require.NoError(t, Copier(context.Background(), cfg, planned))
+
+	r := summary.Report{}
+	cfg.Summary.Fill(&r)
+	return r.Counts, destinations
+}
+
+// writeSource writes a JPEG with its XMP sidecar to dir. The JPEG has no
+// capture time, its modification time is used instead.
+func writeSource(t *testing.T, dir string) (string, string) {
+	p, sidecar := filepath.Join(dir, "IMG_0001.JPG"), filepath.Join(dir, "IMG_0001.xmp")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(p, jpegmetatest.JFIF(), 0644))
+	require.NoError(t, os.WriteFile(sidecar, []byte("<x:xmpmeta/>"), 0644))
+	mtime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(p, mtime, mtime))
+	return p, sidecar
+}
+
+func TestCopierRemoveSource(t *testing.T) {
+	dir := t.TempDir()
+	cfg := CopierConfig{DestinationDir: filepath.Join(dir, "photos"), DeviceRules: file.DeviceRules{Fallback: "camera"}, RemoveSource: true}
+
+	p, sidecar := writeSource(t, filepath.Join(dir, "card"))
+	counts, destinations := copyFiles(t, cfg, p)
+	assert.Equal(t, map[string]int{OutcomeMoved: 1}, counts)
+	assert.NoFileExists(t, p)
+	assert.NoFileExists(t, sidecar)
+	dst := destinations[p]
+	assert.FileExists(t, dst)
+	buf, err := os.ReadFile(file.SidecarDestination(p, sidecar, dst))
+	require.NoError(t, err)
+	assert.Equal(t, "<x:xmpmeta/>", string(buf))
+
+	// The file is already in the destination, it isn't copied so the
+	// source is kept.
+	p, sidecar = writeSource(t, filepath.Join(dir, "card"))
+	counts, _ = copyFiles(t, cfg, p)
+	assert.Equal(t, map[string]int{OutcomeExists: 1}, counts)
+	assert.FileExists(t, p)
+	assert.FileExists(t, sidecar)
+
+	// A file of the same name and size in the destination month is a
+	// duplicate, it isn't copied so the source is kept.
+	cfg.CheckDuplicates = true
+	p, sidecar = writeSource(t, filepath.Join(dir, "other-card"))
+	counts, _ = copyFiles(t, cfg, p)
+	assert.Equal(t, map[string]int{OutcomeDuplicate: 1}, counts)
+	assert.FileExists(t, p)
+	assert.FileExists(t, sidecar)
+}
+
+func TestRemoveSource(t *testing.T) {
+	logger := logwrap.New("pt", logwrap.Output{W: io.Discard})
+	dir := t.TempDir()
+	p, sidecar := writeSource(t, filepath.Join(dir, "card"))
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	hash, err := fileutil.GetFileHash(p)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"missing", nil},
+		{"different size", []byte{0xff, 0xd8}},
+		{"different hash", make([]byte, info.Size())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(dir, "photos", tt.name+".JPG")
+			if tt.content != nil {
+				require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0755))
+				require.NoError(t, os.WriteFile(dst, tt.content, 0644))
+			}
+			err := removeSource(logger, Job{File: file.NewFile(p, info), DestinationFilePath: dst}, hash)
+			assert.Error(t, err)
+			assert.FileExists(t, p)
+			assert.FileExists(t, sidecar)
+		})
+	}
+
+	// The sidecar is shared with the other file of a RAW+JPEG pair, it is
+	// copied and kept for it.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "card", "IMG_0001.CR2"), nil, 0644))
+	dst := filepath.Join(dir, "photos", "20220102-030405000.JPG")
+	require.NoError(t, os.WriteFile(dst, jpegmetatest.JFIF(), 0644))
+	require.NoError(t, removeSource(logger, Job{File: file.NewFile(p, info), DestinationFilePath: dst}, hash))
+	assert.NoFileExists(t, p)
+	assert.FileExists(t, sidecar)
+	assert.FileExists(t, filepath.Join(dir, "photos", "20220102-030405000.xmp"))
+}
+
+func TestProberMediaType(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := writeSource(t, filepath.Join(dir, "card"))
+
+	cfg := CopierConfig{DestinationDir: filepath.Join(dir, "photos"), Filter: filter.Filter{MediaTypes: []file.MediaType{file.MediaTypeVideo}}}
+	counts, destinations := copyFiles(t, cfg, p)
+	assert.Empty(t, counts)
+	assert.Empty(t, destinations)
+
+	cfg.Filter.MediaTypes = []file.MediaType{file.MediaTypePhoto}
+	counts, _ = copyFiles(t, cfg, p)
+	assert.Equal(t, map[string]int{OutcomeCopied: 1}, counts)
+}
@@ -0,0 +1,564 @@
+// Package xmp reads and writes the XMP properties managed by pt, either as an
+// XMP sidecar file or as a packet embedded in a JPEG.
+//
+// Only the properties in Properties are understood. Update replaces them in
+// an existing packet, keeping the properties written by other programs (eg;
+// develop settings, ratings and IPTC fields).
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"pt/internal/fileutil"
+	"pt/internal/geo"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Namespaces of the properties in Properties.
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsExif      = "http://ns.adobe.com/exif/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+)
+
+// timeFormat is the ISO 8601 format of XMP dates.
+const timeFormat = "2006-01-02T15:04:05.000-07:00"
+
+// Properties are the XMP properties managed by pt.
+type Properties struct {
+	// DateTimeOriginal is written as exif:DateTimeOriginal and
+	// photoshop:DateCreated.
+	DateTimeOriginal time.Time
+
+	// GPS is written as exif:GPSLatitude and exif:GPSLongitude.
+	GPS *geo.Point
+
+	// Description is written as dc:description.
+	Description string
+
+	// Keywords are written as dc:subject.
+	Keywords []string
+}
+
+// IsZero checks if no properties are set.
+func (p Properties) IsZero() bool {
+	return p.DateTimeOriginal.IsZero() && p.GPS == nil && p.Description == "" && len(p.Keywords) == 0
+}
+
+// Merge returns p with the properties set in o replacing those of p.
+// Keywords in o are added to those of p.
+func (p Properties) Merge(o Properties) Properties {
+	if !o.DateTimeOriginal.IsZero() {
+		p.DateTimeOriginal = o.DateTimeOriginal
+	}
+	if o.GPS != nil {
+		p.GPS = o.GPS
+	}
+	if o.Description != "" {
+		p.Description = o.Description
+	}
+
+	keywords := []string{}
+	seen := map[string]bool{}
+	for _, k := range append(append([]string{}, p.Keywords...), o.Keywords...) {
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		keywords = append(keywords, k)
+	}
+	p.Keywords = keywords
+
+	return p
+}
+
+// Parse reads the properties from an XMP packet. Properties may be written
+// either as attributes of rdf:Description or as elements.
+func Parse(r io.Reader) (Properties, error) {
+	p := Properties{}
+	d := xml.NewDecoder(r)
+
+	var lat, lon string
+	// stack holds the names of the open elements.
+	stack := []xml.Name{}
+	setProperty := func(name xml.Name, value string) error {
+		value = strings.TrimSpace(value)
+		switch {
+		case name.Space == nsExif && name.Local == "DateTimeOriginal",
+			name.Space == nsPhotoshop && name.Local == "DateCreated" && p.DateTimeOriginal.IsZero():
+			t, err := parseTime(value)
+			if err != nil {
+				return err
+			}
+			p.DateTimeOriginal = t
+		case name.Space == nsExif && name.Local == "GPSLatitude":
+			lat = value
+		case name.Space == nsExif && name.Local == "GPSLongitude":
+			lon = value
+		}
+		return nil
+	}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			if t.Name.Space == nsRDF && t.Name.Local == "Description" {
+				for _, a := range t.Attr {
+					if err := setProperty(a.Name, a.Value); err != nil {
+						return p, err
+					}
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) == 0 || strings.TrimSpace(string(t)) == "" {
+				continue
+			}
+			// Values of dc:description and dc:subject are held in rdf:li
+			// elements within a rdf:Alt or rdf:Bag.
+			if len(stack) >= 3 && stack[len(stack)-1].Local == "li" {
+				switch property := stack[len(stack)-3]; {
+				case property.Space == nsDC && property.Local == "description":
+					p.Description = strings.TrimSpace(string(t))
+				case property.Space == nsDC && property.Local == "subject":
+					p.Keywords = append(p.Keywords, strings.TrimSpace(string(t)))
+				}
+				continue
+			}
+			if err := setProperty(stack[len(stack)-1], string(t)); err != nil {
+				return p, err
+			}
+		}
+	}
+
+	if lat != "" && lon != "" {
+		latitude, err := parseCoordinate(lat)
+		if err != nil {
+			return p, err
+		}
+		longitude, err := parseCoordinate(lon)
+		if err != nil {
+			return p, err
+		}
+		p.GPS = &geo.Point{Latitude: latitude, Longitude: longitude}
+	}
+
+	return p, nil
+}
+
+var packetTemplate = template.Must(template.New("xmp").Funcs(template.FuncMap{
+	"escape": func(s string) (string, error) {
+		var b bytes.Buffer
+		err := xml.EscapeText(&b, []byte(s))
+		return b.String(), err
+	},
+}).Parse(`{{define "description"}}  <rdf:Description rdf:about="{{escape .About}}"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+{{- if .DateTimeOriginal}}
+    exif:DateTimeOriginal="{{.DateTimeOriginal}}"
+    photoshop:DateCreated="{{.DateTimeOriginal}}"
+{{- end}}
+{{- if .GPSLatitude}}
+    exif:GPSLatitude="{{.GPSLatitude}}"
+    exif:GPSLongitude="{{.GPSLongitude}}"
+{{- end}}>
+{{- if .Description}}
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">{{escape .Description}}</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+{{- end}}
+{{- if .Keywords}}
+   <dc:subject>
+    <rdf:Bag>
+{{- range .Keywords}}
+     <rdf:li>{{escape .}}</rdf:li>
+{{- end}}
+    </rdf:Bag>
+   </dc:subject>
+{{- end}}
+  </rdf:Description>
+{{end}}<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="pt">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+{{template "description" .}} </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`))
+
+// packetData is the data of packetTemplate.
+type packetData struct {
+	About            string
+	DateTimeOriginal string
+	GPSLatitude      string
+	GPSLongitude     string
+	Description      string
+	Keywords         []string
+}
+
+// data returns p as packetData.
+func (p Properties) data() packetData {
+	data := packetData{
+		Description: p.Description,
+		Keywords:    p.Keywords,
+	}
+	if !p.DateTimeOriginal.IsZero() {
+		data.DateTimeOriginal = p.DateTimeOriginal.Format(timeFormat)
+	}
+	if p.GPS != nil {
+		data.GPSLatitude = formatCoordinate(p.GPS.Latitude, "N", "S")
+		data.GPSLongitude = formatCoordinate(p.GPS.Longitude, "E", "W")
+	}
+	return data
+}
+
+// Marshal returns p as an XMP packet.
+func (p Properties) Marshal() ([]byte, error) {
+	var b bytes.Buffer
+	if err := packetTemplate.Execute(&b, p.data()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// managed returns true if name is a property of Properties.
+func managed(name xml.Name) bool {
+	switch name.Space {
+	case nsExif:
+		return name.Local == "DateTimeOriginal" || name.Local == "GPSLatitude" || name.Local == "GPSLongitude"
+	case nsPhotoshop:
+		return name.Local == "DateCreated"
+	case nsDC:
+		return name.Local == "description" || name.Local == "subject"
+	}
+	return false
+}
+
+// edit replaces the bytes of a packet from start to end with text.
+type edit struct {
+	start, end int
+	text       []byte
+}
+
+// attrPattern matches a prefixed attribute of a start tag.
+var attrPattern = regexp.MustCompile(`\s+([\w.-]+):([\w.-]+)\s*=\s*("[^"]*"|'[^']*')`)
+
+// Update returns packet with its properties of Properties replaced by p.
+// Every other element and attribute of packet is kept as it is. The
+// properties of p are written as a rdf:Description of their own at the end
+// of rdf:RDF. The packet of Marshal is returned if packet is empty.
+func (p Properties) Update(packet []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(packet)) == 0 {
+		return p.Marshal()
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	// scopes holds the namespace prefixes declared by each open element,
+	// and stack their names. RawToken is used as Token doesn't report the
+	// offsets of prefixes within start tags.
+	scopes := []map[string]string{}
+	stack := []xml.Name{}
+	resolve := func(prefix string) string {
+		if prefix == "xml" {
+			return "http://www.w3.org/XML/1998/namespace"
+		}
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if ns, ok := scopes[i][prefix]; ok {
+				return ns
+			}
+		}
+		return prefix
+	}
+
+	edits := []edit{}
+	// descriptions are the open rdf:Description elements.
+	type description struct {
+		start, edit, depth int
+		// kept is set if the description holds properties that aren't
+		// removed.
+		kept bool
+	}
+	descriptions := []*description{}
+	about := ""
+	rdfEnd := -1
+	// removeFrom is the offset of the managed property being removed, and
+	// removeDepth the depth of its element.
+	removeFrom, removeDepth := -1, 0
+	for {
+		start := int(d.InputOffset())
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end := int(d.InputOffset())
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := map[string]string{}
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					scope[a.Name.Local] = a.Value
+				} else if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					scope[""] = a.Value
+				}
+			}
+			scopes = append(scopes, scope)
+			name := xml.Name{Space: resolve(t.Name.Space), Local: t.Name.Local}
+			parent := xml.Name{}
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, name)
+
+			// Only the properties of the descriptions of rdf:RDF are
+			// changed, not those of structures within other properties.
+			if n := len(descriptions); removeFrom < 0 && n > 0 && descriptions[n-1].depth == len(stack)-1 {
+				if managed(name) {
+					removeFrom, removeDepth = start, len(stack)
+				} else {
+					descriptions[n-1].kept = true
+				}
+			}
+			if parent.Space == nsRDF && parent.Local == "RDF" && name.Space == nsRDF && name.Local == "Description" {
+				desc := &description{start: start, edit: len(edits), depth: len(stack)}
+				descriptions = append(descriptions, desc)
+				for _, a := range t.Attr {
+					attr := xml.Name{Space: resolve(a.Name.Space), Local: a.Name.Local}
+					switch {
+					case attr.Space == nsRDF && attr.Local == "about":
+						about = a.Value
+					case a.Name.Space == "xmlns", a.Name.Space == "" && a.Name.Local == "xmlns":
+					case !managed(attr):
+						desc.kept = true
+					}
+				}
+				tag := attrPattern.ReplaceAllFunc(packet[start:end], func(attr []byte) []byte {
+					m := attrPattern.FindSubmatch(attr)
+					if managed(xml.Name{Space: resolve(string(m[1])), Local: string(m[2])}) {
+						return nil
+					}
+					return attr
+				})
+				edits = append(edits, edit{start, end, tag})
+			}
+		case xml.CharData:
+			if n := len(descriptions); n > 0 && descriptions[n-1].depth == len(stack) && len(bytes.TrimSpace(t)) > 0 {
+				descriptions[n-1].kept = true
+			}
+		case xml.EndElement:
+			name := xml.Name{Space: resolve(t.Name.Space), Local: t.Name.Local}
+			if removeFrom >= 0 && len(stack) == removeDepth {
+				edits = append(edits, edit{spaceBefore(packet, removeFrom), end, nil})
+				removeFrom = -1
+			}
+			// A description left without properties is removed.
+			if n := len(descriptions); n > 0 && descriptions[n-1].depth == len(stack) {
+				desc := descriptions[n-1]
+				descriptions = descriptions[:n-1]
+				if !desc.kept {
+					edits = append(edits[:desc.edit], edit{spaceBefore(packet, desc.start), end, nil})
+				}
+			}
+			if name.Space == nsRDF && name.Local == "RDF" && rdfEnd < 0 {
+				rdfEnd = lineStart(packet, start)
+			}
+			if len(stack) > 0 {
+				stack, scopes = stack[:len(stack)-1], scopes[:len(scopes)-1]
+			}
+		}
+	}
+	if rdfEnd < 0 {
+		return nil, errors.New("XMP packet has no rdf:RDF")
+	}
+
+	if !p.IsZero() {
+		data := p.data()
+		data.About = about
+		var b bytes.Buffer
+		if err := packetTemplate.ExecuteTemplate(&b, "description", data); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit{rdfEnd, rdfEnd, b.Bytes()})
+	}
+
+	var b bytes.Buffer
+	offset := 0
+	for _, e := range edits {
+		b.Write(packet[offset:e.start])
+		b.Write(e.text)
+		offset = e.end
+	}
+	b.Write(packet[offset:])
+	return b.Bytes(), nil
+}
+
+// spaceBefore returns offset moved back over the whitespace before it, which
+// goes with an element removed from offset.
+func spaceBefore(packet []byte, offset int) int {
+	for offset > 0 && strings.ContainsRune(" \t\r\n", rune(packet[offset-1])) {
+		offset--
+	}
+	return offset
+}
+
+// lineStart returns the start of the line of offset if only spaces precede
+// it on its line, otherwise offset.
+func lineStart(packet []byte, offset int) int {
+	i := offset
+	for i > 0 && (packet[i-1] == ' ' || packet[i-1] == '\t') {
+		i--
+	}
+	if i == 0 || packet[i-1] == '\n' {
+		return i
+	}
+	return offset
+}
+
+// SidecarPath returns the path of the XMP sidecar of p, p with its extension
+// replaced (eg; IMG_0001.xmp for IMG_0001.CR3). When another file has the
+// same name, such as the JPEG of a RAW+JPEG pair, the sidecar is p with .xmp
+// added (IMG_0001.CR3.xmp) so the files don't share one.
+func SidecarPath(p string) string {
+	if _, err := os.Stat(p + ".xmp"); err == nil || shared(p) {
+		return p + ".xmp"
+	}
+	return strings.TrimSuffix(p, filepath.Ext(p)) + ".xmp"
+}
+
+// FindSidecar returns the path of the existing XMP sidecar of p, as
+// SidecarPath names it, and false if p has none.
+func FindSidecar(p string) (string, bool) {
+	if _, err := os.Stat(p + ".xmp"); err == nil {
+		return p + ".xmp", true
+	}
+	stemPath := strings.TrimSuffix(p, filepath.Ext(p)) + ".xmp"
+	if _, err := os.Stat(stemPath); err != nil || shared(p) {
+		return "", false
+	}
+	return stemPath, true
+}
+
+// shared returns true if another file than p and its sidecars (as
+// fileutil.IsSidecar matches them) has the name of p without its extension,
+// ignoring case.
+func shared(p string) bool {
+	entries, err := os.ReadDir(filepath.Dir(p))
+	if err != nil {
+		return false
+	}
+	base := filepath.Base(p)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	for _, e := range entries {
+		name := e.Name()
+		if fileutil.IsSidecar(name) {
+			continue
+		}
+		if name != base && strings.EqualFold(strings.TrimSuffix(name, filepath.Ext(name)), stem) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSidecar reads the properties of the XMP sidecar at p. Empty properties
+// are returned if p does not exist.
+func ReadSidecar(p string) (Properties, error) {
+	fh, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return Properties{}, nil
+	}
+	if err != nil {
+		return Properties{}, err
+	}
+	defer fh.Close()
+	return Parse(fh)
+}
+
+// parseTime parses an XMP date which may leave out the time, seconds or
+// timezone.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04",
+		"2006-01-02",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid XMP date: %q", s)
+}
+
+// formatCoordinate formats decimal degrees as an XMP GPS coordinate of
+// degrees, decimal minutes and direction (eg; 37,48.500000N).
+func formatCoordinate(degrees float64, positive, negative string) string {
+	direction := positive
+	if degrees < 0 {
+		direction = negative
+		degrees = -degrees
+	}
+	whole := math.Floor(degrees)
+	return fmt.Sprintf("%d,%.6f%s", int(whole), (degrees-whole)*60, direction)
+}
+
+// parseCoordinate parses an XMP GPS coordinate in the form of either
+// "DDD,MM,SSk" or "DDD,MM.mmk" as decimal degrees.
+func parseCoordinate(s string) (float64, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid XMP coordinate: %q", s)
+	}
+	direction := strings.ToUpper(s[len(s)-1:])
+	parts := strings.Split(s[:len(s)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid XMP coordinate: %q", s)
+	}
+
+	degrees := 0.0
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid XMP coordinate: %q", s)
+		}
+		degrees += v / math.Pow(60, float64(i))
+	}
+
+	switch direction {
+	case "S", "W":
+		degrees = -degrees
+	case "N", "E":
+	default:
+		return 0, fmt.Errorf("invalid XMP coordinate: %q", s)
+	}
+	return degrees, nil
+}
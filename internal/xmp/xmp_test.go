@@ -0,0 +1,159 @@
+package xmp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"pt/internal/geo"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalParse(t *testing.T) {
+	p := Properties{
+		DateTimeOriginal: time.Date(1987, 6, 1, 12, 30, 0, 0, time.FixedZone("", 10*60*60)),
+		GPS:              &geo.Point{Latitude: -37.8136, Longitude: 144.9631},
+		Description:      "Nan & Pop's <50th>",
+		Keywords:         []string{"family", "scanned"},
+	}
+
+	buf, err := p.Marshal()
+	require.NoError(t, err)
+
+	parsed, err := Parse(bytes.NewReader(buf))
+	require.NoError(t, err)
+
+	assert.True(t, p.DateTimeOriginal.Equal(parsed.DateTimeOriginal))
+	assert.InDelta(t, p.GPS.Latitude, parsed.GPS.Latitude, 0.000001)
+	assert.InDelta(t, p.GPS.Longitude, parsed.GPS.Longitude, 0.000001)
+	assert.Equal(t, p.Description, parsed.Description)
+	assert.Equal(t, p.Keywords, parsed.Keywords)
+}
+
+func TestParseElements(t *testing.T) {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <exif:DateTimeOriginal>2022-01-30T16:00:01</exif:DateTimeOriginal>
+   <exif:GPSLatitude>37,48,30N</exif:GPSLatitude>
+   <exif:GPSLongitude>122,25.5W</exif:GPSLongitude>
+   <dc:subject><rdf:Bag><rdf:li>beach</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+	p, err := Parse(strings.NewReader(packet))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 30, 16, 0, 1, 0, time.UTC), p.DateTimeOriginal)
+	assert.InDelta(t, 37.808333, p.GPS.Latitude, 0.000001)
+	assert.InDelta(t, -122.425, p.GPS.Longitude, 0.000001)
+	assert.Equal(t, []string{"beach"}, p.Keywords)
+}
+
+func TestMerge(t *testing.T) {
+	p := Properties{Description: "a", Keywords: []string{"x"}}.Merge(Properties{Keywords: []string{"x", "y"}})
+	assert.Equal(t, "a", p.Description)
+	assert.Equal(t, []string{"x", "y"}, p.Keywords)
+}
+
+// lightroom is a sidecar written by another program, with properties pt
+// doesn't manage as attributes and elements.
+const lightroom = `<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="Adobe XMP Core 7.0">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:crs="http://ns.adobe.com/camera-raw-settings/1.0/"
+    xmlns:ex="http://ns.adobe.com/exif/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmp:Rating="4"
+    xmp:Label="Red"
+    crs:Exposure2012="+0.35"
+    ex:DateTimeOriginal="2022-01-30T16:00:01">
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>beach</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <crs:ToneCurvePV2012>
+    <rdf:Seq>
+     <rdf:li>0, 0</rdf:li>
+     <rdf:li>255, 255</rdf:li>
+    </rdf:Seq>
+   </crs:ToneCurvePV2012>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+`
+
+func TestUpdate(t *testing.T) {
+	existing, err := Parse(strings.NewReader(lightroom))
+	require.NoError(t, err)
+	p := existing.Merge(Properties{
+		DateTimeOriginal: time.Date(2022, 1, 30, 17, 0, 1, 0, time.UTC),
+		Description:      "Swim",
+		Keywords:         []string{"summer"},
+	})
+
+	buf, err := p.Update([]byte(lightroom))
+	require.NoError(t, err)
+	packet := string(buf)
+	for _, foreign := range []string{
+		`xmp:Rating="4"`,
+		`xmp:Label="Red"`,
+		`crs:Exposure2012="+0.35"`,
+		"   <crs:ToneCurvePV2012>\n    <rdf:Seq>\n     <rdf:li>0, 0</rdf:li>",
+	} {
+		assert.Contains(t, packet, foreign)
+	}
+	// The properties of pt are written once, in their own description.
+	assert.NotContains(t, packet, `ex:DateTimeOriginal`)
+	assert.Equal(t, 1, strings.Count(packet, "<dc:subject>"))
+	assert.Equal(t, 2, strings.Count(packet, "<rdf:Description"))
+
+	parsed, err := Parse(bytes.NewReader(buf))
+	require.NoError(t, err)
+	assert.True(t, p.DateTimeOriginal.Equal(parsed.DateTimeOriginal))
+	assert.Equal(t, "Swim", parsed.Description)
+	assert.Equal(t, []string{"beach", "summer"}, parsed.Keywords)
+
+	// Updating again changes nothing.
+	again, err := p.Update(buf)
+	require.NoError(t, err)
+	assert.Equal(t, packet, string(again))
+
+	_, err = p.Update([]byte("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"/>"))
+	assert.Error(t, err)
+}
+
+func TestSidecarPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"IMG_0001.CR3", "IMG_0001.JPG", "IMG_0002.CR3", "IMG_0002.xmp"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		found   string
+		isFound bool
+	}{
+		{name: "IMG_0001.CR3", want: "IMG_0001.CR3.xmp"},
+		{name: "IMG_0001.JPG", want: "IMG_0001.JPG.xmp"},
+		{name: "IMG_0002.CR3", want: "IMG_0002.xmp", found: "IMG_0002.xmp", isFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, tt.name)
+			assert.Equal(t, filepath.Join(dir, tt.want), SidecarPath(p))
+			found, ok := FindSidecar(p)
+			assert.Equal(t, tt.isFound, ok)
+			if ok {
+				assert.Equal(t, filepath.Join(dir, tt.found), found)
+			}
+		})
+	}
+}